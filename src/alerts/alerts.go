@@ -0,0 +1,329 @@
+package alerts
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ingester/config"
+	"ingester/db"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultThresholds are the percentages of a budget that trigger an alert when none are configured.
+var defaultThresholds = []float64{80, 100}
+
+// maxBudgetNameLength is the size of the budget column of the alert table.
+const maxBudgetNameLength = 100
+
+var (
+	budgets            []*budget                                // budgets holds the configured budgets and their alert state.
+	webhooks           []webhook                                // webhooks holds the configured notification targets.
+	evaluationInterval time.Duration                            // evaluationInterval is how often the budgets are evaluated.
+	httpClient         = &http.Client{Timeout: 5 * time.Second} // httpClient is the HTTP client used to send the notifications.
+	stateMu            sync.Mutex                               // stateMu guards the alert state of the budgets.
+)
+
+// notifier sends the alerts of the budgets to the webhooks and persists the notified thresholds
+// of a budget with saveState.
+type notifier struct {
+	webhooks  []webhook
+	saveState func(budget string, thresholds []float64) error
+}
+
+// budget is a limit on the usage cost of the matching records over a rolling window.
+type budget struct {
+	name       string
+	filter     db.DataFilter
+	window     time.Duration
+	windowText string
+	amount     float64
+	thresholds []float64
+
+	// alerted holds the thresholds that were crossed and notified, so each crossing is only
+	// notified once until the spend falls back below the threshold.
+	alerted map[float64]bool
+	// unsaved is set while alerted differs from the persisted alert state.
+	unsaved bool
+	// undelivered holds the alerts that a webhook, by its index, has not accepted yet. They are
+	// sent again at the next evaluations, until a newer alert of the budget replaces them.
+	undelivered map[int][]Alert
+}
+
+// Alert describes a budget threshold that was crossed, or the spend falling back below every threshold.
+type Alert struct {
+	Budget          string    `json:"budget"`
+	Status          string    `json:"status"`
+	Threshold       float64   `json:"threshold"`
+	Spend           float64   `json:"spend"`
+	Amount          float64   `json:"amount"`
+	Percent         float64   `json:"percent"`
+	Window          string    `json:"window"`
+	OrgID           int       `json:"orgId,omitempty"`
+	Environment     string    `json:"environment,omitempty"`
+	ApplicationName string    `json:"applicationName,omitempty"`
+	Model           string    `json:"model,omitempty"`
+	Timestamp       time.Time `json:"timestamp"`
+}
+
+// Alert statuses.
+const (
+	StatusTriggered = "triggered"
+	StatusResolved  = "resolved"
+)
+
+// parseWindow parses a window given as a Go duration such as '12h', or as a number of days such as '30d'.
+func parseWindow(value string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid number of days")
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	window, err := time.ParseDuration(value)
+	if err == nil && window <= 0 {
+		err = fmt.Errorf("must be positive")
+	}
+	return window, err
+}
+
+// Init validates and loads the budgets and webhooks. It returns whether any budget is configured.
+func Init(cfg config.Configuration) (bool, error) {
+	evaluationInterval = time.Minute
+	if cfg.Alerts.EvaluationInterval != "" {
+		interval, err := time.ParseDuration(cfg.Alerts.EvaluationInterval)
+		if err != nil || interval <= 0 {
+			return false, fmt.Errorf("'alerts.evaluationInterval' is not a valid duration")
+		}
+		evaluationInterval = interval
+	}
+
+	budgets = nil
+	for i, b := range cfg.Alerts.Budgets {
+		if b.Name == "" {
+			return false, fmt.Errorf("'alerts.budgets[%d].name' is not defined", i)
+		}
+		if len(b.Name) > maxBudgetNameLength {
+			return false, fmt.Errorf("'alerts.budgets[%d].name' must be at most %d characters", i, maxBudgetNameLength)
+		}
+		if b.Amount <= 0 {
+			return false, fmt.Errorf("'alerts.budgets[%d].amount' must be positive", i)
+		}
+		window, err := parseWindow(b.Window)
+		if err != nil {
+			return false, fmt.Errorf("'alerts.budgets[%d].window' must be a duration such as '24h' or '30d'", i)
+		}
+
+		thresholds := append([]float64{}, b.Thresholds...)
+		if len(thresholds) == 0 {
+			thresholds = append(thresholds, defaultThresholds...)
+		}
+		for _, threshold := range thresholds {
+			if threshold <= 0 {
+				return false, fmt.Errorf("'alerts.budgets[%d].thresholds' must be positive percentages", i)
+			}
+		}
+		sort.Float64s(thresholds)
+
+		budgets = append(budgets, &budget{
+			name: b.Name,
+			filter: db.DataFilter{
+				OrgID:           b.OrgID,
+				Environment:     b.Environment,
+				ApplicationName: b.ApplicationName,
+				Model:           b.Model,
+			},
+			window:     window,
+			windowText: b.Window,
+			amount:     b.Amount,
+			thresholds: thresholds,
+			alerted:    map[float64]bool{},
+		})
+	}
+
+	webhooks = nil
+	for i, w := range cfg.Alerts.Webhooks {
+		hook, err := newWebhook(w.URL, w.Format, w.RoutingKey, w.Headers)
+		if err != nil {
+			return false, fmt.Errorf("'alerts.webhooks[%d]': %w", i, err)
+		}
+		webhooks = append(webhooks, hook)
+	}
+
+	if len(budgets) > 0 && len(webhooks) == 0 {
+		log.Warn().Msg("Budgets are configured but no alert webhook is, budget alerts will only be logged")
+	}
+	return len(budgets) > 0, nil
+}
+
+// Start loads the thresholds notified before the ingester started and evaluates the budgets every
+// evaluation interval in the background.
+func Start() {
+	loadState()
+
+	log.Info().Msgf("Evaluating %d budgets every %s", len(budgets), evaluationInterval)
+	go func() {
+		ticker := time.NewTicker(evaluationInterval)
+		defer ticker.Stop()
+
+		for {
+			evaluateBudgets()
+			<-ticker.C
+		}
+	}()
+}
+
+// loadState restores the notified thresholds of the configured budgets from the database. Budgets
+// start without notified thresholds when it cannot be read.
+func loadState() {
+	state, err := db.LoadAlertState()
+	if err != nil {
+		log.Warn().Err(err).Msg("Error loading the alert state, crossed thresholds will be notified again")
+		return
+	}
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	for _, b := range budgets {
+		for _, threshold := range state[b.name] {
+			// Thresholds that are no longer configured are dropped at the next change of the state
+			if slices.Contains(b.thresholds, threshold) {
+				b.alerted[threshold] = true
+			}
+		}
+	}
+}
+
+// evaluateBudgets compares the spend of every budget over its window with its thresholds and
+// notifies the thresholds crossed since the last evaluation.
+func evaluateBudgets() {
+	now := time.Now()
+	n := notifier{webhooks: webhooks, saveState: db.SaveAlertState}
+	for _, b := range budgets {
+		filter := b.filter
+		filter.From = now.Add(-b.window)
+		filter.To = now
+
+		spend, err := db.TotalUsageCost(filter)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error evaluating the budget '%s'", b.name)
+			continue
+		}
+
+		b.update(spend, now, n)
+	}
+}
+
+// update evaluates the budget with its current spend and sends the resulting alerts to every webhook
+// of the notifier. Each webhook that does not accept an alert gets it again at the next evaluations,
+// and the new alert state is only persisted once every webhook accepted the alerts, so they are sent
+// again after a restart.
+func (b *budget) update(spend float64, now time.Time, n notifier) {
+	alerts, alerted := b.evaluate(spend, now)
+	if len(alerts) > 0 {
+		b.undelivered = map[int][]Alert{}
+		for _, alert := range alerts {
+			log.Warn().Msgf("Budget '%s' %s at %.0f%%: spent %.2f of %.2f USD over %s",
+				alert.Budget, alert.Status, alert.Threshold, alert.Spend, alert.Amount, alert.Window)
+		}
+		for i := range n.webhooks {
+			b.undelivered[i] = alerts
+		}
+	}
+
+	for i, hook := range n.webhooks {
+		pending := b.undelivered[i]
+		for len(pending) > 0 && hook.send(pending[0]) == nil {
+			pending = pending[1:]
+		}
+		if len(pending) == 0 {
+			delete(b.undelivered, i)
+		} else {
+			b.undelivered[i] = pending
+		}
+	}
+	saveState := n.saveState
+	if len(b.undelivered) > 0 {
+		saveState = nil
+	}
+	b.setAlerted(alerted, saveState)
+}
+
+// evaluate compares the current spend of the budget with its alert state and returns the alerts to
+// send and the thresholds that are crossed. Only the highest newly crossed threshold is notified, and
+// a resolved alert is sent once the spend falls back below every threshold.
+func (b *budget) evaluate(spend float64, now time.Time) ([]Alert, map[float64]bool) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	percent := spend / b.amount * 100
+	alert := Alert{
+		Budget:          b.name,
+		Spend:           spend,
+		Amount:          b.amount,
+		Percent:         percent,
+		Window:          b.windowText,
+		OrgID:           b.filter.OrgID,
+		Environment:     b.filter.Environment,
+		ApplicationName: b.filter.ApplicationName,
+		Model:           b.filter.Model,
+		Timestamp:       now,
+	}
+
+	var crossed []float64
+	alerted := map[float64]bool{}
+	for _, threshold := range b.thresholds {
+		if percent >= threshold {
+			if !b.alerted[threshold] {
+				crossed = append(crossed, threshold)
+			}
+			alerted[threshold] = true
+		}
+	}
+
+	if len(crossed) > 0 {
+		alert.Status = StatusTriggered
+		alert.Threshold = crossed[len(crossed)-1]
+		return []Alert{alert}, alerted
+	}
+	if len(b.alerted) > 0 && len(alerted) == 0 {
+		alert.Status = StatusResolved
+		alert.Threshold = b.thresholds[0]
+		return []Alert{alert}, alerted
+	}
+	return nil, alerted
+}
+
+// setAlerted replaces the crossed thresholds of the budget. They are persisted with saveState when
+// they changed since they were last persisted, unless saveState is nil.
+func (b *budget) setAlerted(alerted map[float64]bool, saveState func(budget string, thresholds []float64) error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	if !maps.Equal(b.alerted, alerted) {
+		b.alerted = alerted
+		b.unsaved = true
+	}
+	if saveState == nil || !b.unsaved {
+		return
+	}
+
+	var thresholds []float64
+	for threshold := range alerted {
+		thresholds = append(thresholds, threshold)
+	}
+	sort.Float64s(thresholds)
+	if err := saveState(b.name, thresholds); err != nil {
+		log.Error().Err(err).Msgf("Error saving the alert state of budget '%s', crossed thresholds may be notified again after a restart", b.name)
+		return
+	}
+	b.unsaved = false
+}
@@ -0,0 +1,192 @@
+package alerts
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver is a stand-in webhook that records the alerts it receives and responds with its status.
+type receiver struct {
+	mu     sync.Mutex
+	status int
+	alerts []Alert
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var alert Alert
+	json.NewDecoder(req.Body).Decode(&alert)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerts = append(r.alerts, alert)
+	w.WriteHeader(r.status)
+}
+
+// setStatus sets the status of the responses to the next alerts.
+func (r *receiver) setStatus(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+// received returns the alerts received so far.
+func (r *receiver) received() []Alert {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Alert{}, r.alerts...)
+}
+
+// newReceiver starts a receiver for a test and returns it with a generic webhook sending to it.
+func newReceiver(t *testing.T) (*receiver, webhook) {
+	t.Helper()
+	r := &receiver{status: http.StatusOK}
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return r, webhook{url: server.URL, format: FormatGeneric}
+}
+
+// recordingNotifier returns a notifier sending to the given webhooks that records the saved alert
+// state in the returned map.
+func recordingNotifier(hooks ...webhook) (notifier, map[string][]float64) {
+	saved := map[string][]float64{}
+	return notifier{webhooks: hooks, saveState: func(budget string, thresholds []float64) error {
+		saved[budget] = thresholds
+		return nil
+	}}, saved
+}
+
+// testBudget returns a budget of 100 USD with alerts at 50%, 80% and 100%.
+func testBudget() *budget {
+	return &budget{name: "team", window: 24 * time.Hour, windowText: "24h", amount: 100, thresholds: []float64{50, 80, 100}, alerted: map[float64]bool{}}
+}
+
+func TestEvaluateNotifiesHighestCrossedThreshold(t *testing.T) {
+	b := testBudget()
+
+	alerts, alerted := b.evaluate(85, time.Now())
+	if len(alerts) != 1 || alerts[0].Status != StatusTriggered || alerts[0].Threshold != 80 {
+		t.Fatalf("evaluate() = %+v, want a single alert for the 80%% threshold", alerts)
+	}
+	if !alerted[50] || !alerted[80] || alerted[100] {
+		t.Errorf("crossed thresholds = %v, want 50 and 80", alerted)
+	}
+	if len(b.alerted) != 0 {
+		t.Errorf("evaluate() changed the alert state to %v", b.alerted)
+	}
+}
+
+func TestEvaluateSequence(t *testing.T) {
+	r, hook := newReceiver(t)
+	n, saved := recordingNotifier(hook)
+	b := testBudget()
+	now := time.Now()
+
+	for _, spend := range []float64{10, 60, 70, 120, 90, 20, 30} {
+		b.update(spend, now, n)
+	}
+
+	got := r.received()
+	want := []struct {
+		status    string
+		threshold float64
+	}{{StatusTriggered, 50}, {StatusTriggered, 100}, {StatusResolved, 50}}
+	if len(got) != len(want) {
+		t.Fatalf("received %d alerts, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i].Status != want[i].status || got[i].Threshold != want[i].threshold {
+			t.Errorf("alert %d = %s at %v, want %s at %v", i, got[i].Status, got[i].Threshold, want[i].status, want[i].threshold)
+		}
+	}
+	if len(saved["team"]) != 0 {
+		t.Errorf("saved thresholds = %v, want none once resolved", saved["team"])
+	}
+}
+
+func TestUpdateRetriesUndeliveredAlert(t *testing.T) {
+	r, hook := newReceiver(t)
+	n, saved := recordingNotifier(hook)
+	b := testBudget()
+	now := time.Now()
+
+	r.setStatus(http.StatusInternalServerError)
+	b.update(60, now, n)
+	if saved["team"] != nil || len(b.undelivered) != 1 {
+		t.Fatalf("alert state saved as %v after a failed delivery, undelivered %v", saved["team"], b.undelivered)
+	}
+
+	r.setStatus(http.StatusAccepted)
+	b.update(60, now, n)
+	b.update(60, now, n)
+	if got := r.received(); len(got) != 2 {
+		t.Fatalf("received %d alerts, want the failed alert and its retry", len(got))
+	}
+	if !b.alerted[50] || len(saved["team"]) != 1 || saved["team"][0] != 50 {
+		t.Errorf("alert state = %v, saved %v, want the 50%% threshold", b.alerted, saved["team"])
+	}
+}
+
+func TestUpdateRetriesOnlyFailedWebhooks(t *testing.T) {
+	slack, slackHook := newReceiver(t)
+	pagerDuty, pagerDutyHook := newReceiver(t)
+	n, saved := recordingNotifier(slackHook, pagerDutyHook)
+	b := testBudget()
+	now := time.Now()
+
+	pagerDuty.setStatus(http.StatusServiceUnavailable)
+	b.update(60, now, n)
+	if saved["team"] != nil {
+		t.Fatalf("alert state saved as %v before every webhook accepted the alert", saved["team"])
+	}
+
+	pagerDuty.setStatus(http.StatusAccepted)
+	b.update(60, now, n)
+	b.update(60, now, n)
+	if got := slack.received(); len(got) != 1 {
+		t.Errorf("the webhook that accepted the alert received it %d times, want once", len(got))
+	}
+	if got := pagerDuty.received(); len(got) != 2 || got[1].Threshold != 50 {
+		t.Errorf("the webhook that failed received %+v, want the alert and its retry", got)
+	}
+	if len(saved["team"]) != 1 || saved["team"][0] != 50 {
+		t.Errorf("saved thresholds = %v, want the 50%% threshold once every webhook accepted it", saved["team"])
+	}
+
+	// A newer alert replaces the alert a webhook did not accept
+	pagerDuty.setStatus(http.StatusServiceUnavailable)
+	b.update(90, now, n)
+	pagerDuty.setStatus(http.StatusAccepted)
+	b.update(10, now, n)
+	b.update(10, now, n)
+	got := pagerDuty.received()
+	if last := got[len(got)-1]; len(got) != 4 || last.Status != StatusResolved {
+		t.Errorf("the webhook that failed received %+v, want the resolved alert last and no retry of the older alert", got)
+	}
+}
+
+func TestUpdateWithoutWebhooks(t *testing.T) {
+	n, saved := recordingNotifier()
+	b := testBudget()
+
+	b.update(100, time.Now(), n)
+	if len(saved["team"]) != 3 {
+		t.Errorf("saved thresholds = %v, want every threshold once logged", saved["team"])
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	for value, want := range map[string]time.Duration{"30d": 30 * 24 * time.Hour, "12h": 12 * time.Hour} {
+		if got, err := parseWindow(value); err != nil || got != want {
+			t.Errorf("parseWindow(%q) = %v, %v, want %v", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"0d", "-1h", "xd", "month"} {
+		if _, err := parseWindow(value); err == nil {
+			t.Errorf("parseWindow(%q) succeeded, want an error", value)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"ingester/metrics"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Webhook payload formats.
+const (
+	FormatGeneric   = "generic"
+	FormatSlack     = "slack"
+	FormatPagerDuty = "pagerduty"
+)
+
+// pagerDutyEventsURL is the PagerDuty Events API v2 endpoint used when a PagerDuty webhook has no URL.
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// webhook is a notification target for budget alerts.
+type webhook struct {
+	url        string
+	format     string
+	routingKey string
+	headers    map[string]string
+}
+
+// newWebhook validates the configuration of a webhook.
+func newWebhook(url, format, routingKey string, headers map[string]string) (webhook, error) {
+	if format == "" {
+		format = FormatGeneric
+	}
+	switch format {
+	case FormatGeneric, FormatSlack:
+		if url == "" {
+			return webhook{}, fmt.Errorf("'url' is not defined")
+		}
+	case FormatPagerDuty:
+		if routingKey == "" {
+			return webhook{}, fmt.Errorf("'routingKey' is required for PagerDuty webhooks")
+		}
+		if url == "" {
+			url = pagerDutyEventsURL
+		}
+	default:
+		return webhook{}, fmt.Errorf("'format' must be one of '%s', '%s' or '%s'", FormatGeneric, FormatSlack, FormatPagerDuty)
+	}
+	return webhook{url: url, format: format, routingKey: routingKey, headers: headers}, nil
+}
+
+// slackPayload returns a Slack incoming webhook message for an alert.
+func slackPayload(alert Alert) interface{} {
+	text := fmt.Sprintf(":rotating_light: Budget *%s* crossed %.0f%%: spent $%.2f of $%.2f over the last %s",
+		alert.Budget, alert.Threshold, alert.Spend, alert.Amount, alert.Window)
+	if alert.Status == StatusResolved {
+		text = fmt.Sprintf(":white_check_mark: Budget *%s* is back below %.0f%%: spent $%.2f of $%.2f over the last %s",
+			alert.Budget, alert.Threshold, alert.Spend, alert.Amount, alert.Window)
+	}
+	return map[string]interface{}{"text": text}
+}
+
+// pagerDutyPayload returns a PagerDuty Events API v2 event for an alert. Events of a budget share
+// a deduplication key, so PagerDuty groups them into one incident that is resolved with the budget.
+func pagerDutyPayload(alert Alert, routingKey string) interface{} {
+	event := map[string]interface{}{
+		"routing_key":  routingKey,
+		"event_action": "trigger",
+		"dedup_key":    "doku-budget-" + alert.Budget,
+	}
+	if alert.Status == StatusResolved {
+		event["event_action"] = "resolve"
+		return event
+	}
+
+	severity := "warning"
+	if alert.Threshold >= 100 {
+		severity = "critical"
+	}
+	event["payload"] = map[string]interface{}{
+		"summary":        fmt.Sprintf("Doku budget '%s' crossed %.0f%% (%.2f of %.2f USD over %s)", alert.Budget, alert.Threshold, alert.Spend, alert.Amount, alert.Window),
+		"source":         "doku-ingester",
+		"severity":       severity,
+		"timestamp":      alert.Timestamp,
+		"custom_details": alert,
+	}
+	return event
+}
+
+// send delivers an alert to the webhook in its payload format.
+func (w webhook) send(alert Alert) error {
+	var payload interface{} = alert
+	switch w.format {
+	case FormatSlack:
+		payload = slackPayload(alert)
+	case FormatPagerDuty:
+		payload = pagerDutyPayload(alert, w.routingKey)
+	}
+
+	if err := w.post(payload); err != nil {
+		metrics.AlertsSent.Inc(w.format, "failed")
+		log.Error().Err(err).Msgf("Error sending the alert of budget '%s' to the %s webhook", alert.Budget, w.format)
+		return err
+	}
+	metrics.AlertsSent.Inc(w.format, "sent")
+	return nil
+}
+
+// post sends a JSON payload to the webhook URL.
+func (w webhook) post(payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", w.url, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("Error creating request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range w.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Error sending request to %v", w.url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Webhook %v responded with status %d", w.url, resp.StatusCode)
+	}
+	return nil
+}
@@ -1,28 +1,64 @@
 package api
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
+	"time"
+	"unicode"
 
 	"ingester/auth"
 	"ingester/db"
+	"ingester/record"
 
 	"github.com/rs/zerolog/log"
 )
 
 const (
 	// Constants for error messages
-	errMsgKeyExists   = "An API Key with the name '%s' already exists"
-	errMsgAuthFailed  = "Unauthorized: Please check your API Key and try again"
-	errMsgKeyNotFound = "Unable to find API Key with the given name %s"
-	errMsgInvalidBody = "Invalid request body"
+	errMsgKeyExists       = "An API Key with the name '%s' already exists"
+	errMsgAuthFailed      = "Unauthorized: Please check your API Key and try again"
+	errMsgKeyNotFound     = "Unable to find API Key with the given name %s"
+	errMsgInvalidBody     = "Invalid request body"
+	errMsgBatchEmpty      = "Request body does not contain any records"
+	errMsgBatchSize       = "Batch contains more than %d records"
+	errMsgBatchBodySize   = "Batch body is larger than %d bytes"
+	errMsgQueueFull       = "Ingestion queue is full, please retry later"
+	errMsgQueueClosed     = "Ingester is shutting down and not accepting new data"
+	errMsgForbidden       = "Forbidden: The API Key is not allowed to perform this operation"
+	errMsgInvalidScope    = "Scopes must be one of %s"
+	errMsgExpiryPast      = "'expiresAt' must be in the future"
+	errMsgOrgExists       = "An Organization with the name '%s' already exists"
+	errMsgProjectExists   = "A Project with the name '%s' already exists"
+	errMsgProjectNotFound = "Unable to find Project with the given name %s"
+	errMsgInvalidRecord   = "Invalid record, check the data for the invalid fields"
+	errMsgInvalidKeyName  = "API Key names must be at most %d characters"
+)
+
+const (
+	// maxBatchRecords limits the number of records accepted in a single batch request.
+	maxBatchRecords = 10000
+	// maxBatchBodySize limits the size of the body of a batch request.
+	maxBatchBodySize = 32 << 20
+	// maxBatchLineSize limits the size of a single record of a newline-delimited batch.
+	maxBatchLineSize = 10 << 20
+	// defaultRotationGracePeriod is how long a rotated API key stays valid when no grace period is given.
+	defaultRotationGracePeriod = 24 * time.Hour
+	// defaultStaleKeyAge is how long an API key must have been unused to be listed as stale when no age is given.
+	defaultStaleKeyAge = 30 * 24 * time.Hour
 )
 
 // APIKeyRequest represents the expected request structure for API Key related endpoints.
 type APIKeyRequest struct {
-	Name string `json:"name"`
+	Name        string     `json:"name"`
+	Project     string     `json:"project"`
+	Scopes      []string   `json:"scopes"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+	GracePeriod string     `json:"gracePeriod"`
 }
 
 // jsonResponse represents the expected response structure for all endpoints.
@@ -35,6 +71,9 @@ type jsonResponse struct {
 // Normalize capitalizes names fields in the request body.
 func (r *APIKeyRequest) Normalize() {
 	r.Name = strings.ToLower(r.Name)
+	for i, scope := range r.Scopes {
+		r.Scopes[i] = strings.ToLower(strings.TrimSpace(scope))
+	}
 }
 
 // decodeRequestBody decodes the JSON request body into the destination struct.
@@ -60,6 +99,20 @@ func sendJSONResponse(w http.ResponseWriter, status int, message string) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// sendJSONDataResponse constructs and sends a JSON response that carries additional data.
+func sendJSONDataResponse(w http.ResponseWriter, status int, message string, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	response := jsonResponse{
+		Status:  status,
+		Message: message,
+		Data:    data,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
 // handleAPIKeyErrors centralizes the error handling logic for API Key operations.
 func handleAPIKeyErrors(w http.ResponseWriter, err error, name string) {
 	if err.Error() == "KEYEXISTS" {
@@ -68,9 +121,27 @@ func handleAPIKeyErrors(w http.ResponseWriter, err error, name string) {
 	} else if err.Error() == "AUTHFAILED" {
 		sendJSONResponse(w, http.StatusUnauthorized, errMsgAuthFailed)
 		return
+	} else if err.Error() == "FORBIDDEN" {
+		sendJSONResponse(w, http.StatusForbidden, errMsgForbidden)
+		return
 	} else if err.Error() == "NOTFOUND" {
 		sendJSONResponse(w, http.StatusNotFound, fmt.Sprintf(errMsgKeyNotFound, name))
 		return
+	} else if err.Error() == "ORGEXISTS" {
+		sendJSONResponse(w, http.StatusConflict, fmt.Sprintf(errMsgOrgExists, name))
+		return
+	} else if err.Error() == "PROJECTEXISTS" {
+		sendJSONResponse(w, http.StatusConflict, fmt.Sprintf(errMsgProjectExists, name))
+		return
+	} else if err.Error() == "PROJECTNOTFOUND" {
+		sendJSONResponse(w, http.StatusNotFound, fmt.Sprintf(errMsgProjectNotFound, name))
+		return
+	} else if err.Error() == "INVALIDNAME" {
+		sendJSONResponse(w, http.StatusBadRequest, fmt.Sprintf(errMsgInvalidKeyName, db.MaxKeyNameLength))
+		return
+	} else if err.Error() == "INVALIDSCOPE" {
+		sendJSONResponse(w, http.StatusBadRequest, fmt.Sprintf(errMsgInvalidScope, strings.Join(db.ValidScopes, ", ")))
+		return
 	} else {
 		sendJSONResponse(w, http.StatusInternalServerError, err.Error())
 		return
@@ -86,33 +157,86 @@ func generateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	request.Normalize()
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		sendJSONResponse(w, http.StatusBadRequest, errMsgExpiryPast)
+		return
+	}
+
+	count, err := db.CountAPIKeys()
+	if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 
-	newAPIKey, err := db.GenerateAPIKey(getAuthKey(r), request.Name)
+	// The first key is created without authentication in the root organization and is granted every
+	// scope so it can manage the other keys, later keys require an admin key of their organization
+	// and default to ingesting data only
+	scopes := request.Scopes
+	orgID := db.RootOrgID
+	if count == 0 {
+		scopes = db.ValidScopes
+	} else {
+		identity, err := auth.AuthorizeRequest(getAuthKey(r), db.ScopeAdmin)
+		if err != nil {
+			handleAPIKeyErrors(w, err, request.Name)
+			return
+		}
+		orgID = identity.OrgID
+		if len(scopes) == 0 {
+			scopes = []string{db.ScopeIngest}
+		}
+	}
+
+	projectID, err := db.ResolveProject(orgID, request.Project)
+	if err != nil {
+		handleAPIKeyErrors(w, err, request.Project)
+		return
+	}
+
+	newAPIKey, err := db.GenerateAPIKey(orgID, projectID, request.Name, scopes, request.ExpiresAt)
 	if err != nil {
 		handleAPIKeyErrors(w, err, request.Name)
 		return
 	}
+	auth.Remember(newAPIKey, auth.Identity{OrgID: orgID, ProjectID: projectID, Name: request.Name, Scopes: scopes, ExpiresAt: request.ExpiresAt})
 
 	sendJSONResponse(w, http.StatusOK, newAPIKey)
 }
 
-// getAPIKeyHandler handles retrieving an existing API key.
+// getAPIKeyHandler handles retrieving the metadata of an existing API key, or of every
+// API key when the request has no body. The keys themselves are only shown at creation.
 func getAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
 	var request APIKeyRequest
 
-	if err := decodeRequestBody(r, &request); err != nil {
+	if err := decodeRequestBody(r, &request); err != nil && err != io.EOF {
 		sendJSONResponse(w, http.StatusBadRequest, errMsgInvalidBody)
 		return
 	}
 	request.Normalize()
 
-	apiKey, err := db.GetAPIKeyForName(getAuthKey(r), request.Name)
+	identity, err := auth.AuthorizeRequest(getAuthKey(r), db.ScopeAdmin)
 	if err != nil {
 		handleAPIKeyErrors(w, err, request.Name)
 		return
 	}
 
-	sendJSONResponse(w, http.StatusOK, apiKey)
+	if request.Name == "" {
+		keys, err := db.ListAPIKeys(identity.OrgID)
+		if err != nil {
+			handleAPIKeyErrors(w, err, "")
+			return
+		}
+		sendJSONDataResponse(w, http.StatusOK, "API keys retrieved successfully", keys)
+		return
+	}
+
+	info, err := db.GetAPIKeyForName(identity.OrgID, request.Name)
+	if err != nil {
+		handleAPIKeyErrors(w, err, request.Name)
+		return
+	}
+
+	sendJSONDataResponse(w, http.StatusOK, "API key retrieved successfully", info)
 }
 
 // deleteAPIKeyHandler handles deleting an existing API key.
@@ -125,36 +249,143 @@ func deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	request.Normalize()
-	err := db.DeleteAPIKey(getAuthKey(r), request.Name)
+	identity, err := auth.AuthorizeRequest(getAuthKey(r), db.ScopeAdmin)
+	if err != nil {
+		handleAPIKeyErrors(w, err, request.Name)
+		return
+	}
+
+	err = db.DeleteAPIKey(identity.OrgID, request.Name)
 	if err != nil {
 		handleAPIKeyErrors(w, err, request.Name)
 		return
 	}
+	auth.EvictAPIKey(identity.OrgID, request.Name)
 
 	sendJSONResponse(w, http.StatusOK, "API key deleted successfully")
 }
 
+// RotateAPIKeyHandler handles replacing an existing API key by a new one recieved on `/api/keys/rotate` endpoint.
+// The replaced key stays valid for the grace period so clients can switch to the new key.
+func RotateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var request APIKeyRequest
+
+	if err := decodeRequestBody(r, &request); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, errMsgInvalidBody)
+		return
+	}
+	request.Normalize()
+
+	identity, err := auth.AuthorizeRequest(getAuthKey(r), db.ScopeAdmin)
+	if err != nil {
+		handleAPIKeyErrors(w, err, request.Name)
+		return
+	}
+
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		sendJSONResponse(w, http.StatusBadRequest, errMsgExpiryPast)
+		return
+	}
+	gracePeriod := defaultRotationGracePeriod
+	if request.GracePeriod != "" {
+		var ok bool
+		if gracePeriod, ok = parseDuration(request.GracePeriod); !ok || gracePeriod < 0 {
+			sendJSONResponse(w, http.StatusBadRequest, "'gracePeriod' must be a duration such as '1h' or '7d'")
+			return
+		}
+	}
+
+	newAPIKey, err := db.RotateAPIKey(identity.OrgID, request.Name, gracePeriod, request.ExpiresAt)
+	if err != nil {
+		handleAPIKeyErrors(w, err, request.Name)
+		return
+	}
+	auth.EvictAPIKey(identity.OrgID, request.Name)
+
+	sendJSONResponse(w, http.StatusOK, newAPIKey)
+}
+
+// StaleAPIKeysHandler handles listing expired API keys and API keys that were not used for a while
+// recieved on `/api/keys/stale` endpoint.
+func StaleAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	identity, err := auth.AuthorizeRequest(getAuthKey(r), db.ScopeAdmin)
+	if err != nil {
+		handleAPIKeyErrors(w, err, "")
+		return
+	}
+
+	unusedFor := defaultStaleKeyAge
+	if value := r.URL.Query().Get("unusedFor"); value != "" {
+		var ok bool
+		if unusedFor, ok = parseDuration(value); !ok || unusedFor <= 0 {
+			sendJSONResponse(w, http.StatusBadRequest, "'unusedFor' must be a duration such as '12h' or '30d'")
+			return
+		}
+	}
+
+	keys, err := db.ListStaleAPIKeys(identity.OrgID, time.Now().Add(-unusedFor))
+	if err != nil {
+		handleAPIKeyErrors(w, err, "")
+		return
+	}
+
+	sendJSONDataResponse(w, http.StatusOK, "Stale API keys retrieved successfully", keys)
+}
+
+// sendValidationError responds with every invalid field of a record, or with a generic
+// message when the body is not a JSON object.
+func sendValidationError(w http.ResponseWriter, err error) {
+	if validationErr, ok := err.(*record.ValidationError); ok {
+		sendJSONDataResponse(w, validationErr.Status, errMsgInvalidRecord, validationErr.Fields)
+		return
+	}
+	sendJSONResponse(w, http.StatusBadRequest, errMsgInvalidBody)
+}
+
 // DataHandler handles data related operations recieved on `/api/push` endpoint.
 func DataHandler(w http.ResponseWriter, r *http.Request) {
-	var data map[string]interface{}
+	var body json.RawMessage
 
-	if err := decodeRequestBody(r, &data); err != nil {
+	if err := decodeRequestBody(r, &body); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, errMsgInvalidBody)
+		return
+	}
+	data, err := record.Decode(body)
+	if err == record.ErrInvalidBody {
 		sendJSONResponse(w, http.StatusBadRequest, errMsgInvalidBody)
 		return
 	}
 
-	var err error
-	data["name"], err = auth.AuthenticateRequest(getAuthKey(r))
+	identity, authErr := auth.AuthorizeRequest(getAuthKey(r), db.ScopeIngest)
+	if authErr != nil {
+		handleAPIKeyErrors(w, authErr, "")
+		return
+	}
 	if err != nil {
-		handleAPIKeyErrors(w, err, "")
+		sendValidationError(w, err)
+		return
+	}
+	data.Name = identity.Name
+	data.OrgID = identity.OrgID
+	data.ProjectID = identity.ProjectID
+
+	if !enforceLimits(w, identity, data.ApplicationName) {
 		return
 	}
 
 	// Check if skipResp is true
-	skipResp, ok := data["skipResp"].(bool)
-	if ok && skipResp == true {
+	if data.SkipResp {
+		// Hand the record to the ingestion queue for async processing
+		err = db.EnqueueInsertion(data)
+		if err == db.ErrQueueFull {
+			w.Header().Set("Retry-After", "1")
+			sendJSONResponse(w, http.StatusTooManyRequests, errMsgQueueFull)
+			return
+		} else if err != nil {
+			sendJSONResponse(w, http.StatusServiceUnavailable, errMsgQueueClosed)
+			return
+		}
 		sendJSONResponse(w, http.StatusAccepted, "Insertion started in background")
-		go db.PerformDatabaseInsertion(data) // Running as a goroutine for async processing
 		return
 	}
 
@@ -164,6 +395,168 @@ func DataHandler(w http.ResponseWriter, r *http.Request) {
 	sendJSONResponse(w, statusCode, responseMessage)
 }
 
+// BatchDataHandler handles batches of data recieved on `/api/push/batch` endpoint.
+// The body is either a JSON array of records or a newline-delimited JSON stream.
+func BatchDataHandler(w http.ResponseWriter, r *http.Request) {
+	identity, err := auth.AuthorizeRequest(getAuthKey(r), db.ScopeIngest)
+	if err != nil {
+		handleAPIKeyErrors(w, err, "")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchBodySize)
+	records, rejected, err := decodeBatchBody(r)
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, errBatchTooLarge):
+		sendJSONResponse(w, http.StatusRequestEntityTooLarge, fmt.Sprintf(errMsgBatchSize, maxBatchRecords))
+		return
+	case errors.As(err, &maxBytesErr):
+		sendJSONResponse(w, http.StatusRequestEntityTooLarge, fmt.Sprintf(errMsgBatchBodySize, maxBatchBodySize))
+		return
+	case err != nil:
+		sendJSONResponse(w, http.StatusBadRequest, errMsgInvalidBody)
+		return
+	}
+
+	total := len(records) + len(rejected)
+	if total == 0 {
+		sendJSONResponse(w, http.StatusBadRequest, errMsgBatchEmpty)
+		return
+	}
+
+	if !enforceLimits(w, identity, recordApplications(records)...) {
+		return
+	}
+
+	for i := range records {
+		records[i].Name = identity.Name
+		records[i].OrgID = identity.OrgID
+		records[i].ProjectID = identity.ProjectID
+	}
+
+	inserted := []db.BatchResult{}
+	statusCode := http.StatusBadRequest
+	if len(records) > 0 {
+		inserted, statusCode = db.PerformBatchInsertion(records)
+	}
+
+	// Merge the records that were rejected while decoding back in at their original position
+	results := make([]db.BatchResult, total)
+	for _, result := range rejected {
+		results[result.Index] = result
+	}
+	next := 0
+	for i := range results {
+		if results[i].Status == 0 {
+			results[i] = inserted[next]
+			results[i].Index = i
+			next++
+		}
+	}
+	if len(rejected) > 0 && statusCode == http.StatusCreated {
+		statusCode = http.StatusMultiStatus
+	}
+
+	sendJSONDataResponse(w, statusCode, batchStatusMessage(statusCode), results)
+}
+
+// errBatchTooLarge is returned by decodeBatchBody when a batch has more than maxBatchRecords records.
+var errBatchTooLarge = errors.New("BATCHTOOLARGE")
+
+// decodeBatchBody decodes the records of a batch request. Records that are not valid are returned
+// as failed results at their position in the batch so the rest of the batch can proceed. Decoding
+// stops as soon as the batch has more than maxBatchRecords records.
+func decodeBatchBody(r *http.Request) ([]record.Record, []db.BatchResult, error) {
+	reader := bufio.NewReader(r.Body)
+
+	// A JSON array is detected from its first non-whitespace character
+	isArray := false
+	for {
+		b, err := reader.Peek(1)
+		if err == io.EOF {
+			return nil, nil, nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if unicode.IsSpace(rune(b[0])) {
+			reader.ReadByte()
+			continue
+		}
+		isArray = b[0] == '['
+		break
+	}
+
+	var bodies []json.RawMessage
+	if isArray {
+		// The array is decoded one element at a time so that an oversized batch is not held in memory
+		decoder := json.NewDecoder(reader)
+		if _, err := decoder.Token(); err != nil {
+			return nil, nil, err
+		}
+		for decoder.More() {
+			if len(bodies) == maxBatchRecords {
+				return nil, nil, errBatchTooLarge
+			}
+			var body json.RawMessage
+			if err := decoder.Decode(&body); err != nil {
+				return nil, nil, err
+			}
+			bodies = append(bodies, body)
+		}
+		if _, err := decoder.Token(); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 64*1024), maxBatchLineSize)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			if len(bodies) == maxBatchRecords {
+				return nil, nil, errBatchTooLarge
+			}
+			bodies = append(bodies, json.RawMessage(line))
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var records []record.Record
+	var rejected []db.BatchResult
+	for i, body := range bodies {
+		data, err := record.Decode(body)
+		if err != nil {
+			result := db.BatchResult{Index: i, Status: http.StatusBadRequest, Message: errMsgInvalidBody}
+			if validationErr, ok := err.(*record.ValidationError); ok {
+				result.Status, result.Message, result.Errors = validationErr.Status, errMsgInvalidRecord, validationErr.Fields
+			}
+			rejected = append(rejected, result)
+			continue
+		}
+		records = append(records, data)
+	}
+
+	return records, rejected, nil
+}
+
+// batchStatusMessage returns the response message for the overall status of a batch.
+func batchStatusMessage(statusCode int) string {
+	switch statusCode {
+	case http.StatusCreated:
+		return "Data insertion completed"
+	case http.StatusMultiStatus:
+		return "Data insertion completed for some records, check the data for details"
+	case http.StatusBadRequest:
+		return "No valid records found in the batch"
+	default:
+		return "Internal Server Error"
+	}
+}
+
 // APIKeyHandler handles all API Key tasks recieved on `/api/keys` endpoint.
 func APIKeyHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ingester/auth"
+	"ingester/db"
+)
+
+const testRecord = `{"environment":"prod","endpoint":"openai.chat.completions","sourceLanguage":"python",` +
+	`"applicationName":"chatbot","model":"gpt-4","promptTokens":10,"completionTokens":5}`
+
+func batchRequest(body string) *http.Request {
+	return httptest.NewRequest(http.MethodPost, "/api/push/batch", strings.NewReader(body))
+}
+
+func TestDecodeBatchBodyFormats(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"array", "  [" + testRecord + "," + testRecord + "]"},
+		{"ndjson", testRecord + "\n\n" + testRecord + "\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			records, rejected, err := decodeBatchBody(batchRequest(test.body))
+			if err != nil {
+				t.Fatalf("decodeBatchBody() error = %v", err)
+			}
+			if len(records) != 2 || len(rejected) != 0 {
+				t.Fatalf("decodeBatchBody() = %d records, %d rejected, want 2 and 0", len(records), len(rejected))
+			}
+		})
+	}
+}
+
+func TestDecodeBatchBodyRejectsInvalidRecords(t *testing.T) {
+	body := "[" + testRecord + `,{"endpoint":"openai.chat.completions"},"not a record"]`
+	records, rejected, err := decodeBatchBody(batchRequest(body))
+	if err != nil {
+		t.Fatalf("decodeBatchBody() error = %v", err)
+	}
+	if len(records) != 1 || len(rejected) != 2 {
+		t.Fatalf("decodeBatchBody() = %d records, %d rejected, want 1 and 2", len(records), len(rejected))
+	}
+	if rejected[0].Index != 1 || rejected[0].Status != http.StatusUnprocessableEntity || len(rejected[0].Errors) == 0 {
+		t.Errorf("rejected[0] = %+v, want index 1 with field errors", rejected[0])
+	}
+	if rejected[1].Index != 2 || rejected[1].Status != http.StatusBadRequest {
+		t.Errorf("rejected[1] = %+v, want index 2 with status 400", rejected[1])
+	}
+}
+
+func TestDecodeBatchBodyEmpty(t *testing.T) {
+	records, rejected, err := decodeBatchBody(batchRequest("  \n"))
+	if err != nil || len(records) != 0 || len(rejected) != 0 {
+		t.Fatalf("decodeBatchBody() = %d, %d, %v, want an empty batch", len(records), len(rejected), err)
+	}
+}
+
+func TestDecodeBatchBodyRecordLimit(t *testing.T) {
+	array := "[" + strings.Repeat("{},", maxBatchRecords) + "{}]"
+	ndjson := strings.Repeat("{}\n", maxBatchRecords+1)
+	for name, body := range map[string]string{"array": array, "ndjson": ndjson} {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := decodeBatchBody(batchRequest(body)); !errors.Is(err, errBatchTooLarge) {
+				t.Fatalf("decodeBatchBody() error = %v, want errBatchTooLarge", err)
+			}
+		})
+	}
+
+	body := strings.Repeat("{}\n", maxBatchRecords)
+	if _, rejected, err := decodeBatchBody(batchRequest(body)); err != nil || len(rejected) != maxBatchRecords {
+		t.Fatalf("decodeBatchBody() = %d rejected, %v, want %d records accepted for decoding", len(rejected), err, maxBatchRecords)
+	}
+}
+
+func TestDecodeBatchBodySizeLimit(t *testing.T) {
+	r := batchRequest("[" + testRecord + "," + testRecord + "]")
+	r.Body = http.MaxBytesReader(httptest.NewRecorder(), r.Body, int64(len(testRecord)))
+
+	var maxBytesErr *http.MaxBytesError
+	if _, _, err := decodeBatchBody(r); !errors.As(err, &maxBytesErr) {
+		t.Fatalf("decodeBatchBody() error = %v, want *http.MaxBytesError", err)
+	}
+}
+
+func TestDecodeBatchBodyMalformed(t *testing.T) {
+	for name, body := range map[string]string{"unterminated array": "[" + testRecord, "long line": strings.Repeat("x", maxBatchLineSize+1)} {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := decodeBatchBody(batchRequest(body)); err == nil {
+				t.Fatal("decodeBatchBody() error = nil, want an error")
+			}
+		})
+	}
+}
+
+// Keys of the root organization and of another organization, named after their scopes.
+const (
+	ingestKey   = "doku-ingest-0000000000000000000000000000000000"
+	readKey     = "doku-read-000000000000000000000000000000000000"
+	adminKey    = "doku-admin-00000000000000000000000000000000000"
+	orgAdminKey = "doku-orgadmin-0000000000000000000000000000000000"
+	orgReadKey  = "doku-orgread-00000000000000000000000000000000000"
+)
+
+func init() {
+	auth.Remember(ingestKey, auth.Identity{OrgID: db.RootOrgID, Name: "ingest", Scopes: []string{db.ScopeIngest}})
+	auth.Remember(readKey, auth.Identity{OrgID: db.RootOrgID, Name: "read", Scopes: []string{db.ScopeRead}})
+	auth.Remember(adminKey, auth.Identity{OrgID: db.RootOrgID, Name: "admin", Scopes: []string{db.ScopeAdmin}})
+	auth.Remember(orgAdminKey, auth.Identity{OrgID: db.RootOrgID + 1, Name: "admin", Scopes: []string{db.ScopeAdmin}})
+	auth.Remember(orgReadKey, auth.Identity{OrgID: db.RootOrgID + 1, Name: "read", Scopes: []string{db.ScopeRead}})
+}
+
+// serve sends a request authenticated with the key to the handler and returns the response status.
+func serve(handler http.HandlerFunc, method, target, key, body string) int {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Authorization", key)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w.Code
+}
+
+func TestHandlersRefuseMissingScope(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		target  string
+		body    string
+		keys    []string
+	}{
+		{"push", DataHandler, "POST", "/api/push", testRecord, []string{readKey}},
+		{"push batch", BatchDataHandler, "POST", "/api/push/batch", "[" + testRecord + "]", []string{readKey}},
+		{"otlp traces", func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Set("Content-Type", contentTypeJSON)
+			OTLPTracesHandler(w, r)
+		}, "POST", "/v1/traces", "{}", []string{readKey}},
+		{"query data", DataQueryHandler, "GET", "/api/data", "", []string{ingestKey}},
+		{"aggregate data", DataAggregateHandler, "GET", "/api/data/aggregate", "", []string{ingestKey}},
+		{"usage", UsageHandler, "GET", "/api/usage", "", []string{ingestKey}},
+		{"metrics", MetricsHandler, "GET", "/metrics", "", []string{ingestKey}},
+		{"list keys", APIKeyHandler, "GET", "/api/keys", "", []string{ingestKey, readKey}},
+		{"delete key", APIKeyHandler, "DELETE", "/api/keys", `{"name":"read"}`, []string{ingestKey, readKey}},
+		{"rotate key", RotateAPIKeyHandler, "POST", "/api/keys/rotate", `{"name":"read"}`, []string{ingestKey, readKey}},
+		{"stale keys", StaleAPIKeysHandler, "GET", "/api/keys/stale", "", []string{ingestKey, readKey}},
+		{"organizations", OrganizationHandler, "GET", "/api/orgs", "", []string{ingestKey, readKey}},
+		{"projects", ProjectHandler, "GET", "/api/projects", "", []string{ingestKey, readKey}},
+		{"pricing reload", PricingReloadHandler, "POST", "/api/pricing/reload", "", []string{ingestKey, readKey}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, key := range test.keys {
+				if status := serve(test.handler, test.method, test.target, key, test.body); status != http.StatusForbidden {
+					t.Errorf("status with the %s key = %d, want 403", strings.Split(key, "-")[1], status)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlersRefuseOtherOrganizations(t *testing.T) {
+	// Organizations, the pricing and the metrics are shared, so only the root organization manages them
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		target  string
+		key     string
+	}{
+		{"organizations", OrganizationHandler, "GET", "/api/orgs", orgAdminKey},
+		{"create organization", OrganizationHandler, "POST", "/api/orgs", orgAdminKey},
+		{"pricing reload", PricingReloadHandler, "POST", "/api/pricing/reload", orgAdminKey},
+		{"metrics", MetricsHandler, "GET", "/metrics", orgReadKey},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if status := serve(test.handler, test.method, test.target, test.key, ""); status != http.StatusForbidden {
+				t.Errorf("status = %d, want 403", status)
+			}
+		})
+	}
+}
+
+func TestAdminScopeGrantsRead(t *testing.T) {
+	for _, key := range []string{readKey, adminKey} {
+		if status := serve(UsageHandler, "GET", "/api/usage", key, ""); status != http.StatusOK {
+			t.Errorf("usage status with the %s key = %d, want 200", strings.Split(key, "-")[1], status)
+		}
+	}
+}
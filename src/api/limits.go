@@ -0,0 +1,68 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ingester/auth"
+	"ingester/db"
+	"ingester/limits"
+	"ingester/record"
+)
+
+const (
+	errMsgRateLimited   = "Too many requests for this API Key, please retry later"
+	errMsgQuotaExceeded = "Ingestion quota exceeded, please retry once the quota resets"
+)
+
+// sendTooManyRequests responds with HTTP 429 and the number of seconds to wait in the Retry-After header.
+func sendTooManyRequests(w http.ResponseWriter, retryAfter time.Duration, message string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(retryAfter.Seconds())))))
+	sendJSONResponse(w, http.StatusTooManyRequests, message)
+}
+
+// enforceLimits applies the rate limit of the API key and the quotas of the key and of each of the
+// applications a request pushes data for. It responds and returns false when a limit is exceeded.
+func enforceLimits(w http.ResponseWriter, identity auth.Identity, applications ...string) bool {
+	if ok, retryAfter := limits.Allow(identity.OrgID, identity.Name); !ok {
+		sendTooManyRequests(w, retryAfter, errMsgRateLimited)
+		return false
+	}
+	if len(applications) == 0 {
+		applications = []string{""}
+	}
+	for _, application := range applications {
+		if ok, retryAfter := limits.CheckQuota(identity.OrgID, identity.Name, application); !ok {
+			sendTooManyRequests(w, retryAfter, errMsgQuotaExceeded)
+			return false
+		}
+	}
+	return true
+}
+
+// recordApplications returns the distinct application names of a list of records.
+func recordApplications(records []record.Record) []string {
+	var applications []string
+	seen := map[string]bool{}
+	for _, data := range records {
+		if !seen[data.ApplicationName] {
+			seen[data.ApplicationName] = true
+			applications = append(applications, data.ApplicationName)
+		}
+	}
+	return applications
+}
+
+// UsageHandler handles reading the quota usage of the API key and of the applications of its organization
+// recieved on `/api/usage` endpoint.
+func UsageHandler(w http.ResponseWriter, r *http.Request) {
+	identity, err := auth.AuthorizeRequest(getAuthKey(r), db.ScopeRead)
+	if err != nil {
+		handleAPIKeyErrors(w, err, "")
+		return
+	}
+
+	sendJSONDataResponse(w, http.StatusOK, "Usage retrieved successfully", limits.GetUsage(identity.OrgID, identity.Name))
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"ingester/auth"
+	"ingester/db"
+	"ingester/metrics"
+)
+
+// MetricsHandler serves the Prometheus metrics on the `/metrics` endpoint of the API. The metrics
+// cover every organization, so only keys of the root organization with the read scope can scrape
+// them. The key is accepted as is or as a bearer token, which is how Prometheus sends credentials.
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	identity, err := auth.AuthorizeRequest(strings.TrimPrefix(getAuthKey(r), "Bearer "), db.ScopeRead)
+	if err != nil {
+		handleAPIKeyErrors(w, err, "")
+		return
+	}
+	if identity.OrgID != db.RootOrgID {
+		sendJSONResponse(w, http.StatusForbidden, errMsgForbidden)
+		return
+	}
+
+	metrics.Handler(w, r)
+}
@@ -0,0 +1,242 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ingester/db"
+	"ingester/record"
+)
+
+// APIVersion is the version of the ingester API described by the OpenAPI document. It is bumped
+// whenever an endpoint, a request or a response changes.
+const APIVersion = "1.0.0"
+
+var (
+	openAPIOnce     sync.Once // openAPIOnce ensures the OpenAPI document is generated only once.
+	openAPIDocument []byte    // openAPIDocument holds the generated OpenAPI document.
+)
+
+// schemaRef returns a reference to a schema of the OpenAPI document.
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// typeSchema returns the JSON Schema of a Go type, following the json tags of structs.
+func typeSchema(t reflect.Type) map[string]interface{} {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := typeSchema(t.Elem())
+		if jsonType, ok := schema["type"].(string); ok {
+			schema["type"] = []string{jsonType, "null"}
+		}
+		return schema
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		addStructProperties(t, properties)
+		return map[string]interface{}{"type": "object", "properties": properties}
+	}
+	return map[string]interface{}{}
+}
+
+// addStructProperties adds the JSON properties of a struct, including those of its embedded structs.
+func addStructProperties(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			addStructProperties(field.Type, properties)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = typeSchema(field.Type)
+	}
+}
+
+// responseSchema returns the schema of a jsonResponse carrying the given data.
+func responseSchema(data map[string]interface{}) map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(jsonResponse{}))
+	properties := schema["properties"].(map[string]interface{})
+	if data == nil {
+		delete(properties, "data")
+	} else {
+		properties["data"] = data
+	}
+	return schema
+}
+
+// jsonContent returns the content of a request or response body with a JSON schema.
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+// operation returns an OpenAPI operation answering with the given status codes and schemas.
+func operation(summary string, requestBody map[string]interface{}, responses map[string]map[string]interface{}) map[string]interface{} {
+	op := map[string]interface{}{"summary": summary}
+	if requestBody != nil {
+		op["requestBody"] = requestBody
+	}
+
+	described := map[string]interface{}{}
+	for status, schema := range responses {
+		code, _ := strconv.Atoi(status)
+		described[status] = map[string]interface{}{
+			"description": http.StatusText(code),
+			"content":     jsonContent(schema),
+		}
+	}
+	op["responses"] = described
+	return op
+}
+
+// generateOpenAPIDocument describes the push, API key and health endpoints. The schemas are
+// generated from the request and response types, and one record schema per supported endpoint.
+func generateOpenAPIDocument() map[string]interface{} {
+	message := responseSchema(nil)
+	fieldErrors := responseSchema(typeSchema(reflect.TypeOf([]record.FieldError{})))
+
+	schemas := map[string]interface{}{
+		"APIKeyRequest": typeSchema(reflect.TypeOf(APIKeyRequest{})),
+		"APIKeyInfo":    typeSchema(reflect.TypeOf(db.APIKeyInfo{})),
+		"BatchResult":   typeSchema(reflect.TypeOf(db.BatchResult{})),
+	}
+	var records []interface{}
+	mapping := map[string]string{}
+	for _, endpoint := range record.Endpoints() {
+		name := "Record." + endpoint
+		schemas[name] = record.Schema(endpoint)
+		records = append(records, schemaRef(name))
+		mapping[endpoint] = "#/components/schemas/" + name
+	}
+	schemas["Record"] = map[string]interface{}{
+		"oneOf":         records,
+		"discriminator": map[string]interface{}{"propertyName": "endpoint", "mapping": mapping},
+	}
+
+	apiKeyBody := map[string]interface{}{"content": jsonContent(schemaRef("APIKeyRequest"))}
+	batchResults := responseSchema(map[string]interface{}{"type": "array", "items": schemaRef("BatchResult")})
+
+	health := operation("Check that the ingester and its database are up", nil, map[string]map[string]interface{}{
+		"200": message,
+		"503": message,
+	})
+	health["security"] = []interface{}{}
+
+	paths := map[string]interface{}{
+		"/": map[string]interface{}{"get": health},
+		"/api/push": map[string]interface{}{
+			"post": operation("Push a record", map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(schemaRef("Record")),
+			}, map[string]map[string]interface{}{
+				"201": message,
+				"202": message,
+				"400": fieldErrors,
+				"401": message,
+				"403": message,
+				"422": fieldErrors,
+				"429": message,
+				"503": message,
+			}),
+		},
+		"/api/push/batch": map[string]interface{}{
+			"post": operation("Push a batch of records as a JSON array or newline-delimited JSON", map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json":     map[string]interface{}{"schema": map[string]interface{}{"type": "array", "items": schemaRef("Record")}},
+					"application/x-ndjson": map[string]interface{}{"schema": schemaRef("Record")},
+				},
+			}, map[string]map[string]interface{}{
+				"201": batchResults,
+				"207": batchResults,
+				"400": batchResults,
+				"401": message,
+				"413": message,
+				"429": message,
+				"500": batchResults,
+			}),
+		},
+		"/api/keys": map[string]interface{}{
+			"get": operation("Get the metadata of the API key with the given name, or of every API key when no name is given", apiKeyBody, map[string]map[string]interface{}{
+				"200": responseSchema(map[string]interface{}{"oneOf": []interface{}{
+					schemaRef("APIKeyInfo"),
+					map[string]interface{}{"type": "array", "items": schemaRef("APIKeyInfo")},
+				}}),
+				"401": message,
+				"403": message,
+				"404": message,
+			}),
+			"post": operation("Create an API key, which is returned as the message of the response. The first key is created without authentication", apiKeyBody, map[string]map[string]interface{}{
+				"200": message,
+				"400": message,
+				"401": message,
+				"403": message,
+				"404": message,
+				"409": message,
+			}),
+			"delete": operation("Delete the API key with the given name", apiKeyBody, map[string]map[string]interface{}{
+				"200": message,
+				"401": message,
+				"403": message,
+				"404": message,
+			}),
+		},
+	}
+
+	return map[string]interface{}{
+		"openapi":           "3.1.0",
+		"jsonSchemaDialect": "https://json-schema.org/draft/2020-12/schema",
+		"info": map[string]interface{}{
+			"title":   "Doku Ingester API",
+			"version": APIVersion,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": "Authorization"},
+			},
+		},
+		"security": []interface{}{map[string]interface{}{"apiKey": []string{}}},
+	}
+}
+
+// OpenAPIHandler serves the OpenAPI document of the ingester API recieved on `/openapi.json` endpoint.
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() {
+		openAPIDocument, _ = json.MarshalIndent(generateOpenAPIDocument(), "", "  ")
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPIDocument)
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"ingester/db"
+	"ingester/record"
+)
+
+// servedDocument returns the OpenAPI document served by the handler, decoded from its JSON.
+func servedDocument(t *testing.T) map[string]interface{} {
+	t.Helper()
+	w := httptest.NewRecorder()
+	OpenAPIHandler(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("OpenAPIHandler() = %d with content type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	var document map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &document); err != nil {
+		t.Fatalf("document is not valid JSON: %v", err)
+	}
+	return document
+}
+
+// object returns the member of a JSON object at the given path of keys.
+func object(t *testing.T, value interface{}, path ...string) map[string]interface{} {
+	t.Helper()
+	for _, key := range path {
+		parent, ok := value.(map[string]interface{})
+		if !ok {
+			t.Fatalf("'%s' of %s is not in an object", key, strings.Join(path, "."))
+		}
+		value = parent[key]
+	}
+	result, ok := value.(map[string]interface{})
+	if !ok {
+		t.Fatalf("%s is not an object", strings.Join(path, "."))
+	}
+	return result
+}
+
+// jsonNames returns the JSON names of the fields of a struct type, including its embedded structs.
+func jsonNames(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			names = append(names, jsonNames(field.Type)...)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestOpenAPIDocumentCoversEndpoints(t *testing.T) {
+	document := servedDocument(t)
+	schemas := object(t, document, "components", "schemas")
+	mapping := object(t, schemas, "Record", "discriminator", "mapping")
+	oneOf, _ := object(t, schemas, "Record")["oneOf"].([]interface{})
+
+	if len(oneOf) != len(record.Endpoints()) || len(mapping) != len(record.Endpoints()) {
+		t.Errorf("Record has %d variants and %d mappings, want %d", len(oneOf), len(mapping), len(record.Endpoints()))
+	}
+	for _, endpoint := range record.Endpoints() {
+		name := "Record." + endpoint
+		if mapping[endpoint] != "#/components/schemas/"+name {
+			t.Errorf("discriminator maps %s to %v", endpoint, mapping[endpoint])
+		}
+		schema := object(t, schemas, name)
+		if object(t, schema, "properties", "endpoint")["const"] != endpoint {
+			t.Errorf("%s does not fix the endpoint", name)
+		}
+		// Every field of a record is described, so the schema follows the Record type
+		properties := object(t, schema, "properties")
+		for _, field := range jsonNames(reflect.TypeOf(record.Record{})) {
+			if properties[field] == nil {
+				t.Errorf("%s does not describe the '%s' field", name, field)
+			}
+		}
+		if len(properties) != len(jsonNames(reflect.TypeOf(record.Record{}))) {
+			t.Errorf("%s has %d properties, want one per field of Record", name, len(properties))
+		}
+	}
+}
+
+func TestOpenAPIDocumentTypes(t *testing.T) {
+	document := servedDocument(t)
+	schemas := object(t, document, "components", "schemas")
+
+	types := map[string]reflect.Type{
+		"APIKeyRequest": reflect.TypeOf(APIKeyRequest{}),
+		"APIKeyInfo":    reflect.TypeOf(db.APIKeyInfo{}),
+		"BatchResult":   reflect.TypeOf(db.BatchResult{}),
+	}
+	for name, goType := range types {
+		properties := object(t, schemas, name, "properties")
+		fields := jsonNames(goType)
+		for _, field := range fields {
+			if properties[field] == nil {
+				t.Errorf("%s does not describe the '%s' field", name, field)
+			}
+		}
+		if len(properties) != len(fields) {
+			t.Errorf("%s has %d properties, want %d", name, len(properties), len(fields))
+		}
+	}
+
+	// Pointers are nullable and time fields are date-times
+	expiresAt := object(t, schemas, "APIKeyRequest", "properties", "expiresAt")
+	if expiresAt["format"] != "date-time" || !reflect.DeepEqual(expiresAt["type"], []interface{}{"string", "null"}) {
+		t.Errorf("expiresAt = %v, want a nullable date-time", expiresAt)
+	}
+
+	// Responses are jsonResponse envelopes
+	responses := object(t, document, "paths", "/api/push", "post", "responses")
+	for _, field := range jsonNames(reflect.TypeOf(jsonResponse{})) {
+		if field == "data" {
+			continue
+		}
+		if object(t, responses, "201", "content", "application/json", "schema", "properties")[field] == nil {
+			t.Errorf("the push response does not describe the '%s' field", field)
+		}
+	}
+	if object(t, responses, "422", "content", "application/json", "schema", "properties", "data")["type"] != "array" {
+		t.Error("the 422 push response does not carry the invalid fields")
+	}
+}
+
+func TestOpenAPIDocumentReferences(t *testing.T) {
+	document := servedDocument(t)
+	schemas := object(t, document, "components", "schemas")
+
+	var check func(value interface{})
+	check = func(value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok {
+				if name := strings.TrimPrefix(ref, "#/components/schemas/"); schemas[name] == nil {
+					t.Errorf("reference %s does not resolve", ref)
+				}
+			}
+			for _, member := range v {
+				check(member)
+			}
+		case []interface{}:
+			for _, member := range v {
+				check(member)
+			}
+		}
+	}
+	check(document)
+
+	for _, path := range []string{"/", "/api/push", "/api/push/batch", "/api/keys"} {
+		object(t, document, "paths", path)
+	}
+}
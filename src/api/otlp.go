@@ -0,0 +1,124 @@
+package api
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"ingester/auth"
+	"ingester/db"
+	"ingester/otlp"
+
+	"github.com/rs/zerolog/log"
+)
+
+// maxOTLPBodySize limits the size of an OTLP/HTTP export request.
+const maxOTLPBodySize = 16 << 20
+
+const (
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeJSON     = "application/json"
+)
+
+// sendOTLPTraceResponse sends an ExportTraceServiceResponse in the encoding of the request.
+func sendOTLPTraceResponse(w http.ResponseWriter, contentType string, rejected int, message string) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+
+	if contentType == contentTypeProtobuf {
+		w.Write(otlp.MarshalTraceResponseProto(int64(rejected), message))
+		return
+	}
+
+	response := otlp.ExportTraceServiceResponse{}
+	if rejected > 0 || message != "" {
+		response.PartialSuccess = &otlp.ExportTracePartialSuccess{RejectedSpans: otlp.Int64(rejected), ErrorMessage: message}
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// decodeOTLPTraceRequest decodes the body of an OTLP/HTTP trace export in JSON or protobuf encoding.
+func decodeOTLPTraceRequest(r *http.Request, contentType string) (*otlp.ExportTraceServiceRequest, error) {
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gzipReader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		body = gzipReader
+	}
+
+	content, err := io.ReadAll(io.LimitReader(body, maxOTLPBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxOTLPBodySize {
+		return nil, fmt.Errorf("Request body is larger than %d bytes", maxOTLPBodySize)
+	}
+
+	if contentType == contentTypeProtobuf {
+		return otlp.UnmarshalTraceRequestProto(content)
+	}
+
+	var request otlp.ExportTraceServiceRequest
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// OTLPTracesHandler handles OTLP/HTTP trace exports recieved on `/v1/traces` endpoint.
+// GenAI spans are queued to be stored and exported as records, other spans are ignored.
+func OTLPTracesHandler(w http.ResponseWriter, r *http.Request) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != contentTypeProtobuf && contentType != contentTypeJSON {
+		sendJSONResponse(w, http.StatusUnsupportedMediaType, "Content-Type must be application/x-protobuf or application/json")
+		return
+	}
+
+	identity, err := auth.AuthorizeRequest(getAuthKey(r), db.ScopeIngest)
+	if err != nil {
+		handleAPIKeyErrors(w, err, "")
+		return
+	}
+
+	request, err := decodeOTLPTraceRequest(r, contentType)
+	if err != nil {
+		log.Warn().Err(err).Msg("Error decoding OTLP trace export")
+		sendJSONResponse(w, http.StatusBadRequest, errMsgInvalidBody)
+		return
+	}
+
+	records, rejected, message := otlp.GenAIRecords(request)
+	if len(records) == 0 {
+		sendOTLPTraceResponse(w, contentType, rejected, message)
+		return
+	}
+
+	for i := range records {
+		records[i].Name = identity.Name
+		records[i].OrgID = identity.OrgID
+		records[i].ProjectID = identity.ProjectID
+	}
+
+	if !enforceLimits(w, identity, recordApplications(records)...) {
+		return
+	}
+
+	// The records take the same queue as records pushed with 'skipResp', which writes and exports them
+	err = db.EnqueueBatch(records)
+	if err == db.ErrQueueFull {
+		w.Header().Set("Retry-After", "1")
+		sendJSONResponse(w, http.StatusTooManyRequests, errMsgQueueFull)
+		return
+	} else if err != nil {
+		sendJSONResponse(w, http.StatusServiceUnavailable, errMsgQueueClosed)
+		return
+	}
+
+	sendOTLPTraceResponse(w, contentType, rejected, message)
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"ingester/auth"
+	"ingester/cost"
+	"ingester/db"
+
+	"github.com/rs/zerolog/log"
+)
+
+// errMsgPricingReload is the message returned when the pricing could not be reloaded.
+const errMsgPricingReload = "Failed to reload the pricing information, the last good pricing is kept: "
+
+// pricingReloadTimeout bounds the reload of the admin endpoint, which has to answer before the
+// write timeout of the server.
+const pricingReloadTimeout = 8 * time.Second
+
+// PricingReloadHandler reloads the pricing information on the `/api/pricing/reload` endpoint.
+// The pricing is shared by every organization, so only admins of the root organization can reload it.
+// The pricing URL is requested once, the retries are left to the refresh interval.
+func PricingReloadHandler(w http.ResponseWriter, r *http.Request) {
+	identity, err := auth.AuthorizeRequest(getAuthKey(r), db.ScopeAdmin)
+	if err != nil {
+		handleAPIKeyErrors(w, err, "")
+		return
+	}
+	if !identity.IsRootAdmin() {
+		sendJSONResponse(w, http.StatusForbidden, errMsgForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), pricingReloadTimeout)
+	defer cancel()
+	updated, err := cost.ReloadOnce(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to reload LLM pricing information through the admin endpoint")
+		sendJSONResponse(w, http.StatusBadGateway, errMsgPricingReload+err.Error())
+		return
+	}
+	if !updated {
+		sendJSONResponse(w, http.StatusOK, "Pricing information is unchanged")
+		return
+	}
+	log.Info().Msg("Reloaded LLM pricing information through the admin endpoint")
+	sendJSONResponse(w, http.StatusOK, "Pricing information reloaded successfully")
+}
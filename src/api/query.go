@@ -0,0 +1,168 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ingester/auth"
+	"ingester/db"
+)
+
+const (
+	// defaultQueryLimit is the number of records returned per page when no limit is given.
+	defaultQueryLimit = 100
+	// maxQueryLimit is the maximum number of records returned per page.
+	maxQueryLimit = 1000
+	// maxAggregateBuckets limits the number of time buckets an aggregate request can produce.
+	maxAggregateBuckets = 10000
+	// defaultAggregateRange is the time range aggregated when no start time is given.
+	defaultAggregateRange = 24 * time.Hour
+)
+
+// dataPage is the response body of the data query endpoint.
+type dataPage struct {
+	Records    []db.DataRecord `json:"records"`
+	NextCursor string          `json:"nextCursor,omitempty"`
+}
+
+// parseDataFilter reads the time range and column filters from the query string. Records are
+// always filtered on the organization of the caller.
+func parseDataFilter(r *http.Request, orgID int) (db.DataFilter, error) {
+	query := r.URL.Query()
+	filter := db.DataFilter{
+		OrgID:           orgID,
+		Environment:     query.Get("environment"),
+		ApplicationName: query.Get("applicationName"),
+		Endpoint:        query.Get("endpoint"),
+		Model:           query.Get("model"),
+	}
+
+	var err error
+	if projectID := query.Get("projectId"); projectID != "" {
+		if filter.ProjectID, err = strconv.Atoi(projectID); err != nil || filter.ProjectID <= 0 {
+			return filter, fmt.Errorf("'projectId' must be a project ID")
+		}
+	}
+	if from := query.Get("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return filter, fmt.Errorf("'from' must be an RFC 3339 timestamp")
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return filter, fmt.Errorf("'to' must be an RFC 3339 timestamp")
+		}
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, fmt.Errorf("'from' must be before 'to'")
+	}
+	return filter, nil
+}
+
+// parseDuration parses a duration given as a Go duration such as '15m' or '1h', or as a number of days such as '1d'.
+func parseDuration(value string) (time.Duration, bool) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, false
+		}
+		return time.Duration(n) * 24 * time.Hour, true
+	}
+	duration, err := time.ParseDuration(value)
+	return duration, err == nil
+}
+
+// parseBucket parses a bucket width given as a Go duration such as '15m' or '1h', or as a number of days such as '1d'.
+func parseBucket(value string) (time.Duration, error) {
+	bucket, ok := parseDuration(value)
+	if !ok || bucket < time.Minute {
+		return 0, fmt.Errorf("'bucket' must be a duration of at least '1m', such as '1h' or '1d'")
+	}
+	return bucket, nil
+}
+
+// DataQueryHandler handles reading stored records recieved on `/api/data` endpoint.
+func DataQueryHandler(w http.ResponseWriter, r *http.Request) {
+	identity, err := auth.AuthorizeRequest(getAuthKey(r), db.ScopeRead)
+	if err != nil {
+		handleAPIKeyErrors(w, err, "")
+		return
+	}
+
+	filter, err := parseDataFilter(r, identity.OrgID)
+	if err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	limit := defaultQueryLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxQueryLimit {
+			sendJSONResponse(w, http.StatusBadRequest, fmt.Sprintf("'limit' must be a number between 1 and %d", maxQueryLimit))
+			return
+		}
+	}
+
+	records, nextCursor, err := db.QueryData(filter, r.URL.Query().Get("cursor"), limit)
+	if err != nil {
+		if err.Error() == "INVALIDCURSOR" {
+			sendJSONResponse(w, http.StatusBadRequest, "'cursor' is not valid")
+			return
+		}
+		sendJSONResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	sendJSONDataResponse(w, http.StatusOK, "Data retrieved successfully", dataPage{Records: records, NextCursor: nextCursor})
+}
+
+// DataAggregateHandler handles aggregating stored records recieved on `/api/data/aggregate` endpoint.
+func DataAggregateHandler(w http.ResponseWriter, r *http.Request) {
+	identity, err := auth.AuthorizeRequest(getAuthKey(r), db.ScopeRead)
+	if err != nil {
+		handleAPIKeyErrors(w, err, "")
+		return
+	}
+
+	filter, err := parseDataFilter(r, identity.OrgID)
+	if err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.To.IsZero() {
+		filter.To = time.Now()
+	}
+	if filter.From.IsZero() {
+		filter.From = filter.To.Add(-defaultAggregateRange)
+	}
+
+	bucketValue := r.URL.Query().Get("bucket")
+	if bucketValue == "" {
+		bucketValue = "1h"
+	}
+	bucket, err := parseBucket(bucketValue)
+	if err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.To.Sub(filter.From)/bucket > maxAggregateBuckets {
+		sendJSONResponse(w, http.StatusBadRequest, fmt.Sprintf("The time range contains more than %d buckets, use a larger 'bucket'", maxAggregateBuckets))
+		return
+	}
+
+	records, err := db.AggregateData(filter, bucket, r.URL.Query().Get("groupBy"))
+	if err != nil {
+		if err.Error() == "INVALIDGROUP" {
+			sendJSONResponse(w, http.StatusBadRequest, fmt.Sprintf("'groupBy' must be one of %s", strings.Join(db.GroupByColumns, ", ")))
+			return
+		}
+		sendJSONResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	sendJSONDataResponse(w, http.StatusOK, "Data aggregated successfully", records)
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"ingester/auth"
+	"ingester/db"
+)
+
+// orgAdminKeyName is the name of the admin API key created along with an organization.
+const orgAdminKeyName = "admin"
+
+// tenancyRequest represents the expected request structure for the organization and project endpoints.
+type tenancyRequest struct {
+	Name string `json:"name"`
+}
+
+// createdOrganization is the response body of the organization creation endpoint.
+type createdOrganization struct {
+	Organization db.Organization `json:"organization"`
+	Project      db.Project      `json:"project"`
+	APIKey       string          `json:"apiKey"`
+}
+
+// OrganizationHandler handles organization related operations recieved on `/api/orgs` endpoint.
+// Only admins of the root organization can list and create organizations.
+func OrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	identity, err := auth.AuthorizeRequest(getAuthKey(r), db.ScopeAdmin)
+	if err != nil {
+		handleAPIKeyErrors(w, err, "")
+		return
+	}
+	if !identity.IsRootAdmin() {
+		sendJSONResponse(w, http.StatusForbidden, errMsgForbidden)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		orgs, err := db.ListOrganizations()
+		if err != nil {
+			handleAPIKeyErrors(w, err, "")
+			return
+		}
+		sendJSONDataResponse(w, http.StatusOK, "Organizations retrieved successfully", orgs)
+	case "POST":
+		var request tenancyRequest
+		if err := decodeRequestBody(r, &request); err != nil || strings.TrimSpace(request.Name) == "" {
+			sendJSONResponse(w, http.StatusBadRequest, errMsgInvalidBody)
+			return
+		}
+
+		// The organization gets an admin key to create its own keys and projects
+		org, project, apiKey, err := db.CreateOrganization(strings.TrimSpace(request.Name), orgAdminKeyName)
+		if err != nil {
+			handleAPIKeyErrors(w, err, request.Name)
+			return
+		}
+		auth.Remember(apiKey, auth.Identity{OrgID: org.ID, ProjectID: project.ID, Name: orgAdminKeyName, Scopes: db.ValidScopes})
+		sendJSONDataResponse(w, http.StatusCreated, "Organization created successfully",
+			createdOrganization{Organization: org, Project: project, APIKey: apiKey})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// ProjectHandler handles project related operations recieved on `/api/projects` endpoint.
+// Projects are listed and created in the organization of the caller.
+func ProjectHandler(w http.ResponseWriter, r *http.Request) {
+	identity, err := auth.AuthorizeRequest(getAuthKey(r), db.ScopeAdmin)
+	if err != nil {
+		handleAPIKeyErrors(w, err, "")
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		projects, err := db.ListProjects(identity.OrgID)
+		if err != nil {
+			handleAPIKeyErrors(w, err, "")
+			return
+		}
+		sendJSONDataResponse(w, http.StatusOK, "Projects retrieved successfully", projects)
+	case "POST":
+		var request tenancyRequest
+		if err := decodeRequestBody(r, &request); err != nil || strings.TrimSpace(request.Name) == "" {
+			sendJSONResponse(w, http.StatusBadRequest, errMsgInvalidBody)
+			return
+		}
+
+		project, err := db.CreateProject(identity.OrgID, strings.TrimSpace(request.Name))
+		if err != nil {
+			handleAPIKeyErrors(w, err, request.Name)
+			return
+		}
+		sendJSONDataResponse(w, http.StatusCreated, "Project created successfully", project)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
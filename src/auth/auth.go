@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"ingester/db"
+	"ingester/metrics"
 
 	"github.com/rs/zerolog/log"
 )
@@ -16,14 +17,37 @@ var (
 	apiKeyCache = sync.Map{}
 	// CacheEntryDuration defines how long an item should stay in the cache before being re-validated.
 	CacheEntryDuration = time.Minute * 10
+	// lastUseUpdates stores when the last use of each API key was written to the database.
+	lastUseUpdates = sync.Map{}
+	// LastUseUpdateInterval limits how often the last use of an API key is written to the database.
+	LastUseUpdateInterval = time.Minute
 )
 
 // cacheEntry represents an entry in the API key cache.
 type cacheEntry struct {
-	Name      string
+	Identity  Identity
 	Timestamp time.Time
 }
 
+// Identity describes the owner of an authenticated API key.
+type Identity struct {
+	OrgID     int
+	ProjectID int
+	Name      string
+	Scopes    []string
+	ExpiresAt *time.Time
+}
+
+// HasScope reports whether the identity was granted the scope. The admin scope grants every scope.
+func (i Identity) HasScope(scope string) bool {
+	for _, s := range i.Scopes {
+		if s == scope || s == db.ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
 // InitializeCacheEviction sets up periodic eviction of expired cache entries.
 func InitializeCacheEviction() {
 	go func() {
@@ -52,27 +76,92 @@ func evictExpiredEntries() {
 }
 
 // AuthenticateRequest checks the provided API key against the known keys.
-func AuthenticateRequest(apiKey string) (string, error) {
-	/// Attempt to retrieve API Key from the cache.
-	if val, ok := apiKeyCache.Load(apiKey); ok {
+func AuthenticateRequest(apiKey string) (Identity, error) {
+	/// Attempt to retrieve API Key from the cache, which is keyed by the hash of the key.
+	keyHash := db.HashAPIKey(apiKey)
+	if val, ok := apiKeyCache.Load(keyHash); ok {
 		entry := val.(cacheEntry)
-		if time.Since(entry.Timestamp) < CacheEntryDuration {
-			return entry.Name, nil
+		expired := entry.Identity.ExpiresAt != nil && !time.Now().Before(*entry.Identity.ExpiresAt)
+		if time.Since(entry.Timestamp) < CacheEntryDuration && !expired {
+			metrics.AuthCacheHits.Inc()
+			recordLastUse(entry.Identity)
+			return entry.Identity, nil
 		}
 	}
+	metrics.AuthCacheMisses.Inc()
 
 	// If the key is not in the cache or the cache has expired, call the db to check the API key.
-	name, err := db.CheckAPIKey(apiKey)
+	info, err := db.CheckAPIKey(apiKey)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			log.Warn().Msg("Authorization Failed for an API Key")
-			return "", fmt.Errorf("AUTHFAILED")
+			return Identity{}, fmt.Errorf("AUTHFAILED")
 		}
-		return "", err
+		return Identity{}, err
 	}
 
 	// The API key has been successfully authenticated, so cache it.
-	apiKeyCache.Store(apiKey, cacheEntry{Name: name, Timestamp: time.Now()})
+	identity := Identity{OrgID: info.OrgID, ProjectID: info.ProjectID, Name: info.Name, Scopes: info.Scopes, ExpiresAt: info.ExpiresAt}
+	apiKeyCache.Store(keyHash, cacheEntry{Identity: identity, Timestamp: time.Now()})
+	recordLastUse(identity)
+
+	return identity, nil
+}
+
+// Remember caches the identity of an API key that was just issued, so its first uses are not
+// checked against the database. Its use is recorded once LastUseUpdateInterval has passed.
+func Remember(apiKey string, identity Identity) {
+	apiKeyCache.Store(db.HashAPIKey(apiKey), cacheEntry{Identity: identity, Timestamp: time.Now()})
+	lastUseUpdates.Store(lastUseKey(identity), time.Now())
+}
+
+// lastUseKey returns the key of the last use updates of the API key of an identity.
+func lastUseKey(identity Identity) string {
+	return fmt.Sprintf("%d/%s", identity.OrgID, identity.Name)
+}
+
+// recordLastUse writes the last use of an API key to the database in the background, at most
+// once per LastUseUpdateInterval for each key.
+func recordLastUse(identity Identity) {
+	key := lastUseKey(identity)
+	now := time.Now()
+	if val, ok := lastUseUpdates.Load(key); ok && now.Sub(val.(time.Time)) < LastUseUpdateInterval {
+		return
+	}
+	lastUseUpdates.Store(key, now)
 
-	return name, nil
+	go func() {
+		if err := db.TouchAPIKey(identity.OrgID, identity.Name); err != nil {
+			log.Warn().Err(err).Msgf("Error updating the last use of the API key with the name '%s'", identity.Name)
+		}
+	}()
+}
+
+// EvictAPIKey removes the cached entries of the API key with the given name in the organization,
+// so a deleted or rotated key is checked against the database on its next use.
+func EvictAPIKey(orgID int, name string) {
+	apiKeyCache.Range(func(key, value interface{}) bool {
+		if entry, ok := value.(cacheEntry); ok && entry.Identity.OrgID == orgID && entry.Identity.Name == name {
+			apiKeyCache.Delete(key)
+		}
+		return true
+	})
+}
+
+// IsRootAdmin reports whether the identity is an admin of the root organization, which manages the other organizations.
+func (i Identity) IsRootAdmin() bool {
+	return i.OrgID == db.RootOrgID && i.HasScope(db.ScopeAdmin)
+}
+
+// AuthorizeRequest authenticates the provided API key and checks that it was granted the scope.
+func AuthorizeRequest(apiKey, scope string) (Identity, error) {
+	identity, err := AuthenticateRequest(apiKey)
+	if err != nil {
+		return identity, err
+	}
+	if !identity.HasScope(scope) {
+		log.Warn().Msgf("API Key with the name '%s' is missing the '%s' scope", identity.Name, scope)
+		return identity, fmt.Errorf("FORBIDDEN")
+	}
+	return identity, nil
 }
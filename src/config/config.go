@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v2"
@@ -15,20 +16,90 @@ type Configuration struct {
 		LocalFile struct {
 			Path string `yaml:"path"`
 		} `yaml:"localFile"`
-		URL string `yaml:"url"`
+		URL             string `yaml:"url"`
+		RefreshInterval string `yaml:"refreshInterval"`
+		Deployments     []struct {
+			Name   string `yaml:"name"`
+			Model  string `yaml:"model"`
+			Region string `yaml:"region"`
+		} `yaml:"deployments"`
+		Overrides []struct {
+			Name            string  `yaml:"name"`
+			OrgID           int     `yaml:"orgId"`
+			APIKey          string  `yaml:"apiKey"`
+			ApplicationName string  `yaml:"applicationName"`
+			Provider        string  `yaml:"provider"`
+			Discount        float64 `yaml:"discount"`
+			Chat            map[string]struct {
+				PromptPrice     float64 `yaml:"promptPrice"`
+				CompletionPrice float64 `yaml:"completionPrice"`
+				CacheReadPrice  float64 `yaml:"cacheReadPrice"`
+				CacheWritePrice float64 `yaml:"cacheWritePrice"`
+			} `yaml:"chat"`
+			Embeddings map[string]float64 `yaml:"embeddings"`
+		} `yaml:"overrides"`
 	} `yaml:"pricingInfo"`
 	DBConfig struct {
-		DBName          string `yaml:"name"`
-		DBUser          string `yaml:"username"`
-		DBPassword      string `yaml:"password"`
-		DBHost          string `yaml:"host"`
-		DBPort          string `yaml:"port"`
-		DBSSLMode       string `yaml:"sslMode"`
-		MaxOpenConns    int    `yaml:"maxOpenConns"`
-		MaxIdleConns    int    `yaml:"maxIdleConns"`
-		DataTableName   string `yaml:"dataTable"`
-		APIKeyTableName string `yaml:"apiKeyTable"`
+		DBName            string `yaml:"name"`
+		DBUser            string `yaml:"username"`
+		DBPassword        string `yaml:"password"`
+		DBHost            string `yaml:"host"`
+		DBPort            string `yaml:"port"`
+		DBSSLMode         string `yaml:"sslMode"`
+		MaxOpenConns      int    `yaml:"maxOpenConns"`
+		MaxIdleConns      int    `yaml:"maxIdleConns"`
+		DataTableName     string `yaml:"dataTable"`
+		APIKeyTableName   string `yaml:"apiKeyTable"`
+		APIKeySecret      string `yaml:"apiKeySecret"`
+		OrgTableName      string `yaml:"orgTable"`
+		ProjectTableName  string `yaml:"projectTable"`
+		PricingTableName  string `yaml:"pricingTable"`
+		AlertTableName    string `yaml:"alertTable"`
+		RetentionInterval string `yaml:"retentionInterval"`
+		CompressAfter     string `yaml:"compressAfter"`
+		ChunkTimeInterval string `yaml:"chunkTimeInterval"`
+		IngestQueue       struct {
+			Size          int    `yaml:"size"`
+			Workers       int    `yaml:"workers"`
+			BatchSize     int    `yaml:"batchSize"`
+			FlushInterval string `yaml:"flushInterval"`
+		} `yaml:"ingestQueue"`
 	} `yaml:"dbConfig"`
+	Limits struct {
+		RateLimit struct {
+			RequestsPerSecond float64 `yaml:"requestsPerSecond"`
+			Burst             int     `yaml:"burst"`
+		} `yaml:"rateLimit"`
+		Quotas []struct {
+			Scope     string  `yaml:"scope"`
+			Period    string  `yaml:"period"`
+			MaxTokens int64   `yaml:"maxTokens"`
+			MaxCost   float64 `yaml:"maxCost"`
+		} `yaml:"quotas"`
+	} `yaml:"limits"`
+	Alerts struct {
+		EvaluationInterval string `yaml:"evaluationInterval"`
+		Budgets            []struct {
+			Name            string    `yaml:"name"`
+			OrgID           int       `yaml:"orgId"`
+			Environment     string    `yaml:"environment"`
+			ApplicationName string    `yaml:"applicationName"`
+			Model           string    `yaml:"model"`
+			Window          string    `yaml:"window"`
+			Amount          float64   `yaml:"amount"`
+			Thresholds      []float64 `yaml:"thresholds"`
+		} `yaml:"budgets"`
+		Webhooks []struct {
+			URL        string            `yaml:"url"`
+			Format     string            `yaml:"format"`
+			RoutingKey string            `yaml:"routingKey"`
+			Headers    map[string]string `yaml:"headers"`
+		} `yaml:"webhooks"`
+	} `yaml:"alerts"`
+	Metrics struct {
+		ListenAddress string `yaml:"listenAddress"`
+		MaxSeries     int    `yaml:"maxSeries"`
+	} `yaml:"metrics"`
 	ObservabilityPlatform struct {
 		Enabled      bool `yaml:"enabled"`
 		GrafanaCloud struct {
@@ -43,6 +114,11 @@ type Configuration struct {
 			MetricsURL string `yaml:"metricsUrl"`
 			LogsURL    string `yaml:"logsUrl"`
 		} `yaml:"newRelic"`
+		OTLP struct {
+			Endpoint string            `yaml:"endpoint"`
+			Headers  map[string]string `yaml:"headers"`
+		} `yaml:"otlp"`
+		Unknown map[string]interface{} `yaml:",inline"` // Unknown holds the blocks of platforms the ingester has no exporter for.
 	} `yaml:"observabilityPlatform"`
 }
 
@@ -79,6 +155,58 @@ func validateConfig(cfg *Configuration) error {
 		log.Info().Msg("dbConfig.username is now set")
 	}
 
+	// The pricing is only reloaded on SIGHUP or through the admin endpoint when no interval is set
+	if cfg.PricingInfo.RefreshInterval != "" {
+		if interval, err := time.ParseDuration(cfg.PricingInfo.RefreshInterval); err != nil || interval <= 0 {
+			return fmt.Errorf("'pricingInfo.refreshInterval' is not a valid duration")
+		}
+	}
+
+	// Keys hashed without a secret could be matched against a leaked key table, so it is required
+	if cfg.DBConfig.APIKeySecret == "" {
+		log.Info().Msg("'dbConfig.apiKeySecret' is not defined, trying to read from environment variable 'API_KEY_SECRET'")
+		cfg.DBConfig.APIKeySecret = os.Getenv("API_KEY_SECRET")
+		if cfg.DBConfig.APIKeySecret == "" {
+			return fmt.Errorf("'API_KEY_SECRET' environment variable is not set and 'dbConfig.apiKeySecret' is not defined in configuration")
+		}
+	}
+
+	// Apply defaults for the tenancy tables
+	if cfg.DBConfig.OrgTableName == "" {
+		cfg.DBConfig.OrgTableName = "ORGANIZATIONS"
+	}
+	if cfg.DBConfig.ProjectTableName == "" {
+		cfg.DBConfig.ProjectTableName = "PROJECTS"
+	}
+	if cfg.DBConfig.PricingTableName == "" {
+		cfg.DBConfig.PricingTableName = "PRICING"
+	}
+	if cfg.DBConfig.AlertTableName == "" {
+		cfg.DBConfig.AlertTableName = "ALERTS"
+	}
+
+	// Label values of the metrics come from the pushed records, so their series are capped
+	if cfg.Metrics.MaxSeries <= 0 {
+		cfg.Metrics.MaxSeries = 2000
+	}
+
+	// Apply defaults for the asynchronous ingestion queue
+	if cfg.DBConfig.IngestQueue.Size <= 0 {
+		cfg.DBConfig.IngestQueue.Size = 10000
+	}
+	if cfg.DBConfig.IngestQueue.Workers <= 0 {
+		cfg.DBConfig.IngestQueue.Workers = 4
+	}
+	if cfg.DBConfig.IngestQueue.BatchSize <= 0 {
+		cfg.DBConfig.IngestQueue.BatchSize = 500
+	}
+	if cfg.DBConfig.IngestQueue.FlushInterval == "" {
+		cfg.DBConfig.IngestQueue.FlushInterval = "1s"
+	}
+	if interval, err := time.ParseDuration(cfg.DBConfig.IngestQueue.FlushInterval); err != nil || interval <= 0 {
+		return fmt.Errorf("'dbConfig.ingestQueue.flushInterval' is not a valid duration")
+	}
+
 	return nil
 }
 
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+// validConfig returns a configuration that passes validation.
+func validConfig() *Configuration {
+	cfg := &Configuration{IngesterPort: "9044"}
+	cfg.PricingInfo.LocalFile.Path = "pricing.json"
+	cfg.DBConfig.DBUser = "doku"
+	cfg.DBConfig.DBPassword = "doku"
+	cfg.DBConfig.APIKeySecret = "secret"
+	return cfg
+}
+
+func TestValidateConfigAPIKeySecret(t *testing.T) {
+	t.Setenv("API_KEY_SECRET", "")
+	cfg := validConfig()
+	cfg.DBConfig.APIKeySecret = ""
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "apiKeySecret") {
+		t.Fatalf("validateConfig() error = %v, want the missing secret reported", err)
+	}
+
+	t.Setenv("API_KEY_SECRET", "from-env")
+	cfg = validConfig()
+	cfg.DBConfig.APIKeySecret = ""
+	if err := validateConfig(cfg); err != nil || cfg.DBConfig.APIKeySecret != "from-env" {
+		t.Fatalf("validateConfig() = %v with secret %q, want the secret read from the environment", err, cfg.DBConfig.APIKeySecret)
+	}
+}
+
+func TestValidateConfigDefaults(t *testing.T) {
+	cfg := validConfig()
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("validateConfig() error = %v", err)
+	}
+	if cfg.DBConfig.IngestQueue.Size != 10000 || cfg.DBConfig.PricingTableName != "PRICING" || cfg.Metrics.MaxSeries != 2000 {
+		t.Errorf("defaults were not applied: %+v", cfg.DBConfig)
+	}
+}
@@ -1,24 +1,105 @@
 package cost
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
 )
 
-var Pricing PricingModel
+// pricing holds the pricing document loaded from the file or URL. It is replaced as a whole when
+// the pricing is reloaded, so that concurrent cost lookups see either the previous or the new pricing.
+var pricing atomic.Pointer[Version]
+
+func init() {
+	pricing.Store(&Version{Pricing: &PricingModel{}})
+}
+
+// CurrentPricing returns the pricing loaded from the file or URL.
+func CurrentPricing() *PricingModel {
+	return pricing.Load().Pricing
+}
 
 // PricingModel is the pricing information for the different models and features.
 type PricingModel struct {
+	// EffectiveFrom is the time from which the prices apply. Documents without it apply from
+	// the time they are first loaded.
+	EffectiveFrom *time.Time `json:"effectiveFrom,omitempty"`
+
 	Embeddings map[string]float64                       `json:"embeddings"`
 	Images     map[string]map[string]map[string]float64 `json:"images"`
 	Audio      map[string]float64                       `json:"audio"`
-	Chat       map[string]struct {
-		PromptPrice     float64 `json:"promptPrice"`
-		CompletionPrice float64 `json:"completionPrice"`
-	} `json:"chat"`
+	Chat       map[string]ChatPricing                   `json:"chat"`
+	Gemini     ProviderPricing                          `json:"gemini"`
+	VertexAI   ProviderPricing                          `json:"vertexai"`
+	Mistral    ProviderPricing                          `json:"mistral"`
+	// Regions overrides the chat and embeddings prices of models served in a region, such as a
+	// Bedrock or Azure OpenAI region, whose prices differ from the list prices.
+	Regions map[string]ProviderPricing `json:"regions"`
+}
+
+// Request identifies how a usage is priced: the version selects the pricing document, the
+// provider its section, the region its overrides, the model the price, and the tenant the
+// negotiated pricing that applies on top of it.
+type Request struct {
+	Version  int
+	Provider string
+	Region   string
+	Model    string
+	Tenant   Tenant
+}
+
+// ChatPricing is the price of 1000 tokens of a chat model.
+type ChatPricing struct {
+	PromptPrice     float64 `json:"promptPrice"`
+	CompletionPrice float64 `json:"completionPrice"`
+	// CacheReadPrice and CacheWritePrice are the prices of the prompt tokens read from and written
+	// to the prompt cache. They default to the prompt price.
+	CacheReadPrice  float64 `json:"cacheReadPrice,omitempty"`
+	CacheWritePrice float64 `json:"cacheWritePrice,omitempty"`
+}
+
+// ProviderPricing is the pricing of a provider whose models are priced apart from the OpenAI,
+// Cohere and Anthropic models, because the same model names have different prices elsewhere.
+type ProviderPricing struct {
+	Chat       map[string]ChatPricing `json:"chat"`
+	Embeddings map[string]float64     `json:"embeddings"`
+}
+
+// providerSections returns the chat and embeddings pricing used for the endpoints of a provider.
+func (p PricingModel) providerSections(provider string) (map[string]ChatPricing, map[string]float64) {
+	switch provider {
+	case "gemini":
+		return p.Gemini.Chat, p.Gemini.Embeddings
+	case "vertexai":
+		return p.VertexAI.Chat, p.VertexAI.Embeddings
+	case "mistral":
+		return p.Mistral.Chat, p.Mistral.Embeddings
+	}
+	return p.Chat, p.Embeddings
+}
+
+// chatPrice returns the chat price of a request, preferring the price of its region.
+func (p PricingModel) chatPrice(req Request) (ChatPricing, bool) {
+	if price, ok := p.Regions[req.Region].Chat[req.Model]; ok {
+		return price, true
+	}
+	chat, _ := p.providerSections(req.Provider)
+	price, ok := chat[req.Model]
+	return price, ok
+}
+
+// embeddingsPrice returns the embeddings price of a request, preferring the price of its region.
+func (p PricingModel) embeddingsPrice(req Request) (float64, bool) {
+	if price, ok := p.Regions[req.Region].Embeddings[req.Model]; ok {
+		return price, true
+	}
+	_, embeddings := p.providerSections(req.Provider)
+	price, ok := embeddings[req.Model]
+	return price, ok
 }
 
 // validatePricingData validates the pricing data for the different models and features.
@@ -45,90 +126,45 @@ func validatePricingData(pricingModel PricingModel) error {
 		}
 	}
 
-	// Validate the Chat pricing
-	for model, chatPricing := range pricingModel.Chat {
-		if chatPricing.PromptPrice == 0 {
-			return fmt.Errorf("Prompt Tokens pricing data for model '%s' is not defined in the JSON File", model)
-		} else if chatPricing.CompletionPrice == 0 {
-			return fmt.Errorf("Completion Tokens pricing data for model '%s' is not defined in the JSON File", model)
+	// Validate the Chat pricing, including that of the providers priced apart
+	for _, chat := range []map[string]ChatPricing{pricingModel.Chat, pricingModel.Gemini.Chat, pricingModel.VertexAI.Chat, pricingModel.Mistral.Chat} {
+		if err := validateChatPricing(chat); err != nil {
+			return err
 		}
 	}
-
-	return nil
-}
-
-// fetchJSONFromURL is a new function for fetching JSON content from a URL.
-func fetchJSONFromURL(url string) ([]byte, error) {
-	maxRetries := 5
-	var resp *http.Response
-	var err error
-	for i := 0; i < maxRetries; i++ {
-		resp, err = http.Get(url)
-		if err == nil {
-			break
+	for region, overrides := range pricingModel.Regions {
+		if err := validateChatPricing(overrides.Chat); err != nil {
+			return fmt.Errorf("region '%s': %w", region, err)
 		}
 	}
 
-	if err != nil {
-		return nil, fmt.Errorf("Failed to make request to URL %s", url)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Failed to fetch JSON content from URL %s", url)
-	}
-
-	return io.ReadAll(resp.Body)
-}
-
-// fetchJSONFromFile is a new function for fetching JSON content from a URL.
-func fetchJSONFromFile(path string) ([]byte, error) {
-	content, err := os.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to fetch JSON content from file '%s'", path)
-	}
-
-	return content, nil
+	return nil
 }
 
-// LoadPricing loads the pricing information from the given file.
-func LoadPricing(path, url string) error {
-	var content []byte
-	var err error
-
-	switch {
-	case path != "":
-		content, err = fetchJSONFromFile(path)
-	case url != "":
-		content, err = fetchJSONFromURL(url)
-	}
-	if err != nil {
-		return err
-	}
-
-	if err = json.Unmarshal(content, &Pricing); err != nil {
-		return fmt.Errorf("Failed to unmarshal costing JSON: %w", err)
-	}
-
-	if err = validatePricingData(Pricing); err != nil {
-		return err
+// validateChatPricing validates that every chat model has a prompt and a completion price.
+func validateChatPricing(chat map[string]ChatPricing) error {
+	for model, chatPricing := range chat {
+		if chatPricing.PromptPrice == 0 {
+			return fmt.Errorf("Prompt Tokens pricing data for model '%s' is not defined in the JSON File", model)
+		} else if chatPricing.CompletionPrice == 0 {
+			return fmt.Errorf("Completion Tokens pricing data for model '%s' is not defined in the JSON File", model)
+		}
 	}
-
 	return nil
 }
 
-// calculateEmbeddingsCost calculates the cost for embeddings based on the model and prompt tokens.
-func CalculateEmbeddingsCost(promptTokens float64, model string) (float64, error) {
-	price, ok := Pricing.Embeddings[model]
+// CalculateEmbeddingsCost calculates the cost for embeddings based on the request and prompt tokens.
+func CalculateEmbeddingsCost(req Request, promptTokens float64) (float64, error) {
+	price, ok := negotiatedEmbeddingsPrice(req)
 	if !ok {
 		return 0, nil
 	}
 	return (promptTokens / 1000) * price, nil
 }
 
-// calculateImageCost calculates the cost for images based on the model, image size, and quality.
-func CalculateImageCost(model, imageSize, quality string) (float64, error) {
-	models, ok := Pricing.Images[model]
+// CalculateImageCost calculates the cost for images based on the request, image size, and quality.
+func CalculateImageCost(req Request, imageSize, quality string) (float64, error) {
+	models, ok := versionPricing(req.Version).Images[req.Model]
 	if !ok {
 		return 0, nil
 	}
@@ -141,23 +177,70 @@ func CalculateImageCost(model, imageSize, quality string) (float64, error) {
 		return 0, nil
 	}
 
-	return price, nil
+	return overrideFor(req).apply(price), nil
 }
 
-// calculateChatCost calculates the cost for chat based on the model, prompt tokens, and completion tokens.
-func CalculateChatCost(promptTokens, completionTokens float64, model string) (float64, error) {
-	chatModel, ok := Pricing.Chat[model]
+// CalculateChatCost calculates the cost for chat based on the request, prompt tokens, and completion tokens.
+func CalculateChatCost(req Request, promptTokens, completionTokens float64) (float64, error) {
+	chatModel, ok := negotiatedChatPrice(req)
 	if !ok {
 		return 0, nil
 	}
 	return ((promptTokens / 1000) * chatModel.PromptPrice) + ((completionTokens / 1000) * chatModel.CompletionPrice), nil
 }
 
-// CalculateAudioCost calculates the cost for Audio based on the model, and prompt.
-func CalculateAudioCost(prompt string, model string) (float64, error) {
-	price, ok := Pricing.Audio[model]
+// CalculateCacheCost calculates the cost of the prompt tokens read from and written to the prompt cache
+// of a chat model.
+func CalculateCacheCost(req Request, cacheReadTokens, cacheWriteTokens float64) (float64, error) {
+	chatModel, ok := negotiatedChatPrice(req)
 	if !ok {
 		return 0, nil
 	}
-	return ((float64(len(prompt)) / 1000) * price), nil
+	readPrice, writePrice := chatModel.CacheReadPrice, chatModel.CacheWritePrice
+	if readPrice == 0 {
+		readPrice = chatModel.PromptPrice
+	}
+	if writePrice == 0 {
+		writePrice = chatModel.PromptPrice
+	}
+	return ((cacheReadTokens / 1000) * readPrice) + ((cacheWriteTokens / 1000) * writePrice), nil
+}
+
+// CalculateAudioCost calculates the cost for Audio based on the request, and prompt.
+func CalculateAudioCost(req Request, prompt string) (float64, error) {
+	price, ok := versionPricing(req.Version).Audio[req.Model]
+	if !ok {
+		return 0, nil
+	}
+	return ((float64(len(prompt)) / 1000) * overrideFor(req).apply(price)), nil
+}
+
+// characterTokenizedModels are the prefixes of the models whose tokenizer is not close to any
+// tiktoken encoding. Their tokens are estimated from the number of characters instead.
+var characterTokenizedModels = []string{"gemini", "text-embedding-00", "text-multilingual-embedding", "textembedding-gecko"}
+
+// CountTokens counts the tokens of a text with the tokenizer of the model, or with the
+// cl100k_base encoding when the model is not known to the tokenizer, such as Claude and
+// Mistral models. Gemini models are estimated at four characters per token, like the
+// encodings when they cannot be downloaded.
+func CountTokens(text, model string) int {
+	for _, prefix := range characterTokenizedModels {
+		if strings.HasPrefix(model, prefix) {
+			return estimateTokens(text)
+		}
+	}
+
+	tkm, err := tiktoken.EncodingForModel(model)
+	if err != nil {
+		tkm, err = tiktoken.GetEncoding("cl100k_base")
+	}
+	if err != nil {
+		return estimateTokens(text)
+	}
+	return len(tkm.Encode(text, nil, nil))
+}
+
+// estimateTokens estimates the tokens of a text at four characters per token.
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
 }
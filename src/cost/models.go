@@ -0,0 +1,116 @@
+package cost
+
+import (
+	"fmt"
+	"strings"
+
+	"ingester/config"
+)
+
+// Deployment maps the name of an Azure OpenAI deployment, which SDKs report instead of the model,
+// to the model it serves and the region it runs in.
+type Deployment struct {
+	Model  string
+	Region string
+}
+
+// deployments holds the configured deployments by name.
+var deployments = map[string]Deployment{}
+
+// bedrockProviders are the model providers Bedrock prefixes its model IDs with.
+var bedrockProviders = map[string]bool{
+	"ai21":      true,
+	"amazon":    true,
+	"anthropic": true,
+	"cohere":    true,
+	"meta":      true,
+	"mistral":   true,
+	"stability": true,
+}
+
+// bedrockRegionPrefixes are the prefixes of the model IDs of Bedrock cross-region inference profiles.
+var bedrockRegionPrefixes = []string{"us.", "eu.", "apac."}
+
+// bedrockModelNames maps the Bedrock model IDs, without their provider, whose name differs from
+// the pricing key of the model.
+var bedrockModelNames = map[string]string{
+	"claude-v2":              "claude-2",
+	"claude-v2:1":            "claude-2.1",
+	"claude-instant-v1":      "claude-instant-1",
+	"command-text-v14":       "command",
+	"command-light-text-v14": "command-light",
+}
+
+// Init loads the Azure OpenAI deployments and the pricing overrides of the 'pricingInfo' configuration.
+func Init(cfg config.Configuration) error {
+	deployments = map[string]Deployment{}
+	for i, deployment := range cfg.PricingInfo.Deployments {
+		if deployment.Name == "" || deployment.Model == "" {
+			return fmt.Errorf("'pricingInfo.deployments[%d]' must set 'name' and 'model'", i)
+		}
+		if _, exists := deployments[deployment.Name]; exists {
+			return fmt.Errorf("'pricingInfo.deployments[%d]' redefines deployment '%s'", i, deployment.Name)
+		}
+		deployments[deployment.Name] = Deployment{Model: deployment.Model, Region: deployment.Region}
+	}
+	return loadOverrides(cfg)
+}
+
+// ResolveModel returns the pricing key of the model reported by a request, and the region of its
+// deployment if it has one. Azure OpenAI deployment names are replaced by their model and Bedrock
+// model IDs are normalised, so that 'anthropic.claude-v2' is priced as 'claude-2'.
+func ResolveModel(model string) (string, string) {
+	if deployment, ok := deployments[model]; ok {
+		return deployment.Model, deployment.Region
+	}
+	return normalizeBedrockModel(model), ""
+}
+
+// normalizeBedrockModel turns a Bedrock model ID or ARN, such as
+// 'us.anthropic.claude-3-haiku-20240307-v1:0', into the pricing key of the model. Other model
+// names are returned unchanged.
+func normalizeBedrockModel(model string) string {
+	id := model
+	if strings.HasPrefix(id, "arn:") {
+		id = id[strings.LastIndex(id, "/")+1:]
+	}
+	for _, prefix := range bedrockRegionPrefixes {
+		id = strings.TrimPrefix(id, prefix)
+	}
+
+	provider, name, found := strings.Cut(id, ".")
+	if !found || !bedrockProviders[provider] {
+		return model
+	}
+
+	if pricingKey, ok := bedrockModelNames[name]; ok {
+		return pricingKey
+	}
+	// Drop the version of the model ID, such as '-v1:0' or ':7:4k', the names are looked up at each step
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i]
+	}
+	if pricingKey, ok := bedrockModelNames[name]; ok {
+		return pricingKey
+	}
+	if i := strings.LastIndex(name, "-v"); i >= 0 && isNumber(name[i+2:]) {
+		name = name[:i]
+	}
+	if pricingKey, ok := bedrockModelNames[name]; ok {
+		return pricingKey
+	}
+	return name
+}
+
+// isNumber reports whether a string is a non-empty run of digits.
+func isNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
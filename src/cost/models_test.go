@@ -0,0 +1,145 @@
+package cost
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"ingester/config"
+)
+
+// deploymentConfig is the configuration of an Azure OpenAI deployment.
+type deploymentConfig = struct {
+	Name   string `yaml:"name"`
+	Model  string `yaml:"model"`
+	Region string `yaml:"region"`
+}
+
+// withPricingState restores the loaded pricing, the stored pricing versions, the pricing source and
+// the configured deployments and overrides when the test ends, so that the test can replace them.
+func withPricingState(t *testing.T) {
+	t.Helper()
+	loaded, stored := pricing.Load(), versions.Load()
+	configuredDeployments, configuredOverrides := deployments, overrides
+	t.Cleanup(func() {
+		pricing.Store(loaded)
+		versions.Store(stored)
+		deployments, overrides = configuredDeployments, configuredOverrides
+
+		source.mu.Lock()
+		defer source.mu.Unlock()
+		source.generation++
+		source.path, source.url, source.etag, source.lastModified, source.modTime = "", "", "", "", time.Time{}
+	})
+}
+
+// setLoadedPricing replaces the pricing document loaded from the file or URL.
+func setLoadedPricing(pricingModel *PricingModel) {
+	pricing.Store(&Version{Pricing: pricingModel})
+}
+
+// configure loads the deployments and overrides of the configuration.
+func configure(t *testing.T, cfg config.Configuration) {
+	t.Helper()
+	if err := Init(cfg); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+}
+
+func TestNormalizeBedrockModel(t *testing.T) {
+	tests := map[string]string{
+		"anthropic.claude-v2":                                             "claude-2",
+		"anthropic.claude-v2:1":                                           "claude-2.1",
+		"anthropic.claude-instant-v1":                                     "claude-instant-1",
+		"anthropic.claude-3-haiku-20240307-v1:0":                          "claude-3-haiku-20240307",
+		"us.anthropic.claude-3-haiku-20240307-v1:0":                       "claude-3-haiku-20240307",
+		"eu.anthropic.claude-3-sonnet-20240229-v1:0":                      "claude-3-sonnet-20240229",
+		"cohere.command-text-v14":                                         "command",
+		"cohere.command-light-text-v14:7":                                 "command-light",
+		"cohere.command-text-v14:7:4k":                                    "command",
+		"anthropic.claude-3-haiku-20240307-v1:0:200k":                     "claude-3-haiku-20240307",
+		"amazon.titan-embed-text-v1":                                      "titan-embed-text",
+		"meta.llama3-70b-instruct-v1:0":                                   "llama3-70b-instruct",
+		"arn:aws:bedrock:us-east-1::foundation-model/anthropic.claude-v2": "claude-2",
+		"arn:aws:bedrock:us-east-1:123456789012:inference-profile/us.anthropic.claude-3-haiku-20240307-v1:0": "claude-3-haiku-20240307",
+		// Other model names are left unchanged
+		"gpt-4":          "gpt-4",
+		"claude-3-opus":  "claude-3-opus",
+		"unknown.model":  "unknown.model",
+		"gpt-4o-2024-v2": "gpt-4o-2024-v2",
+	}
+	for model, want := range tests {
+		if got := normalizeBedrockModel(model); got != want {
+			t.Errorf("normalizeBedrockModel(%q) = %q, want %q", model, got, want)
+		}
+	}
+}
+
+func TestResolveModelDeployments(t *testing.T) {
+	withPricingState(t)
+	var cfg config.Configuration
+	cfg.PricingInfo.Deployments = []deploymentConfig{
+		{Name: "prod-gpt4", Model: "gpt-4", Region: "eastus"},
+		{Name: "anthropic.claude-v2", Model: "claude-2.1"},
+	}
+	configure(t, cfg)
+
+	if model, region := ResolveModel("prod-gpt4"); model != "gpt-4" || region != "eastus" {
+		t.Errorf("ResolveModel(deployment) = %q, %q, want gpt-4 in eastus", model, region)
+	}
+	// Deployments take precedence over the Bedrock model IDs
+	if model, _ := ResolveModel("anthropic.claude-v2"); model != "claude-2.1" {
+		t.Errorf("ResolveModel() = %q, want the model of the deployment", model)
+	}
+	if model, region := ResolveModel("anthropic.claude-instant-v1"); model != "claude-instant-1" || region != "" {
+		t.Errorf("ResolveModel(bedrock) = %q, %q", model, region)
+	}
+	if model, _ := ResolveModel("gpt-4o"); model != "gpt-4o" {
+		t.Errorf("ResolveModel(gpt-4o) = %q, want the model unchanged", model)
+	}
+}
+
+func TestInitRejectsInvalidDeployments(t *testing.T) {
+	withPricingState(t)
+	tests := map[string][]deploymentConfig{
+		"without a model": {{Name: "prod"}},
+		"without a name":  {{Model: "gpt-4"}},
+		"redefined":       {{Name: "prod", Model: "gpt-4"}, {Name: "prod", Model: "gpt-4o"}},
+	}
+	for name, configured := range tests {
+		t.Run(name, func(t *testing.T) {
+			var cfg config.Configuration
+			cfg.PricingInfo.Deployments = configured
+			if err := Init(cfg); err == nil || !strings.Contains(err.Error(), "pricingInfo.deployments") {
+				t.Errorf("Init() error = %v, want the deployment reported", err)
+			}
+		})
+	}
+}
+
+func TestRegionalPrices(t *testing.T) {
+	withPricingState(t)
+	setLoadedPricing(&PricingModel{
+		Chat:       map[string]ChatPricing{"gpt-4": {PromptPrice: 0.03, CompletionPrice: 0.06}},
+		Embeddings: map[string]float64{"ada": 0.0001},
+		Regions: map[string]ProviderPricing{
+			"eastus": {Chat: map[string]ChatPricing{"gpt-4": {PromptPrice: 0.033, CompletionPrice: 0.066}}},
+		},
+	})
+
+	regional, _ := CalculateChatCost(Request{Provider: "openai", Region: "eastus", Model: "gpt-4"}, 1000, 1000)
+	list, _ := CalculateChatCost(Request{Provider: "openai", Region: "westus", Model: "gpt-4"}, 1000, 1000)
+	if !floatEquals(regional, 0.099) || !floatEquals(list, 0.09) {
+		t.Errorf("chat cost = %v in the region and %v elsewhere, want 0.099 and 0.09", regional, list)
+	}
+	// Models without a regional price use the list price
+	if embeddings, _ := CalculateEmbeddingsCost(Request{Provider: "openai", Region: "eastus", Model: "ada"}, 1000); !floatEquals(embeddings, 0.0001) {
+		t.Errorf("embeddings cost = %v, want the list price", embeddings)
+	}
+}
+
+// floatEquals reports whether two costs are equal up to rounding errors.
+func floatEquals(a, b float64) bool {
+	const epsilon = 1e-9
+	return a-b < epsilon && b-a < epsilon
+}
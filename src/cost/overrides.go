@@ -0,0 +1,150 @@
+package cost
+
+import (
+	"fmt"
+	"strings"
+
+	"ingester/config"
+)
+
+// Tenant identifies who a usage is priced for, so that the prices negotiated by an organization
+// apply to its records.
+type Tenant struct {
+	OrgID           int
+	APIKey          string // APIKey is the name of the API key that pushed the record.
+	ApplicationName string
+}
+
+// override is a negotiated pricing that sits on top of the pricing document for the records of an
+// organization, API key or application. Models with a price of their own are priced with it, the
+// others get the discount on their price.
+type override struct {
+	name            string
+	orgID           int
+	apiKey          string
+	applicationName string
+	provider        string
+	discount        float64 // discount is the percentage taken off the prices of the pricing document.
+	chat            map[string]ChatPricing
+	embeddings      map[string]float64
+}
+
+// overrides holds the configured pricing overrides, in the order of the configuration.
+var overrides []override
+
+// loadOverrides loads the pricing overrides of the 'pricingInfo' configuration.
+func loadOverrides(cfg config.Configuration) error {
+	overrides = nil
+	for i, o := range cfg.PricingInfo.Overrides {
+		if o.OrgID == 0 && o.APIKey == "" && o.ApplicationName == "" {
+			return fmt.Errorf("'pricingInfo.overrides[%d]' must set 'orgId', 'apiKey' or 'applicationName'", i)
+		}
+		// Key names are only unique within an organization
+		if o.APIKey != "" && o.OrgID == 0 {
+			return fmt.Errorf("'pricingInfo.overrides[%d]' must set 'orgId' along with 'apiKey'", i)
+		}
+		if o.Discount < 0 || o.Discount > 100 {
+			return fmt.Errorf("'pricingInfo.overrides[%d].discount' must be between 0 and 100", i)
+		}
+		if o.Discount == 0 && len(o.Chat) == 0 && len(o.Embeddings) == 0 {
+			return fmt.Errorf("'pricingInfo.overrides[%d]' must set 'discount', 'chat' or 'embeddings'", i)
+		}
+
+		chat := map[string]ChatPricing{}
+		for model, price := range o.Chat {
+			chat[model] = ChatPricing{PromptPrice: price.PromptPrice, CompletionPrice: price.CompletionPrice,
+				CacheReadPrice: price.CacheReadPrice, CacheWritePrice: price.CacheWritePrice}
+		}
+		if err := validateChatPricing(chat); err != nil {
+			return fmt.Errorf("'pricingInfo.overrides[%d]': %w", i, err)
+		}
+		for model, price := range o.Embeddings {
+			if price <= 0 {
+				return fmt.Errorf("'pricingInfo.overrides[%d]': Embeddings pricing data for model '%s' must be positive", i, model)
+			}
+		}
+
+		name := o.Name
+		if name == "" {
+			name = fmt.Sprintf("overrides[%d]", i)
+		}
+		// API key names are stored in lower case
+		overrides = append(overrides, override{name: name, orgID: o.OrgID, apiKey: strings.ToLower(o.APIKey),
+			applicationName: o.ApplicationName, provider: o.Provider, discount: o.Discount, chat: chat, embeddings: o.Embeddings})
+	}
+	return nil
+}
+
+// matches reports whether the override applies to a request.
+func (o override) matches(req Request) bool {
+	return (o.orgID == 0 || o.orgID == req.Tenant.OrgID) &&
+		(o.apiKey == "" || o.apiKey == req.Tenant.APIKey) &&
+		(o.applicationName == "" || o.applicationName == req.Tenant.ApplicationName) &&
+		(o.provider == "" || o.provider == req.Provider)
+}
+
+// specificity is the number of conditions of the override, so that an override for an API key or
+// an application wins over one for the whole organization.
+func (o override) specificity() int {
+	n := 0
+	for _, set := range []bool{o.orgID != 0, o.apiKey != "", o.applicationName != "", o.provider != ""} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+// apply takes the discount of the override off a price.
+func (o *override) apply(price float64) float64 {
+	if o == nil {
+		return price
+	}
+	return price * (100 - o.discount) / 100
+}
+
+// overrideFor returns the most specific override that applies to a request, the first one
+// configured on a tie, or nil when none applies.
+func overrideFor(req Request) *override {
+	var found *override
+	for i := range overrides {
+		if overrides[i].matches(req) && (found == nil || overrides[i].specificity() > found.specificity()) {
+			found = &overrides[i]
+		}
+	}
+	return found
+}
+
+// HasOverride reports whether a pricing override applies to a request.
+func HasOverride(req Request) bool {
+	return overrideFor(req) != nil
+}
+
+// negotiatedChatPrice returns the chat price of a request, with the override of its tenant applied.
+func negotiatedChatPrice(req Request) (ChatPricing, bool) {
+	o := overrideFor(req)
+	if o != nil {
+		if price, ok := o.chat[req.Model]; ok {
+			return price, true
+		}
+	}
+	price, ok := versionPricing(req.Version).chatPrice(req)
+	if !ok {
+		return price, false
+	}
+	return ChatPricing{PromptPrice: o.apply(price.PromptPrice), CompletionPrice: o.apply(price.CompletionPrice),
+		CacheReadPrice: o.apply(price.CacheReadPrice), CacheWritePrice: o.apply(price.CacheWritePrice)}, true
+}
+
+// negotiatedEmbeddingsPrice returns the embeddings price of a request, with the override of its
+// tenant applied.
+func negotiatedEmbeddingsPrice(req Request) (float64, bool) {
+	o := overrideFor(req)
+	if o != nil {
+		if price, ok := o.embeddings[req.Model]; ok {
+			return price, true
+		}
+	}
+	price, ok := versionPricing(req.Version).embeddingsPrice(req)
+	return o.apply(price), ok
+}
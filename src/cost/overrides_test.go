@@ -0,0 +1,123 @@
+package cost
+
+import (
+	"strings"
+	"testing"
+
+	"ingester/config"
+)
+
+// overrideConfig is the configuration of a pricing override.
+type overrideConfig = struct {
+	Name            string  `yaml:"name"`
+	OrgID           int     `yaml:"orgId"`
+	APIKey          string  `yaml:"apiKey"`
+	ApplicationName string  `yaml:"applicationName"`
+	Provider        string  `yaml:"provider"`
+	Discount        float64 `yaml:"discount"`
+	Chat            map[string]struct {
+		PromptPrice     float64 `yaml:"promptPrice"`
+		CompletionPrice float64 `yaml:"completionPrice"`
+		CacheReadPrice  float64 `yaml:"cacheReadPrice"`
+		CacheWritePrice float64 `yaml:"cacheWritePrice"`
+	} `yaml:"chat"`
+	Embeddings map[string]float64 `yaml:"embeddings"`
+}
+
+// configureOverrides loads the pricing overrides of a test.
+func configureOverrides(t *testing.T, configured ...overrideConfig) {
+	t.Helper()
+	var cfg config.Configuration
+	cfg.PricingInfo.Overrides = configured
+	configure(t, cfg)
+}
+
+func TestOverrideSelection(t *testing.T) {
+	withPricingState(t)
+	configureOverrides(t,
+		overrideConfig{Name: "org", OrgID: 2, Discount: 10},
+		overrideConfig{Name: "org-openai", OrgID: 2, Provider: "openai", Discount: 20},
+		overrideConfig{Name: "key", OrgID: 2, APIKey: "Batch", Discount: 30},
+		overrideConfig{Name: "app", ApplicationName: "chatbot", Discount: 40},
+		overrideConfig{Name: "other-org", OrgID: 3, Discount: 50},
+	)
+
+	tests := []struct {
+		name string
+		req  Request
+		want string
+	}{
+		{"organization", Request{Provider: "anthropic", Tenant: Tenant{OrgID: 2}}, "org"},
+		{"provider wins over organization", Request{Provider: "openai", Tenant: Tenant{OrgID: 2}}, "org-openai"},
+		{"key of the organization", Request{Provider: "anthropic", Tenant: Tenant{OrgID: 2, APIKey: "batch"}}, "key"},
+		{"key of another organization", Request{Provider: "anthropic", Tenant: Tenant{OrgID: 4, APIKey: "batch"}}, ""},
+		{"application in any organization", Request{Provider: "anthropic", Tenant: Tenant{OrgID: 4, ApplicationName: "chatbot"}}, "app"},
+		{"first configured on a tie", Request{Provider: "anthropic", Tenant: Tenant{OrgID: 3, ApplicationName: "chatbot"}}, "app"},
+		{"no match", Request{Provider: "openai", Tenant: Tenant{OrgID: 5}}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ""
+			if o := overrideFor(test.req); o != nil {
+				got = o.name
+			}
+			if got != test.want {
+				t.Errorf("overrideFor() = %q, want %q", got, test.want)
+			}
+			if HasOverride(test.req) != (test.want != "") {
+				t.Errorf("HasOverride() = %v, want %v", HasOverride(test.req), test.want != "")
+			}
+		})
+	}
+}
+
+func TestOverridePrices(t *testing.T) {
+	withPricingState(t)
+	setLoadedPricing(&PricingModel{
+		Chat:       map[string]ChatPricing{"gpt-4": {PromptPrice: 0.03, CompletionPrice: 0.06}, "gpt-4o": {PromptPrice: 0.005, CompletionPrice: 0.015}},
+		Embeddings: map[string]float64{"ada": 0.0001},
+	})
+	negotiated := overrideConfig{OrgID: 2, Discount: 50, Embeddings: map[string]float64{"ada": 0.00002}}
+	negotiated.Chat = map[string]struct {
+		PromptPrice     float64 `yaml:"promptPrice"`
+		CompletionPrice float64 `yaml:"completionPrice"`
+		CacheReadPrice  float64 `yaml:"cacheReadPrice"`
+		CacheWritePrice float64 `yaml:"cacheWritePrice"`
+	}{"gpt-4": {PromptPrice: 0.02, CompletionPrice: 0.04}}
+	configureOverrides(t, negotiated)
+
+	tenant := Tenant{OrgID: 2}
+	listed, _ := CalculateChatCost(Request{Provider: "openai", Model: "gpt-4", Tenant: tenant}, 1000, 1000)
+	discounted, _ := CalculateChatCost(Request{Provider: "openai", Model: "gpt-4o", Tenant: tenant}, 1000, 1000)
+	list, _ := CalculateChatCost(Request{Provider: "openai", Model: "gpt-4", Tenant: Tenant{OrgID: 3}}, 1000, 1000)
+	embeddings, _ := CalculateEmbeddingsCost(Request{Provider: "openai", Model: "ada", Tenant: tenant}, 1000)
+
+	if !floatEquals(listed, 0.06) || !floatEquals(discounted, 0.01) || !floatEquals(list, 0.09) || !floatEquals(embeddings, 0.00002) {
+		t.Errorf("costs = %v, %v, %v, %v, want the override price, the discounted price, the list price and the override price",
+			listed, discounted, list, embeddings)
+	}
+}
+
+func TestLoadOverridesRejectsInvalid(t *testing.T) {
+	withPricingState(t)
+	tests := map[string]struct {
+		override overrideConfig
+		want     string
+	}{
+		"without a condition":      {overrideConfig{Discount: 10}, "must set 'orgId', 'apiKey' or 'applicationName'"},
+		"key without organization": {overrideConfig{APIKey: "batch", Discount: 10}, "must set 'orgId' along with 'apiKey'"},
+		"discount over 100":        {overrideConfig{OrgID: 2, Discount: 120}, "between 0 and 100"},
+		"without prices":           {overrideConfig{OrgID: 2}, "must set 'discount', 'chat' or 'embeddings'"},
+		"negative embeddings":      {overrideConfig{OrgID: 2, Embeddings: map[string]float64{"ada": -1}}, "must be positive"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var cfg config.Configuration
+			cfg.PricingInfo.Overrides = []overrideConfig{test.override}
+			err := loadOverrides(cfg)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("loadOverrides() error = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package cost
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"ingester/config"
+	"ingester/metrics"
+
+	"github.com/rs/zerolog/log"
+)
+
+// pricingSource is where the pricing is loaded from, with the validators of the last document
+// that was loaded so that an unchanged document is not fetched and parsed again.
+type pricingSource struct {
+	mu           sync.Mutex
+	generation   int // generation counts the changes of the source and of the pricing loaded from it.
+	path         string
+	url          string
+	etag         string    // etag is the ETag of the last document loaded from the URL.
+	lastModified string    // lastModified is the Last-Modified header of the last document loaded from the URL.
+	modTime      time.Time // modTime is the modification time of the last file loaded.
+}
+
+// source is the pricing source set by LoadPricing.
+var source pricingSource
+
+// fetchClient is the HTTP client the pricing documents are fetched with.
+var fetchClient = &http.Client{Timeout: 30 * time.Second}
+
+// fetchRetry is how a pricing document is requested again after a failure.
+type fetchRetry struct {
+	attempts int           // attempts is the number of times the document is requested before giving up.
+	backoff  time.Duration // backoff is the wait before the first retry, doubled for every further retry.
+}
+
+// backgroundRetry is the retry of the reloads that nobody waits for, on start, on the refresh
+// interval and on SIGHUP.
+var backgroundRetry = fetchRetry{attempts: 5, backoff: time.Second}
+
+// fetchJSONFromURL fetches JSON content from a URL, unless it did not change since the document
+// with the given validators was fetched. It returns no content when the document did not change.
+// Requests that fail or get a server error are retried with an exponential backoff, until the
+// attempts run out or the context is done.
+func fetchJSONFromURL(ctx context.Context, url, etag, lastModified string, retry fetchRetry) ([]byte, http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to create request to URL %s", url)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	var resp *http.Response
+	for attempt := 0; attempt < retry.attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(retry.backoff << (attempt - 1)):
+			case <-ctx.Done():
+				return nil, nil, fmt.Errorf("Failed to make request to URL %s: %w", url, ctx.Err())
+			}
+		}
+		resp, err = fetchClient.Do(req)
+		if err == nil && resp.StatusCode < 500 {
+			break
+		}
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+		log.Debug().Err(err).Msgf("Attempt %d of %d to fetch the pricing from URL %s failed", attempt+1, retry.attempts, url)
+	}
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to make request to URL %s", url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, resp.Header, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("Failed to fetch JSON content from URL %s", url)
+	}
+
+	content, err := io.ReadAll(resp.Body)
+	return content, resp.Header, err
+}
+
+// fetchJSONFromFile fetches JSON content from a file, unless it was not modified since the given time.
+// It returns no content when the file was not modified.
+func fetchJSONFromFile(path string, modTime time.Time) ([]byte, time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, modTime, fmt.Errorf("Failed to fetch JSON content from file '%s'", path)
+	}
+	if info.ModTime().Equal(modTime) {
+		return nil, modTime, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, modTime, fmt.Errorf("Failed to fetch JSON content from file '%s'", path)
+	}
+
+	return content, info.ModTime(), nil
+}
+
+// LoadPricing loads the pricing information from the given file or URL, which the pricing is
+// reloaded from afterwards.
+func LoadPricing(path, url string) error {
+	source.mu.Lock()
+	source.generation++
+	source.path, source.url = path, url
+	source.etag, source.lastModified, source.modTime = "", "", time.Time{}
+	source.mu.Unlock()
+
+	_, err := Reload()
+	return err
+}
+
+// LoadConfiguredPricing loads the pricing from the URL of the 'pricingInfo' configuration, or from its
+// local file when no URL is set.
+func LoadConfiguredPricing(cfg config.Configuration) error {
+	if cfg.PricingInfo.URL != "" {
+		log.Info().Msgf("Initializing LLM Pricing Information from URL '%s'", cfg.PricingInfo.URL)
+		return LoadPricing("", cfg.PricingInfo.URL)
+	}
+	log.Info().Msgf("Initializing LLM Pricing Information from local file '%s'", cfg.PricingInfo.LocalFile.Path)
+	return LoadPricing(cfg.PricingInfo.LocalFile.Path, "")
+}
+
+// Reload fetches the pricing again and puts it in use if it changed. It reports whether the
+// pricing was updated. A document that cannot be parsed or fails validation is rejected and
+// the pricing in use is kept. The source is not locked while the document is fetched, and a
+// document fetched while the source or the pricing changed is discarded.
+func Reload() (bool, error) {
+	return reload(context.Background(), backgroundRetry)
+}
+
+// ReloadOnce reloads the pricing like Reload, for a caller that waits for the outcome: the
+// pricing URL is requested a single time and the request is canceled with the context.
+func ReloadOnce(ctx context.Context) (bool, error) {
+	return reload(ctx, fetchRetry{attempts: 1})
+}
+
+// reload reloads the pricing, fetching it from a URL with the given retry.
+func reload(ctx context.Context, retry fetchRetry) (bool, error) {
+	source.mu.Lock()
+	generation, path, url := source.generation, source.path, source.url
+	etag, lastModified, modTime := source.etag, source.lastModified, source.modTime
+	source.mu.Unlock()
+
+	var content []byte
+	var err error
+
+	switch {
+	case path != "":
+		content, modTime, err = fetchJSONFromFile(path, modTime)
+	case url != "":
+		var header http.Header
+		content, header, err = fetchJSONFromURL(ctx, url, etag, lastModified, retry)
+		if err == nil {
+			etag, lastModified = header.Get("ETag"), header.Get("Last-Modified")
+		}
+	default:
+		err = fmt.Errorf("PricingInfo configuration is not defined")
+	}
+	if err != nil {
+		metrics.PricingReloads.Inc("failed")
+		return false, err
+	}
+	if content == nil {
+		metrics.PricingReloads.Inc("unchanged")
+		return false, nil
+	}
+
+	pricingModel, err := ParseDocument(content)
+	if err != nil {
+		metrics.PricingReloads.Inc("failed")
+		return false, err
+	}
+	effectiveFrom := time.Now()
+	if pricingModel.EffectiveFrom != nil {
+		effectiveFrom = *pricingModel.EffectiveFrom
+	}
+
+	// The validators are only kept once the document is in use, so a rejected document is
+	// fetched again on the next reload. A document is dropped when the source was changed or
+	// another reload put a document in use while it was fetched.
+	source.mu.Lock()
+	if source.generation != generation {
+		source.mu.Unlock()
+		metrics.PricingReloads.Inc("unchanged")
+		return false, nil
+	}
+	source.generation++
+	pricing.Store(&Version{EffectiveFrom: effectiveFrom, Document: content, Checksum: documentChecksum(content), Pricing: pricingModel})
+	source.etag, source.lastModified, source.modTime = etag, lastModified, modTime
+	source.mu.Unlock()
+	metrics.PricingReloads.Inc("updated")
+
+	runReloadHooks()
+	return true, nil
+}
+
+// StartRefresh reloads the pricing on the given interval until the process exits.
+func StartRefresh(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ReloadAndLog("refresh interval")
+		}
+	}()
+}
+
+// ReloadAndLog reloads the pricing and logs the outcome along with what triggered the reload.
+func ReloadAndLog(trigger string) {
+	updated, err := Reload()
+	switch {
+	case err != nil:
+		log.Error().Err(err).Msgf("Failed to reload LLM pricing information on %s, keeping the last good pricing", trigger)
+	case updated:
+		log.Info().Msgf("Reloaded LLM pricing information on %s", trigger)
+	default:
+		log.Debug().Msgf("LLM pricing information is unchanged on %s", trigger)
+	}
+}
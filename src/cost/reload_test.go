@@ -0,0 +1,160 @@
+package cost
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ingester/config"
+)
+
+// pricingDocument reads the pricing document shipped with the ingester.
+func pricingDocument(t *testing.T) []byte {
+	t.Helper()
+	content, err := os.ReadFile("../../assets/pricing.json")
+	if err != nil {
+		t.Fatalf("reading the pricing document: %v", err)
+	}
+	return content
+}
+
+// fastRetry is the retry of the tests, which do not wait between the attempts.
+var fastRetry = fetchRetry{attempts: backgroundRetry.attempts, backoff: time.Millisecond}
+
+func TestFetchJSONFromURLRetriesServerErrors(t *testing.T) {
+	document := pricingDocument(t)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(document)
+	}))
+	defer server.Close()
+
+	content, header, err := fetchJSONFromURL(context.Background(), server.URL, "", "", fastRetry)
+	if err != nil {
+		t.Fatalf("fetchJSONFromURL() error = %v", err)
+	}
+	if len(content) != len(document) || header.Get("ETag") != `"v1"` || requests.Load() != 3 {
+		t.Errorf("fetched %d bytes after %d requests, want the document after 3", len(content), requests.Load())
+	}
+}
+
+func TestFetchJSONFromURLGivesUp(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	if _, _, err := fetchJSONFromURL(context.Background(), server.URL, "", "", fastRetry); err == nil {
+		t.Fatal("fetchJSONFromURL() succeeded, want an error")
+	}
+	if requests.Load() != int32(fastRetry.attempts) {
+		t.Errorf("requests = %d, want %d", requests.Load(), fastRetry.attempts)
+	}
+
+	// Client errors are not retried
+	requests.Store(0)
+	notFound := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer notFound.Close()
+	if _, _, err := fetchJSONFromURL(context.Background(), notFound.URL, "", "", fastRetry); err == nil || requests.Load() != 1 {
+		t.Errorf("fetchJSONFromURL() = %v after %d requests, want an error after one", err, requests.Load())
+	}
+}
+
+func TestReloadDoesNotLockSourceWhileFetching(t *testing.T) {
+	withPricingState(t)
+	document := pricingDocument(t)
+
+	var lockedDuringFetch atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !source.mu.TryLock() {
+			lockedDuringFetch.Store(true)
+		} else {
+			source.mu.Unlock()
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(document)
+	}))
+	defer server.Close()
+
+	if err := LoadPricing("", server.URL); err != nil {
+		t.Fatalf("LoadPricing() error = %v", err)
+	}
+	if lockedDuringFetch.Load() {
+		t.Error("the source was locked while the document was fetched")
+	}
+	if updated, err := Reload(); err != nil || updated {
+		t.Errorf("Reload() = %v, %v, want the unchanged document skipped", updated, err)
+	}
+}
+
+func TestReloadOnceIsBounded(t *testing.T) {
+	withPricingState(t)
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	source.mu.Lock()
+	source.generation++
+	source.url = server.URL
+	source.mu.Unlock()
+
+	// A server error is not retried
+	if updated, err := ReloadOnce(context.Background()); err == nil || updated || requests.Load() != 1 {
+		t.Errorf("ReloadOnce() = %v, %v after %d requests, want an error after one", updated, err, requests.Load())
+	}
+
+	// A request that hangs is canceled with the context
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := ReloadOnce(ctx); err == nil || time.Since(start) > 5*time.Second {
+		t.Errorf("ReloadOnce() = %v after %v, want an error once the context is done", err, time.Since(start))
+	}
+}
+
+func TestLoadConfiguredPricingPrefersURL(t *testing.T) {
+	withPricingState(t)
+
+	document := pricingDocument(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(document)
+	}))
+	defer server.Close()
+
+	// The missing file is not read when a URL is set
+	var cfg config.Configuration
+	cfg.PricingInfo.URL = server.URL
+	cfg.PricingInfo.LocalFile.Path = "missing.json"
+	if err := LoadConfiguredPricing(cfg); err != nil {
+		t.Fatalf("LoadConfiguredPricing() error = %v, want the pricing loaded from the URL", err)
+	}
+
+	cfg.PricingInfo.URL = ""
+	if err := LoadConfiguredPricing(cfg); err == nil {
+		t.Error("LoadConfiguredPricing() succeeded, want the missing file reported")
+	}
+}
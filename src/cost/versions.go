@@ -0,0 +1,137 @@
+package cost
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Version is a pricing document along with the time from which it applies. Versions stored in the
+// database are numbered from 1, the document loaded from the file or URL is version 0 until it is stored.
+type Version struct {
+	Number        int
+	EffectiveFrom time.Time
+	Document      []byte
+	Checksum      string // Checksum identifies the document, a document is stored once.
+	Pricing       *PricingModel
+}
+
+// documentChecksum returns the checksum of a pricing document.
+func documentChecksum(document []byte) string {
+	checksum := sha256.Sum256(document)
+	return hex.EncodeToString(checksum[:])
+}
+
+// versions holds the stored pricing versions, ordered by the time from which they apply.
+var versions atomic.Pointer[[]Version]
+
+var (
+	reloadHooksMu sync.Mutex
+	reloadHooks   []func() // reloadHooks are called after a new pricing document is loaded.
+)
+
+// ParseDocument parses and validates a pricing document.
+func ParseDocument(content []byte) (*PricingModel, error) {
+	var pricingModel PricingModel
+	if err := json.Unmarshal(content, &pricingModel); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal costing JSON: %w", err)
+	}
+	if err := validatePricingData(pricingModel); err != nil {
+		return nil, err
+	}
+	return &pricingModel, nil
+}
+
+// LoadedVersion returns the pricing document loaded from the file or URL.
+func LoadedVersion() Version {
+	return *pricing.Load()
+}
+
+// SetVersions replaces the stored pricing versions that records are priced with.
+func SetVersions(stored []Version) {
+	sorted := append([]Version{}, stored...)
+	for i := range sorted {
+		if sorted[i].Checksum == "" && sorted[i].Document != nil {
+			sorted[i].Checksum = documentChecksum(sorted[i].Document)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].EffectiveFrom.Equal(sorted[j].EffectiveFrom) {
+			return sorted[i].Number < sorted[j].Number
+		}
+		return sorted[i].EffectiveFrom.Before(sorted[j].EffectiveFrom)
+	})
+	versions.Store(&sorted)
+}
+
+// PricingAt returns the pricing version in effect at the given time: the latest stored version
+// that applies from that time, or the version in effect at the earliest time for times before
+// every version. The loaded document is used when no version is stored, and from the time it
+// applies when it is newer than every stored version but could not be stored, such as when the
+// database was unavailable as it was reloaded.
+func PricingAt(t time.Time) Version {
+	loaded := LoadedVersion()
+	stored := versions.Load()
+	if stored == nil || len(*stored) == 0 {
+		return loaded
+	}
+	if !t.Before(loaded.EffectiveFrom) && isUnstored(loaded, *stored) {
+		return loaded
+	}
+
+	if earliest := (*stored)[0].EffectiveFrom; t.Before(earliest) {
+		t = earliest
+	}
+	i := sort.Search(len(*stored), func(i int) bool { return (*stored)[i].EffectiveFrom.After(t) })
+	return (*stored)[i-1]
+}
+
+// isUnstored reports whether the loaded document is newer than every stored version and is not
+// one of them.
+func isUnstored(loaded Version, stored []Version) bool {
+	if loaded.Document == nil || !loaded.EffectiveFrom.After(stored[len(stored)-1].EffectiveFrom) {
+		return false
+	}
+	for _, version := range stored {
+		if version.Checksum == loaded.Checksum {
+			return false
+		}
+	}
+	return true
+}
+
+// versionPricing returns the pricing of a version number, or the loaded document for version 0.
+func versionPricing(number int) *PricingModel {
+	if stored := versions.Load(); stored != nil && number != 0 {
+		for _, version := range *stored {
+			if version.Number == number {
+				return version.Pricing
+			}
+		}
+	}
+	return CurrentPricing()
+}
+
+// OnReload registers a function called after a new pricing document is loaded, such as storing
+// it as a new version.
+func OnReload(hook func()) {
+	reloadHooksMu.Lock()
+	defer reloadHooksMu.Unlock()
+	reloadHooks = append(reloadHooks, hook)
+}
+
+// runReloadHooks calls the functions registered with OnReload.
+func runReloadHooks() {
+	reloadHooksMu.Lock()
+	hooks := append([]func(){}, reloadHooks...)
+	reloadHooksMu.Unlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+}
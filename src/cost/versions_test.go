@@ -0,0 +1,98 @@
+package cost
+
+import (
+	"testing"
+	"time"
+)
+
+// chatVersion returns a pricing version with a single chat model.
+func chatVersion(number int, effectiveFrom time.Time, promptPrice float64) Version {
+	return Version{Number: number, EffectiveFrom: effectiveFrom, Pricing: &PricingModel{
+		Chat: map[string]ChatPricing{"gpt-4": {PromptPrice: promptPrice, CompletionPrice: promptPrice}},
+	}}
+}
+
+func TestPricingAt(t *testing.T) {
+	withPricingState(t)
+	march := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	april := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	// Versions are sorted by the time they apply from, and a later version applying from the same
+	// time replaces an earlier one
+	SetVersions([]Version{chatVersion(3, april, 0.01), chatVersion(1, march, 0.03), chatVersion(2, march, 0.02)})
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want int
+	}{
+		{"before every version", march.Add(-time.Hour), 2},
+		{"at the start of a version", march, 2},
+		{"within a version", march.Add(10 * 24 * time.Hour), 2},
+		{"just before the next version", april.Add(-time.Nanosecond), 2},
+		{"at the next version", april, 3},
+		{"after the last version", april.AddDate(1, 0, 0), 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := PricingAt(test.at).Number; got != test.want {
+				t.Errorf("PricingAt(%v) = version %d, want %d", test.at, got, test.want)
+			}
+		})
+	}
+}
+
+func TestPricingAtWithoutVersions(t *testing.T) {
+	withPricingState(t)
+	SetVersions(nil)
+	setLoadedPricing(&PricingModel{Chat: map[string]ChatPricing{"gpt-4": {PromptPrice: 0.05, CompletionPrice: 0.05}}})
+
+	if version := PricingAt(time.Now()); version.Number != 0 || version.Pricing.Chat["gpt-4"].PromptPrice != 0.05 {
+		t.Errorf("PricingAt() = %+v, want the loaded document", version)
+	}
+}
+
+func TestCostsUseRequestVersion(t *testing.T) {
+	withPricingState(t)
+	march := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	SetVersions([]Version{chatVersion(1, march, 0.03), chatVersion(2, march.AddDate(0, 1, 0), 0.01)})
+	setLoadedPricing(&PricingModel{Chat: map[string]ChatPricing{"gpt-4": {PromptPrice: 0.05, CompletionPrice: 0.05}}})
+
+	for version, want := range map[int]float64{1: 0.06, 2: 0.02, 0: 0.1} {
+		got, _ := CalculateChatCost(Request{Version: version, Provider: "openai", Model: "gpt-4"}, 1000, 1000)
+		if !floatEquals(got, want) {
+			t.Errorf("cost with version %d = %v, want %v", version, got, want)
+		}
+	}
+	// Versions that are not stored fall back to the loaded document
+	if got, _ := CalculateChatCost(Request{Version: 9, Provider: "openai", Model: "gpt-4"}, 1000, 1000); !floatEquals(got, 0.1) {
+		t.Errorf("cost with an unknown version = %v, want the loaded pricing", got)
+	}
+}
+
+func TestPricingAtUnstoredDocument(t *testing.T) {
+	march := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	stored := chatVersion(1, march, 0.03)
+	stored.Document = []byte(`{"stored":true}`)
+	withPricingState(t)
+	SetVersions([]Version{stored})
+
+	loaded := chatVersion(0, march.AddDate(0, 1, 0), 0.01)
+	loaded.Document = []byte(`{"stored":false}`)
+	loaded.Checksum = documentChecksum(loaded.Document)
+	pricing.Store(&loaded)
+
+	// A document that could not be stored applies from its time, the stored versions before it
+	if got := PricingAt(march.AddDate(0, 2, 0)); got.Number != 0 || got.Pricing.Chat["gpt-4"].PromptPrice != 0.01 {
+		t.Errorf("PricingAt() = %+v, want the loaded document", got)
+	}
+	if got := PricingAt(march.AddDate(0, 0, 10)).Number; got != 1 {
+		t.Errorf("PricingAt() before the loaded document = version %d, want 1", got)
+	}
+
+	// Once stored, the version of the document is used
+	stored.Document = loaded.Document
+	SetVersions([]Version{stored})
+	if got := PricingAt(march.AddDate(0, 2, 0)).Number; got != 1 {
+		t.Errorf("PricingAt() = version %d, want the stored version of the loaded document", got)
+	}
+}
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"fmt"
+)
+
+// LoadAlertState returns the notified thresholds of every budget.
+func LoadAlertState() (map[string][]float64, error) {
+	query := fmt.Sprintf("SELECT budget, threshold FROM %s ORDER BY budget, threshold", dbConfig.AlertTableName)
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading the alert state: %w", err)
+	}
+	defer rows.Close()
+
+	state := map[string][]float64{}
+	for rows.Next() {
+		var budget string
+		var threshold float64
+		if err := rows.Scan(&budget, &threshold); err != nil {
+			return nil, err
+		}
+		state[budget] = append(state[budget], threshold)
+	}
+	return state, rows.Err()
+}
+
+// SaveAlertState replaces the notified thresholds of a budget.
+func SaveAlertState(budget string, thresholds []float64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	deleteQuery := fmt.Sprintf("DELETE FROM %s WHERE budget = $1", dbConfig.AlertTableName)
+	if _, err := tx.Exec(deleteQuery, budget); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Error saving the alert state of budget '%s': %w", budget, err)
+	}
+	insertQuery := fmt.Sprintf("INSERT INTO %s (budget, threshold) VALUES ($1, $2)", dbConfig.AlertTableName)
+	for _, threshold := range thresholds {
+		if _, err := tx.Exec(insertQuery, budget, threshold); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Error saving the alert state of budget '%s': %w", budget, err)
+		}
+	}
+
+	return tx.Commit()
+}
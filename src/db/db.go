@@ -1,18 +1,26 @@
 package db
 
 import (
+	"context"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"ingester/config"
 	"ingester/cost"
+	"ingester/limits"
+	"ingester/metrics"
 	"ingester/obsPlatform"
+	"ingester/record"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
 
-	_ "github.com/lib/pq"
-	"github.com/pkoukk/tiktoken-go"
+	"github.com/lib/pq"
 	"github.com/rs/zerolog/log"
 )
 
@@ -21,7 +29,7 @@ var (
 	db       *sql.DB        // db holds the database connection
 	dbConfig DatabaseConfig // dbConfig holds the database configuration
 
-	// validFields represent the fields that are expected in the incoming data.
+	// validFields are the columns of the data table a record is written to, in the order of recordValues.
 	validFields = []string{
 		"name",
 		"environment",
@@ -43,21 +51,67 @@ var (
 		"audioVoice",
 		"finetuneJobId",
 		"finetuneJobStatus",
+		"imageQuality",
+		"orgId",
+		"projectId",
+		"cacheReadTokens",
+		"cacheWriteTokens",
+		"region",
+		"pricingVersion",
 	}
 )
 
+// Scopes grant an API key access to a group of endpoints.
+const (
+	ScopeIngest = "ingest" // ScopeIngest allows pushing data.
+	ScopeRead   = "read"   // ScopeRead allows reading stored data.
+	ScopeAdmin  = "admin"  // ScopeAdmin allows managing API keys and grants every other scope.
+)
+
+// ValidScopes lists the scopes that can be given to an API key.
+var ValidScopes = []string{ScopeIngest, ScopeRead, ScopeAdmin}
+
+// maxRecordsPerInsert limits the number of records written by a single multi-row insert,
+// keeping the statement below the PostgreSQL limit of 65535 bind parameters.
+const maxRecordsPerInsert = 1000
+
+// execer is implemented by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// BatchResult reports the outcome of inserting a single record from a batch.
+type BatchResult struct {
+	Index   int                 `json:"index"`
+	Status  int                 `json:"status"`
+	Message string              `json:"message"`
+	Errors  []record.FieldError `json:"errors,omitempty"`
+}
+
 // DBConfig holds the database configuration
 type DatabaseConfig struct {
-	DBName          string
-	User            string
-	Password        string
-	Host            string
-	Port            string
-	SSLMode         string
-	MaxIdleConns    int
-	MaxOpenConns    int
-	DataTableName   string
-	ApiKeyTableName string
+	DBName             string
+	User               string
+	Password           string
+	Host               string
+	Port               string
+	SSLMode            string
+	MaxIdleConns       int
+	MaxOpenConns       int
+	DataTableName      string
+	ApiKeyTableName    string
+	ApiKeySecret       string
+	OrgTableName       string
+	ProjectTableName   string
+	PricingTableName   string
+	AlertTableName     string
+	QueueSize          int
+	QueueWorkers       int
+	QueueBatchSize     int
+	QueueFlushInterval time.Duration
+	RetentionInterval  string
+	CompressAfter      string
+	ChunkTimeInterval  string
 }
 
 // PingDB attempts to ping the database to check if it's alive.
@@ -65,126 +119,160 @@ func PingDB() error {
 	return db.Ping()
 }
 
-// GenerateSecureRandomKey should generate a secure random string to be used as an API key.
-func generateSecureRandomKey() (string, error) {
-	randomPartLength := 40 / 2 // Each byte becomes two hex characters, so we need half as many bytes.
+// apiKeyPrefixLength is the length of the non-secret start of an API key stored to look the key up.
+const apiKeyPrefixLength = 10
+
+// MaxKeyNameLength is the size of the name columns of the API key and data tables.
+const MaxKeyNameLength = 50
+
+// APIKeyInfo holds the metadata of an API key. The key itself is never stored.
+type APIKeyInfo struct {
+	OrgID                int        `json:"orgId"`
+	ProjectID            int        `json:"projectId"`
+	Name                 string     `json:"name"`
+	Prefix               string     `json:"prefix"`
+	Scopes               []string   `json:"scopes"`
+	CreatedAt            time.Time  `json:"createdAt"`
+	LastUsedAt           *time.Time `json:"lastUsedAt"`
+	ExpiresAt            *time.Time `json:"expiresAt"`
+	PreviousKeyExpiresAt *time.Time `json:"previousKeyExpiresAt,omitempty"`
+}
 
-	randomBytes := make([]byte, randomPartLength)
-	_, err := rand.Read(randomBytes)
-	if err != nil {
-		log.Error().Err(err).Msg("Error generating random bytes")
-		// In the case of an error, return it as we cannot generate a key.
-		return "", err
-	}
+// StaleAPIKey is an API key that expired or has not been used recently.
+type StaleAPIKey struct {
+	APIKeyInfo
+	Reason string `json:"reason"`
+}
 
-	// Encode the random bytes as a hex string and prefix with 'dk'.
-	randomHexString := hex.EncodeToString(randomBytes)
-	apiKey := "dk" + randomHexString
+// HashAPIKey returns the hex encoded HMAC-SHA256 of an API key keyed with the configured secret.
+func HashAPIKey(apiKey string) string {
+	return hmacAPIKey(dbConfig.ApiKeySecret, apiKey)
+}
 
-	return apiKey, nil
+// hmacAPIKey returns the hex encoded HMAC-SHA256 of an API key keyed with the secret.
+func hmacAPIKey(secret, apiKey string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(apiKey))
+	return hex.EncodeToString(mac.Sum(nil))
 }
 
-// getCreateAPIKeysTableSQL returns the SQL query to create the API keys table.
-func getCreateAPIKeysTableSQL(tableName string) string {
-	return fmt.Sprintf(`
-	CREATE TABLE IF NOT EXISTS %s (
-		id SERIAL PRIMARY KEY,
-		api_key VARCHAR(255) NOT NULL UNIQUE,
-		name VARCHAR(50) NOT NULL
-	);`, tableName)
-}
-
-// getCreateDataTableSQL returns the SQL query to create the data table.
-func getCreateDataTableSQL(tableName string) string {
-	return fmt.Sprintf(`
-	CREATE TABLE IF NOT EXISTS %s (
-		time TIMESTAMPTZ NOT NULL,
-		name VARCHAR(10) NOT NULL,
-		environment VARCHAR(50) NOT NULL,
-		endpoint VARCHAR(50) NOT NULL,
-		sourceLanguage VARCHAR(50) NOT NULL,
-		applicationName VARCHAR(50) NOT NULL,
-		completionTokens INTEGER,
-		promptTokens INTEGER,
-		totalTokens INTEGER,
-		finishReason VARCHAR(50),
-		requestDuration DOUBLE PRECISION,
-		usageCost DOUBLE PRECISION,
-		model VARCHAR(50),
-		prompt TEXT,
-		response TEXT,
-		imageSize TEXT,
-		revisedPrompt TEXT,
-		image TEXT,
-		audioVoice TEXT,
-		finetuneJobId TEXT,
-		finetuneJobStatus TEXT
-	);`, tableName)
-}
-
-// tableExists checks if a table exists in the database.
-func tableExists(db *sql.DB, tableName string) (bool, error) {
-	query := `
-		SELECT EXISTS (
-			SELECT 1
-			FROM   information_schema.tables 
-			WHERE  table_schema = 'public'
-			AND    lower(table_name) = lower($1)
-		)
-	`
-
-	var exists bool
-	err := db.QueryRow(query, tableName).Scan(&exists)
-	if err != nil {
-		return false, err
+// storedAPIKey is an API key as it is stored in the database, along with the key it replaced.
+type storedAPIKey struct {
+	info           APIKeyInfo
+	hash           string
+	previousPrefix string
+	previousHash   string
+}
+
+// newStoredAPIKey returns how an API key with the given metadata is stored. Only the hash and the
+// prefix of the key are stored, so the key itself cannot be read back.
+func newStoredAPIKey(apiKey string, info APIKeyInfo) storedAPIKey {
+	info.Prefix = apiKeyPrefix(apiKey)
+	return storedAPIKey{info: info, hash: HashAPIKey(apiKey)}
+}
+
+// rotate returns the stored key after it is replaced by newAPIKey at now, valid until expiresAt or forever
+// when it is nil. The replaced key stays valid for the grace period, but never past its own expiry.
+func (k storedAPIKey) rotate(newAPIKey string, gracePeriod time.Duration, expiresAt *time.Time, now time.Time) storedAPIKey {
+	previousExpiresAt := now.Add(gracePeriod)
+	if k.info.ExpiresAt != nil && k.info.ExpiresAt.Before(previousExpiresAt) {
+		previousExpiresAt = *k.info.ExpiresAt
 	}
 
-	return exists, nil
+	rotated := newStoredAPIKey(newAPIKey, k.info)
+	rotated.info.ExpiresAt = expiresAt
+	rotated.info.PreviousKeyExpiresAt = &previousExpiresAt
+	rotated.previousPrefix, rotated.previousHash = k.info.Prefix, k.hash
+	return rotated
 }
 
-// createTable creates a table in the database if it doesn't exist.
-func createTable(db *sql.DB, tableName string) error {
-	var createTableSQL string
-	if tableName == dbConfig.ApiKeyTableName {
-		createTableSQL = getCreateAPIKeysTableSQL(tableName)
-	} else if tableName == dbConfig.DataTableName {
-		createTableSQL = getCreateDataTableSQL(tableName)
+// matchAPIKey returns the metadata of the stored key that the API key is, or that it replaced while
+// the grace period lasts. Expired keys do not match.
+func matchAPIKey(apiKey string, keys []storedAPIKey, now time.Time) (APIKeyInfo, bool) {
+	prefix := apiKeyPrefix(apiKey)
+	keyHash := []byte(HashAPIKey(apiKey))
+	for _, key := range keys {
+		info := key.info
+		if info.Prefix == prefix && hmac.Equal(keyHash, []byte(key.hash)) {
+			if info.ExpiresAt != nil && !now.Before(*info.ExpiresAt) {
+				continue
+			}
+			return info, true
+		}
+		if key.previousPrefix == prefix && hmac.Equal(keyHash, []byte(key.previousHash)) {
+			if info.PreviousKeyExpiresAt == nil || !now.Before(*info.PreviousKeyExpiresAt) {
+				continue
+			}
+			info.ExpiresAt = info.PreviousKeyExpiresAt
+			return info, true
+		}
 	}
+	return APIKeyInfo{}, false
+}
 
-	exists, err := tableExists(db, tableName)
-	if err != nil {
-		return fmt.Errorf("Error checking table '%s' existence: %w", tableName, err)
+// apiKeyPrefix returns the non-secret start of an API key.
+func apiKeyPrefix(apiKey string) string {
+	if len(apiKey) > apiKeyPrefixLength {
+		return apiKey[:apiKeyPrefixLength]
 	}
-	if exists == false {
-		_, err := db.Exec(createTableSQL)
-		if err != nil {
-			return fmt.Errorf("Error creating table %s: %w", tableName, err)
-		}
-		log.Info().Msgf("Table '%s' created in the database", tableName)
+	return apiKey
+}
 
-		if tableName == dbConfig.ApiKeyTableName {
-			createIndexSQL := fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_api_key ON %s (api_key);", tableName)
-			_, err = db.Exec(createIndexSQL)
-			if err != nil {
-				return fmt.Errorf("Error creating index on 'api_key' column: %w", err)
-			}
-			log.Info().Msgf("Index on 'api_key' column checked/created in table '%s'", tableName)
+// hashPlaintextAPIKeys replaces the plaintext keys stored before keys were hashed by their hash and prefix.
+func hashPlaintextAPIKeys(conn *sql.Conn) error {
+	ctx := context.Background()
+	query := fmt.Sprintf("SELECT id, api_key FROM %s WHERE api_key IS NOT NULL", dbConfig.ApiKeyTableName)
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	plaintext := map[int]string{}
+	for rows.Next() {
+		var id int
+		var apiKey string
+		if err := rows.Scan(&id, &apiKey); err != nil {
+			rows.Close()
+			return err
 		}
+		plaintext[id] = apiKey
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-		// If the table to create is the data table, convert it into a hypertable
-		if tableName == dbConfig.DataTableName {
-			_, err := db.Exec("SELECT create_hypertable($1, 'time')", tableName)
-			if err != nil {
-				return fmt.Errorf("Error creating hypertable: %w", err)
-			}
-			log.Info().Msgf("Table '%s' converted to a Hypertable", tableName)
+	updateQuery := fmt.Sprintf("UPDATE %s SET key_hash = $1, key_prefix = $2, api_key = NULL WHERE id = $3", dbConfig.ApiKeyTableName)
+	for id, apiKey := range plaintext {
+		stored := newStoredAPIKey(apiKey, APIKeyInfo{})
+		if _, err := conn.ExecContext(ctx, updateQuery, stored.hash, stored.info.Prefix, id); err != nil {
+			return err
 		}
-	} else {
-		log.Info().Msgf("Table '%s' already exists in the database", tableName)
+	}
+	if len(plaintext) > 0 {
+		log.Info().Msgf("Hashed %d API keys stored in plaintext", len(plaintext))
 	}
 	return nil
 }
 
+// GenerateSecureRandomKey should generate a secure random string to be used as an API key.
+func generateSecureRandomKey() (string, error) {
+	randomPartLength := 40 / 2 // Each byte becomes two hex characters, so we need half as many bytes.
+
+	randomBytes := make([]byte, randomPartLength)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		log.Error().Err(err).Msg("Error generating random bytes")
+		// In the case of an error, return it as we cannot generate a key.
+		return "", err
+	}
+
+	// Encode the random bytes as a hex string and prefix with 'dk'.
+	randomHexString := hex.EncodeToString(randomBytes)
+	apiKey := "dk" + randomHexString
+
+	return apiKey, nil
+}
+
 // initializeDB initializes connection to the database.
 func initializeDB() error {
 	var dbErr error
@@ -208,94 +296,299 @@ func initializeDB() error {
 	return dbErr
 }
 
-func getTokens(text, model string) int {
-	tkm, err := tiktoken.EncodingForModel(model)
-	if err != nil {
-		tkm, _ = tiktoken.GetEncoding("cl100k_base")
+// prepareRecord validates a record and calculates its usage cost. Records sent without a time
+// are timed when they are received.
+func prepareRecord(r *record.Record) error {
+	if err := r.Validate(); err != nil {
+		return err
 	}
-	token := tkm.Encode(text, nil, nil)
-	return len(token)
+	setReceiveTime(r)
+	r.CalculateUsageCost()
+	return nil
 }
 
-// insertDataToDB inserts data into the database.
-func insertDataToDB(data map[string]interface{}) (string, int) {
-	// Calculate usage cost based on the endpoint type
-	if data["endpoint"] == "openai.embeddings" || data["endpoint"] == "cohere.embed" {
-		data["usageCost"], _ = cost.CalculateEmbeddingsCost(data["promptTokens"].(float64), data["model"].(string))
-	} else if data["endpoint"] == "openai.chat.completions" || data["endpoint"] == "openai.completions" || data["endpoint"] == "cohere.chat" || data["endpoint"] == "cohere.summarize" || data["endpoint"] == "cohere.generate" {
-		if data["completionTokens"] != nil && data["promptTokens"] != nil {
-			data["usageCost"], _ = cost.CalculateChatCost(data["promptTokens"].(float64), data["completionTokens"].(float64), data["model"].(string))
-		} else if (data["endpoint"] == "openai.chat.completions" || data["endpoint"] == "openai.completions") && data["prompt"] != nil && data["response"] != nil {
-			data["promptTokens"] = getTokens(data["prompt"].(string), data["model"].(string))
-			data["completionTokens"] = getTokens(data["response"].(string), data["model"].(string))
-			data["totalTokens"] = data["promptTokens"].(int) + data["completionTokens"].(int)
-			data["usageCost"], _ = cost.CalculateChatCost(float64(data["promptTokens"].(int)), float64(data["completionTokens"].(int)), data["model"].(string))
+// setReceiveTime sets the time of a record sent without one to the current time.
+func setReceiveTime(r *record.Record) {
+	if r.Time == nil {
+		now := time.Now()
+		r.Time = &now
+	}
+}
+
+// validationResult returns the status code and invalid fields of an error returned by prepareRecord.
+func validationResult(err error) (int, []record.FieldError) {
+	if validationErr, ok := err.(*record.ValidationError); ok {
+		return validationErr.Status, validationErr.Fields
+	}
+	return http.StatusBadRequest, nil
+}
+
+// nullString returns nil for an empty string so that it is stored as NULL.
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// recordValues returns the values of a record in the order of validFields.
+func recordValues(r record.Record) []interface{} {
+	return []interface{}{
+		r.Name,
+		r.Environment,
+		r.Endpoint,
+		r.SourceLanguage,
+		r.ApplicationName,
+		r.CompletionTokens,
+		r.PromptTokens,
+		r.TotalTokens,
+		nullString(r.FinishReason),
+		r.RequestDuration,
+		r.UsageCost,
+		nullString(r.Model),
+		nullString(r.Prompt),
+		nullString(r.Response),
+		nullString(r.ImageSize),
+		nullString(r.RevisedPrompt),
+		nullString(r.Image),
+		nullString(r.AudioVoice),
+		nullString(r.FinetuneJobID),
+		nullString(r.FinetuneJobStatus),
+		nullString(r.ImageQuality),
+		r.OrgID,
+		r.ProjectID,
+		r.CacheReadTokens,
+		r.CacheWriteTokens,
+		nullString(r.Region),
+		r.PricingVersion,
+	}
+}
+
+// observeRecord updates the LLM usage metrics with a record that was written to the database.
+func observeRecord(r record.Record) {
+	labels := []string{r.Environment, r.ApplicationName, r.Endpoint, r.Model}
+
+	metrics.Records.Inc("inserted")
+	limits.Record(r)
+	metrics.LLMRequests.Inc(labels...)
+	if r.UsageCost != nil {
+		metrics.LLMUsageCost.Add(*r.UsageCost, labels...)
+	}
+	if r.RequestDuration != nil {
+		metrics.LLMRequestDuration.Observe(*r.RequestDuration, labels...)
+	}
+	for tokenType, tokens := range map[string]*int{"prompt": r.PromptTokens, "completion": r.CompletionTokens} {
+		if tokens != nil {
+			tokenLabels := append(append([]string{}, labels...), tokenType)
+			metrics.LLMTokens.Add(float64(*tokens), tokenLabels...)
+			metrics.LLMRequestTokens.Observe(float64(*tokens), tokenLabels...)
 		}
-	} else if data["endpoint"] == "openai.images.create" || data["endpoint"] == "openai.images.create.variations" {
-		data["usageCost"], _ = cost.CalculateImageCost(data["model"].(string), data["imageSize"].(string), data["imageQuality"].(string))
-	} else if data["endpoint"] == "openai.audio.speech.create" {
-		data["usageCost"], _ = cost.CalculateAudioCost(data["prompt"].(string), data["model"].(string))
 	}
+}
 
-	// Fill missing fields with nil
-	for _, field := range validFields {
-		if _, exists := data[field]; !exists {
-			data[field] = nil
+// getInsertDataSQL returns the SQL query to insert the given number of records into the data table.
+// Each record is written with its time followed by the values of validFields.
+func getInsertDataSQL(records int) string {
+	columns := len(validFields) + 1
+	var query strings.Builder
+	fmt.Fprintf(&query, "INSERT INTO %s (time, %s) VALUES ", dbConfig.DataTableName, strings.Join(validFields, ", "))
+	for i := 0; i < records; i++ {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		for j := 1; j <= columns; j++ {
+			if j == 1 {
+				query.WriteString("(")
+			} else {
+				query.WriteString(", ")
+			}
+			fmt.Fprintf(&query, "$%d", i*columns+j)
 		}
+		query.WriteString(")")
 	}
+	return query.String()
+}
 
-	go obsPlatform.SendToPlatform(data)
+// insertRecords writes the records to the data table using multi-row inserts.
+func insertRecords(exec execer, records []record.Record) error {
+	for start := 0; start < len(records); start += maxRecordsPerInsert {
+		end := start + maxRecordsPerInsert
+		if end > len(records) {
+			end = len(records)
+		}
 
-	// Define the SQL query for data insertion
-	query := fmt.Sprintf("INSERT INTO %s (time, name, environment, endpoint, sourceLanguage, applicationName, completionTokens, promptTokens, totalTokens, finishReason, requestDuration, usageCost, model, prompt, response, imageSize, revisedPrompt, image, audioVoice, finetuneJobId, finetuneJobStatus) VALUES (NOW(), $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)", dbConfig.DataTableName)
+		args := make([]interface{}, 0, (end-start)*(len(validFields)+1))
+		for _, r := range records[start:end] {
+			args = append(args, *r.Time)
+			args = append(args, recordValues(r)...)
+		}
+
+		if _, err := exec.Exec(getInsertDataSQL(end-start), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertDataToDB inserts data into the database.
+func insertDataToDB(r record.Record) (string, int) {
+	if err := prepareRecord(&r); err != nil {
+		log.Warn().Err(err).Msg("Error preparing data for insertion")
+		metrics.Records.Inc("invalid")
+		status, _ := validationResult(err)
+		return err.Error(), status
+	}
 
 	// Execute the SQL query
-	_, err := db.Exec(query,
-		data["name"],
-		data["environment"],
-		data["endpoint"],
-		data["sourceLanguage"],
-		data["applicationName"],
-		data["completionTokens"],
-		data["promptTokens"],
-		data["totalTokens"],
-		data["finishReason"],
-		data["requestDuration"],
-		data["usageCost"],
-		data["model"],
-		data["prompt"],
-		data["response"],
-		data["imageSize"],
-		data["revisedPrompt"],
-		data["image"],
-		data["audioVoice"],
-		data["finetuneJobId"],
-		data["finetuneJobStatus"],
-	)
+	start := time.Now()
+	err := insertRecords(db, []record.Record{r})
+	metrics.InsertDuration.Observe(time.Since(start).Seconds(), "single")
 	if err != nil {
 		log.Error().Err(err).Msg("Error Inserting data into the database")
+		metrics.Records.Inc("failed")
 		// Update the response message and status code for error
 		return "Internal Server Error", http.StatusInternalServerError
 	}
 
+	observeRecord(r)
+	go obsPlatform.SendToPlatform(r)
 	return "Data insertion completed", http.StatusCreated
 }
 
-// Init initializes the database connection and creates the required tables.
-func Init(cfg config.Configuration) error {
+// insertBatchToDB inserts a batch of records into the database inside a single transaction. Records
+// the database refuses are reported as failed without failing the rest of the batch.
+func insertBatchToDB(records []record.Record) ([]BatchResult, int) {
+	results := make([]BatchResult, len(records))
+	var prepared []record.Record
+	var preparedIndexes []int
+
+	for i, r := range records {
+		results[i].Index = i
+		if err := prepareRecord(&r); err != nil {
+			results[i].Status, results[i].Errors = validationResult(err)
+			results[i].Message = err.Error()
+			metrics.Records.Inc("invalid")
+			continue
+		}
+		prepared = append(prepared, r)
+		preparedIndexes = append(preparedIndexes, i)
+	}
+
+	if len(prepared) == 0 {
+		return results, http.StatusBadRequest
+	}
+
+	start := time.Now()
+	errs := insertIsolatingFailures(prepared, insertBatchInTx)
+	metrics.InsertDuration.Observe(time.Since(start).Seconds(), "batch")
+
+	failed := 0
+	for j, i := range preparedIndexes {
+		if errs[j] != nil {
+			failed++
+			results[i].Status = http.StatusInternalServerError
+			results[i].Message = "Internal Server Error"
+			log.Error().Err(errs[j]).Msgf("Error inserting record %d of a batch into the database", i)
+			continue
+		}
+		results[i].Status = http.StatusCreated
+		results[i].Message = "Data insertion completed"
+		observeRecord(prepared[j])
+		go obsPlatform.SendToPlatform(prepared[j])
+	}
+	if failed > 0 {
+		metrics.Records.Add(float64(failed), "failed")
+	}
+
+	switch {
+	case failed == len(prepared):
+		return results, http.StatusInternalServerError
+	case failed > 0 || len(prepared) != len(records):
+		return results, http.StatusMultiStatus
+	}
+	return results, http.StatusCreated
+}
+
+// insertIsolatingFailures writes the records with insert and returns the error of every record
+// that could not be written. When the database refuses the data of a batch, the batch is split in
+// halves that are written again, so that only the records it refuses fail. Other errors, such as
+// a lost connection, fail every record of the batch.
+func insertIsolatingFailures(records []record.Record, insert func([]record.Record) error) []error {
+	errs := make([]error, len(records))
+	err := insert(records)
+	if err == nil {
+		return errs
+	}
+	if len(records) == 1 || !isDataError(err) {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	half := len(records) / 2
+	copy(errs, insertIsolatingFailures(records[:half], insert))
+	copy(errs[half:], insertIsolatingFailures(records[half:], insert))
+	return errs
+}
+
+// isDataError reports whether the database refused a statement because of the data it writes,
+// such as a value too long for its column, rather than because of the connection or the server.
+func isDataError(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	class := pqErr.Code.Class()
+	return class == "22" || class == "23"
+}
+
+// insertBatchInTx writes the records to the data table inside a single transaction.
+func insertBatchInTx(records []record.Record) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = insertRecords(tx, records); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Connect initializes the database configuration and the connection to the database
+// without changing the schema.
+func Connect(cfg config.Configuration) error {
+
+	// The flush interval is validated while loading the configuration
+	flushInterval, _ := time.ParseDuration(cfg.DBConfig.IngestQueue.FlushInterval)
 
 	// Initialize the database configuration
 	dbConfig = DatabaseConfig{
-		DBName:          cfg.DBConfig.DBName,
-		User:            cfg.DBConfig.DBUser,
-		Password:        cfg.DBConfig.DBPassword,
-		Host:            cfg.DBConfig.DBHost,
-		Port:            cfg.DBConfig.DBPort,
-		SSLMode:         cfg.DBConfig.DBSSLMode,
-		MaxIdleConns:    cfg.DBConfig.MaxIdleConns,
-		MaxOpenConns:    cfg.DBConfig.MaxOpenConns,
-		DataTableName:   cfg.DBConfig.DataTableName,
-		ApiKeyTableName: cfg.DBConfig.APIKeyTableName,
+		DBName:             cfg.DBConfig.DBName,
+		User:               cfg.DBConfig.DBUser,
+		Password:           cfg.DBConfig.DBPassword,
+		Host:               cfg.DBConfig.DBHost,
+		Port:               cfg.DBConfig.DBPort,
+		SSLMode:            cfg.DBConfig.DBSSLMode,
+		MaxIdleConns:       cfg.DBConfig.MaxIdleConns,
+		MaxOpenConns:       cfg.DBConfig.MaxOpenConns,
+		DataTableName:      cfg.DBConfig.DataTableName,
+		ApiKeyTableName:    cfg.DBConfig.APIKeyTableName,
+		ApiKeySecret:       cfg.DBConfig.APIKeySecret,
+		OrgTableName:       cfg.DBConfig.OrgTableName,
+		ProjectTableName:   cfg.DBConfig.ProjectTableName,
+		PricingTableName:   cfg.DBConfig.PricingTableName,
+		AlertTableName:     cfg.DBConfig.AlertTableName,
+		QueueSize:          cfg.DBConfig.IngestQueue.Size,
+		QueueWorkers:       cfg.DBConfig.IngestQueue.Workers,
+		QueueBatchSize:     cfg.DBConfig.IngestQueue.BatchSize,
+		QueueFlushInterval: flushInterval,
+		RetentionInterval:  cfg.DBConfig.RetentionInterval,
+		CompressAfter:      cfg.DBConfig.CompressAfter,
+		ChunkTimeInterval:  cfg.DBConfig.ChunkTimeInterval,
 	}
 
 	err := initializeDB()
@@ -303,79 +596,160 @@ func Init(cfg config.Configuration) error {
 		log.Error().Err(err).Msg("Error initializing database")
 		return fmt.Errorf("Could not initialize connection to the database: %w", err)
 	}
+	return nil
+}
+
+// Init initializes the database connection and brings the schema up to date.
+func Init(cfg config.Configuration) error {
+	err := Connect(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Create or upgrade the DATA and API keys tables.
+	log.Info().Msgf("Applying schema migrations to '%s' and '%s' tables", dbConfig.ApiKeyTableName, dbConfig.DataTableName)
+
+	err = MigrateUp()
+	if err != nil {
+		log.Error().Err(err).Msg("Error applying schema migrations")
+		return err
+	}
 
-	// Create the DATA and API keys table if it doesn't exist.
-	log.Info().Msgf("Creating '%s' and '%s' tables in the database if they don't exist", dbConfig.ApiKeyTableName, dbConfig.DataTableName)
+	err = applyDataTablePolicies(db)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error applying policies to table %s", dbConfig.DataTableName)
+		return err
+	}
 
-	err = createTable(db, dbConfig.ApiKeyTableName)
+	err = createRollups(db)
 	if err != nil {
-		log.Error().Err(err).Msgf("Error creating table %s", dbConfig.ApiKeyTableName)
+		log.Error().Err(err).Msgf("Error creating rollups of table %s", dbConfig.DataTableName)
 		return err
 	}
 
-	err = createTable(db, dbConfig.DataTableName)
+	// Quotas start from zero rather than keeping the ingester down when the usage cannot be read
+	err = seedQuotaUsage(db)
+	if err != nil {
+		log.Warn().Err(err).Msg("Error loading the quota usage, quotas only count the usage from now on")
+	}
+
+	// Store the pricing as a version and store the documents reloaded later on
+	err = SyncPricing()
 	if err != nil {
-		log.Error().Err(err).Msgf("Error creating table %s", dbConfig.ApiKeyTableName)
+		log.Error().Err(err).Msg("Error synchronizing the pricing versions")
 		return err
 	}
+	cost.OnReload(syncPricingOnReload)
+
+	startInsertWorkers(dbConfig.QueueSize, dbConfig.QueueWorkers)
 	return nil
 }
 
 // PerformDatabaseInsertion performs the database insertion synchronously.
-func PerformDatabaseInsertion(data map[string]interface{}) (string, int) {
+func PerformDatabaseInsertion(r record.Record) (string, int) {
 	// Call insertDataToDB directly instead of starting a new goroutine.
-	responseMessage, statusCode := insertDataToDB(data)
+	responseMessage, statusCode := insertDataToDB(r)
 
 	// The operation is now synchronous. Once insertDataToDB returns, the result is ready to use.
 	return responseMessage, statusCode
 }
 
-// CheckAPIKey retrieves the name associated with the given API key from the database.
-func CheckAPIKey(apiKey string) (string, error) {
-	var name string
+// PerformBatchInsertion performs the database insertion of a batch of records synchronously
+// and reports the outcome of every record along with the overall status code.
+func PerformBatchInsertion(records []record.Record) ([]BatchResult, int) {
+	return insertBatchToDB(records)
+}
 
-	query := fmt.Sprintf("SELECT name FROM %s WHERE api_key = $1", dbConfig.ApiKeyTableName)
-	err := db.QueryRow(query, apiKey).Scan(&name)
+// CheckAPIKey retrieves the metadata of the given API key from the database. The key is looked
+// up by its prefix and compared to the stored hash in constant time. The key replaced by the last
+// rotation is also accepted until the end of its grace period, which is then reported as ExpiresAt.
+func CheckAPIKey(apiKey string) (APIKeyInfo, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE key_prefix = $1 OR previous_key_prefix = $1", storedAPIKeyColumns, dbConfig.ApiKeyTableName)
+	rows, err := db.Query(query, apiKeyPrefix(apiKey))
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return "", err
+		return APIKeyInfo{}, err
+	}
+	defer rows.Close()
+
+	var keys []storedAPIKey
+	for rows.Next() {
+		key, err := scanStoredAPIKey(rows)
+		if err != nil {
+			return APIKeyInfo{}, err
 		}
-		return "", err
+		keys = append(keys, key)
 	}
-	return name, nil
+	if err := rows.Err(); err != nil {
+		return APIKeyInfo{}, err
+	}
+	if info, ok := matchAPIKey(apiKey, keys, time.Now()); ok {
+		return info, nil
+	}
+	return APIKeyInfo{}, sql.ErrNoRows
+}
+
+// TouchAPIKey records that the API key with the given name in the organization was just used.
+func TouchAPIKey(orgID int, name string) error {
+	query := fmt.Sprintf("UPDATE %s SET last_used_at = NOW() WHERE org_id = $1 AND name = $2", dbConfig.ApiKeyTableName)
+	_, err := db.Exec(query, orgID, name)
+	return err
 }
 
-// GenerateAPIKey generates a new API key for a given name and stores it in the database.
-func GenerateAPIKey(existingAPIKey, name string) (string, error) {
-	// If there are any existing API keys, authenticate the provided API key before proceeding
+// CountAPIKeys returns the number of API keys stored in the database.
+func CountAPIKeys() (int, error) {
 	var count int
 	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s", dbConfig.ApiKeyTableName)
 	err := db.QueryRow(countQuery).Scan(&count)
 	if err != nil {
 		log.Error().Err(err).Msg("Error checking API key table")
-		return "", fmt.Errorf("Failed to check API key table: %v", err)
-	}
-
-	// Only perform the check if the count is greater than zero
-	if count > 0 {
-		// Attempt to retrieve any existing key for the given name.
-		_, err = GetAPIKeyForName(existingAPIKey, name)
-		if err == nil {
-			log.Warn().Msgf("Error creating new API Key as a key with the name '%s' already exists", name)
-			return "", fmt.Errorf("KEYEXISTS")
-		} else if err.Error() == "AUTHFAILED" {
-			return "", err
+		return 0, fmt.Errorf("Failed to check API key table: %v", err)
+	}
+	return count, nil
+}
+
+// ValidateScopes checks that every scope is one of the ValidScopes.
+func ValidateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		valid := false
+		for _, validScope := range ValidScopes {
+			if scope == validScope {
+				valid = true
+			}
+		}
+		if !valid {
+			return fmt.Errorf("INVALIDSCOPE")
 		}
 	}
+	return nil
+}
+
+// GenerateAPIKey generates a new API key with the given scopes for a given name in a project of the
+// organization and stores it in the database. The key is valid until expiresAt, or forever when it is nil.
+func GenerateAPIKey(orgID, projectID int, name string, scopes []string, expiresAt *time.Time) (string, error) {
+	if err := ValidateScopes(scopes); err != nil {
+		return "", err
+	}
+	// The name of the key is stored with every record it ingests
+	if len(name) > MaxKeyNameLength {
+		return "", fmt.Errorf("INVALIDNAME")
+	}
+
+	// Check for any existing key for the given name in the organization.
+	_, err := GetAPIKeyForName(orgID, name)
+	if err == nil {
+		log.Warn().Msgf("Error creating new API Key as a key with the name '%s' already exists", name)
+		return "", fmt.Errorf("KEYEXISTS")
+	} else if err.Error() != "NOTFOUND" {
+		return "", err
+	}
 
 	// No existing key found, proceed to generate a new API key
-	log.Info().Msgf("Creating a new API Key with the name: %s", name)
+	log.Info().Msgf("Creating a new API Key with the name '%s' and scopes %v", name, scopes)
 	newAPIKey, _ := generateSecureRandomKey()
 
-	// Insert the new API key into the database
-	insertQuery := fmt.Sprintf("INSERT INTO %s (api_key, name) VALUES ($1, $2)", dbConfig.ApiKeyTableName)
-	_, err = db.Exec(insertQuery, newAPIKey, name)
-	if err != nil {
+	// Only the hash and the prefix of the new API key are stored, the key itself is returned once
+	stored := newStoredAPIKey(newAPIKey, APIKeyInfo{OrgID: orgID, ProjectID: projectID, Name: name, Scopes: scopes, ExpiresAt: expiresAt})
+	if err := insertAPIKey(db, stored); err != nil {
 		log.Error().Err(err).Msg("Error inserting the new API key in the database")
 		return "", err
 	}
@@ -383,55 +757,159 @@ func GenerateAPIKey(existingAPIKey, name string) (string, error) {
 	return newAPIKey, nil
 }
 
-// GetAPIKeyForName retrieves an API key for a given name from the database.
-func GetAPIKeyForName(existingAPIKey, name string) (string, error) {
+// insertAPIKey stores a new API key with the database or a transaction.
+func insertAPIKey(exec interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}, key storedAPIKey) error {
+	insertQuery := fmt.Sprintf(`INSERT INTO %s (key_hash, key_prefix, org_id, project_id, name, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`, dbConfig.ApiKeyTableName)
+	_, err := exec.Exec(insertQuery, key.hash, key.info.Prefix, key.info.OrgID, key.info.ProjectID, key.info.Name,
+		pq.Array(key.info.Scopes), key.info.ExpiresAt)
+	return err
+}
 
-	// Autheticate the provided API key before proceeding
-	_, err := CheckAPIKey(existingAPIKey)
-	if err != nil {
-		log.Warn().Msg("Authorization Failed for an API Key")
-		return "", fmt.Errorf("AUTHFAILED")
-	}
+// apiKeyColumns are the columns read into an APIKeyInfo.
+const apiKeyColumns = "org_id, project_id, name, key_prefix, scopes, created_at, last_used_at, expires_at, previous_expires_at"
+
+// scanAPIKeyInfo reads a row of apiKeyColumns.
+func scanAPIKeyInfo(row interface{ Scan(...interface{}) error }) (APIKeyInfo, error) {
+	var info APIKeyInfo
+	err := row.Scan(&info.OrgID, &info.ProjectID, &info.Name, &info.Prefix, pq.Array(&info.Scopes), &info.CreatedAt, &info.LastUsedAt,
+		&info.ExpiresAt, &info.PreviousKeyExpiresAt)
+	return info, err
+}
 
-	// Retrieve the API key for the given name
-	var apiKey string
-	query := fmt.Sprintf("SELECT api_key FROM %s WHERE name = $1", dbConfig.ApiKeyTableName)
-	err = db.QueryRow(query, name).Scan(&apiKey)
+// storedAPIKeyColumns are the columns read into a storedAPIKey.
+const storedAPIKeyColumns = apiKeyColumns + ", key_hash, previous_key_prefix, previous_key_hash"
+
+// scanStoredAPIKey reads a row of storedAPIKeyColumns.
+func scanStoredAPIKey(row interface{ Scan(...interface{}) error }) (storedAPIKey, error) {
+	var key storedAPIKey
+	var previousPrefix, previousHash sql.NullString
+	err := row.Scan(&key.info.OrgID, &key.info.ProjectID, &key.info.Name, &key.info.Prefix, pq.Array(&key.info.Scopes), &key.info.CreatedAt,
+		&key.info.LastUsedAt, &key.info.ExpiresAt, &key.info.PreviousKeyExpiresAt, &key.hash, &previousPrefix, &previousHash)
+	key.previousPrefix, key.previousHash = previousPrefix.String, previousHash.String
+	return key, err
+}
+
+// GetAPIKeyForName retrieves the metadata of the API key with the given name in the organization from the database.
+func GetAPIKeyForName(orgID int, name string) (APIKeyInfo, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE org_id = $1 AND name = $2", apiKeyColumns, dbConfig.ApiKeyTableName)
+	info, err := scanAPIKeyInfo(db.QueryRow(query, orgID, name))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			log.Warn().Msgf("API Key with the name '%s' currently not found in the database", name)
-			return "", fmt.Errorf("NOTFOUND")
+			return info, fmt.Errorf("NOTFOUND")
 		}
 		log.Warn().Err(err).Msgf("Error retrieving API key for the name '%s'", name)
-		return "", err
+		return info, err
 	}
 
-	return apiKey, nil
+	return info, nil
 }
 
-// DeleteAPIKey deletes an API key for a given name from the database.
-func DeleteAPIKey(existingAPIKey, name string) error {
-
-	// Autheticate the provided API key before proceeding and check if the API key exists
-	apiKey, err := GetAPIKeyForName(existingAPIKey, name)
+// ListAPIKeys retrieves the metadata of every API key of the organization from the database, ordered by name.
+func ListAPIKeys(orgID int) ([]APIKeyInfo, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE org_id = $1 ORDER BY name", apiKeyColumns, dbConfig.ApiKeyTableName)
+	rows, err := db.Query(query, orgID)
 	if err != nil {
-		if err.Error() == "AUTHFAILED" {
-			return err
+		log.Error().Err(err).Msg("Error listing API keys")
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKeyInfo{}
+	for rows.Next() {
+		info, err := scanAPIKeyInfo(rows)
+		if err != nil {
+			return nil, err
 		}
-		if err.Error() == "NOTFOUND" {
-			return err
+		keys = append(keys, info)
+	}
+	return keys, rows.Err()
+}
+
+// ListStaleAPIKeys retrieves the metadata of the API keys of the organization that expired, or that
+// were last used (or created, if never used) before the given time, ordered by name.
+func ListStaleAPIKeys(orgID int, unusedSince time.Time) ([]StaleAPIKey, error) {
+	query := fmt.Sprintf(`SELECT %s, CASE WHEN expires_at <= NOW() THEN 'expired' ELSE 'unused' END FROM %s
+		WHERE org_id = $1 AND (expires_at <= NOW() OR COALESCE(last_used_at, created_at) < $2)
+		ORDER BY name`, apiKeyColumns, dbConfig.ApiKeyTableName)
+	rows, err := db.Query(query, orgID, unusedSince)
+	if err != nil {
+		log.Error().Err(err).Msg("Error listing stale API keys")
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []StaleAPIKey{}
+	for rows.Next() {
+		var key StaleAPIKey
+		err := rows.Scan(&key.OrgID, &key.ProjectID, &key.Name, &key.Prefix, pq.Array(&key.Scopes), &key.CreatedAt,
+			&key.LastUsedAt, &key.ExpiresAt, &key.PreviousKeyExpiresAt, &key.Reason)
+		if err != nil {
+			return nil, err
 		}
-		return err
+		keys = append(keys, key)
 	}
+	return keys, rows.Err()
+}
 
-	// Delete the API key from the database
+// RotateAPIKey replaces the API key with the given name in the organization by a new key valid until expiresAt,
+// or forever when it is nil. The replaced key stays valid for the grace period, but never past its own expiry.
+func RotateAPIKey(orgID int, name string, gracePeriod time.Duration, expiresAt *time.Time) (string, error) {
+	newAPIKey, err := generateSecureRandomKey()
+	if err != nil {
+		return "", err
+	}
+
+	log.Info().Msgf("Rotating API Key with the name '%s'", name)
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	selectQuery := fmt.Sprintf("SELECT %s FROM %s WHERE org_id = $1 AND name = $2 FOR UPDATE", storedAPIKeyColumns, dbConfig.ApiKeyTableName)
+	key, err := scanStoredAPIKey(tx.QueryRow(selectQuery, orgID, name))
+	if err == sql.ErrNoRows {
+		log.Warn().Msgf("API Key with the name '%s' currently not found in the database", name)
+		return "", fmt.Errorf("NOTFOUND")
+	} else if err != nil {
+		log.Error().Err(err).Msg("Error rotating API key")
+		return "", err
+	}
+
+	rotated := key.rotate(newAPIKey, gracePeriod, expiresAt, time.Now())
+	updateQuery := fmt.Sprintf(`UPDATE %s SET key_hash = $1, key_prefix = $2, expires_at = $3,
+		previous_key_hash = $4, previous_key_prefix = $5, previous_expires_at = $6
+		WHERE org_id = $7 AND name = $8`, dbConfig.ApiKeyTableName)
+	_, err = tx.Exec(updateQuery, rotated.hash, rotated.info.Prefix, rotated.info.ExpiresAt,
+		rotated.previousHash, rotated.previousPrefix, rotated.info.PreviousKeyExpiresAt, orgID, name)
+	if err != nil {
+		log.Error().Err(err).Msg("Error rotating API key")
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	log.Info().Msgf("API Key with the name '%s' rotated successfully", name)
+	return newAPIKey, nil
+}
+
+// DeleteAPIKey deletes an API key for a given name in the organization from the database.
+func DeleteAPIKey(orgID int, name string) error {
 	log.Info().Msgf("Deleting API Key with the name '%s' from the database", name)
-	query := fmt.Sprintf("DELETE FROM %s WHERE api_key = $1", dbConfig.ApiKeyTableName)
-	_, err = db.Exec(query, apiKey)
+	query := fmt.Sprintf("DELETE FROM %s WHERE org_id = $1 AND name = $2", dbConfig.ApiKeyTableName)
+	result, err := db.Exec(query, orgID, name)
 	if err != nil {
 		log.Error().Err(err).Msg("Error deleting API key")
 		return err
 	}
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		log.Warn().Msgf("API Key with the name '%s' currently not found in the database", name)
+		return fmt.Errorf("NOTFOUND")
+	}
 	log.Info().Msgf("API Key with the name '%s' deleted successfully", name)
 	return nil
 }
@@ -0,0 +1,192 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"ingester/record"
+
+	"github.com/lib/pq"
+)
+
+func TestInsertIsolatingFailures(t *testing.T) {
+	records := make([]record.Record, 7)
+	for i := range records {
+		records[i].Name = "key"
+	}
+	records[2].Name, records[5].Name = "bad", "bad"
+
+	calls := 0
+	insert := func(batch []record.Record) error {
+		calls++
+		for _, r := range batch {
+			if r.Name == "bad" {
+				return &pq.Error{Code: "22001"} // string_data_right_truncation
+			}
+		}
+		return nil
+	}
+
+	errs := insertIsolatingFailures(records, insert)
+	for i, err := range errs {
+		if wantErr := i == 2 || i == 5; (err != nil) != wantErr {
+			t.Errorf("errs[%d] = %v, want error %v", i, err, wantErr)
+		}
+	}
+	if calls > 2*len(records) {
+		t.Errorf("insert called %d times, want at most %d", calls, 2*len(records))
+	}
+}
+
+func TestInsertIsolatingFailuresConnectionError(t *testing.T) {
+	records := make([]record.Record, 4)
+	calls := 0
+	insert := func([]record.Record) error {
+		calls++
+		return errors.New("connection refused")
+	}
+
+	errs := insertIsolatingFailures(records, insert)
+	for i, err := range errs {
+		if err == nil {
+			t.Errorf("errs[%d] = nil, want the connection error", i)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("insert called %d times, want 1 since the batch is not split on connection errors", calls)
+	}
+}
+
+func TestInsertIsolatingFailuresSuccess(t *testing.T) {
+	errs := insertIsolatingFailures(make([]record.Record, 3), func([]record.Record) error { return nil })
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("errs[%d] = %v, want nil", i, err)
+		}
+	}
+}
+
+func TestStoredAPIKeyIsHashed(t *testing.T) {
+	apiKey, err := generateSecureRandomKey()
+	if err != nil {
+		t.Fatalf("generateSecureRandomKey() error = %v", err)
+	}
+	stored := newStoredAPIKey(apiKey, APIKeyInfo{Name: "batch"})
+
+	if stored.info.Prefix != apiKey[:apiKeyPrefixLength] {
+		t.Errorf("prefix = %q, want the first %d characters of the key", stored.info.Prefix, apiKeyPrefixLength)
+	}
+	if strings.Contains(stored.hash, apiKey[apiKeyPrefixLength:]) || len(stored.hash) != 64 {
+		t.Errorf("hash = %q, want a hex encoded HMAC-SHA256 that does not hold the key", stored.hash)
+	}
+	if stored.hash != HashAPIKey(apiKey) || stored.hash == hmacAPIKey("other secret", apiKey) {
+		t.Error("hash does not depend on the key and the secret only")
+	}
+}
+
+func TestMatchAPIKey(t *testing.T) {
+	apiKey := "dk0123456789abcdef0123456789abcdef01234567"
+	other := "dk0123456789abcdef0123456789abcdef0123456f"
+	keys := []storedAPIKey{
+		newStoredAPIKey("dkffffffff89abcdef0123456789abcdef01234567", APIKeyInfo{Name: "unrelated"}),
+		newStoredAPIKey(apiKey, APIKeyInfo{Name: "batch"}),
+	}
+
+	if info, ok := matchAPIKey(apiKey, keys, time.Now()); !ok || info.Name != "batch" {
+		t.Errorf("matchAPIKey() = %+v, %v, want the stored key", info, ok)
+	}
+	// A key sharing the prefix of a stored key, or its stored hash, does not authenticate
+	for _, wrong := range []string{other, apiKey[:apiKeyPrefixLength], keys[1].hash, ""} {
+		if info, ok := matchAPIKey(wrong, keys, time.Now()); ok {
+			t.Errorf("matchAPIKey(%q) = %+v, want the key refused", wrong, info)
+		}
+	}
+}
+
+func TestNewStoredAPIKeyIsStable(t *testing.T) {
+	// Keys stored in plaintext by older versions authenticate once hashed, and hashing is keyed
+	// on the key only, so hashing again gives the same stored key
+	apiKey := "dk1123456789abcdef0123456789abcdef01234567"
+	first, again := newStoredAPIKey(apiKey, APIKeyInfo{}), newStoredAPIKey(apiKey, APIKeyInfo{})
+	if first.hash != again.hash || first.info.Prefix != again.info.Prefix {
+		t.Errorf("newStoredAPIKey() = %+v, then %+v, want the same stored key", first, again)
+	}
+	if _, ok := matchAPIKey(apiKey, []storedAPIKey{first}, time.Now()); !ok {
+		t.Error("matchAPIKey() refused a hashed plaintext key")
+	}
+}
+
+func TestMatchAPIKeyExpiry(t *testing.T) {
+	apiKey := "dk2123456789abcdef0123456789abcdef01234567"
+	now := time.Now()
+	expiresAt := now.Add(time.Hour)
+	keys := []storedAPIKey{newStoredAPIKey(apiKey, APIKeyInfo{Name: "batch", ExpiresAt: &expiresAt})}
+
+	if _, ok := matchAPIKey(apiKey, keys, now); !ok {
+		t.Error("matchAPIKey() refused a key before its expiry")
+	}
+	for _, at := range []time.Time{expiresAt, expiresAt.Add(time.Second)} {
+		if _, ok := matchAPIKey(apiKey, keys, at); ok {
+			t.Errorf("matchAPIKey() accepted a key %v after its expiry", at.Sub(expiresAt))
+		}
+	}
+}
+
+func TestRotatedAPIKeyGracePeriod(t *testing.T) {
+	oldKey := "dk3123456789abcdef0123456789abcdef01234567"
+	newKey := "dk4123456789abcdef0123456789abcdef01234567"
+	now := time.Now()
+	grace := 24 * time.Hour
+	rotated := newStoredAPIKey(oldKey, APIKeyInfo{Name: "batch"}).rotate(newKey, grace, nil, now)
+	keys := []storedAPIKey{rotated}
+
+	// The replaced key works during the grace period only, the new key works from the rotation on
+	tests := []struct {
+		name   string
+		apiKey string
+		at     time.Time
+		want   bool
+	}{
+		{"old key at the rotation", oldKey, now, true},
+		{"old key inside the grace period", oldKey, now.Add(grace - time.Second), true},
+		{"old key at the end of the grace period", oldKey, now.Add(grace), false},
+		{"old key after the grace period", oldKey, now.Add(grace + time.Hour), false},
+		{"new key", newKey, now.Add(grace + time.Hour), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, ok := matchAPIKey(test.apiKey, keys, test.at)
+			if ok != test.want {
+				t.Fatalf("matchAPIKey() = %v, want %v", ok, test.want)
+			}
+			if ok && info.Name != "batch" {
+				t.Errorf("matchAPIKey() = %+v, want the rotated key", info)
+			}
+		})
+	}
+	if info, _ := matchAPIKey(oldKey, keys, now); info.ExpiresAt == nil || !info.ExpiresAt.Equal(now.Add(grace)) {
+		t.Errorf("old key expires at %v, want the end of the grace period", info.ExpiresAt)
+	}
+}
+
+func TestRotatedAPIKeyKeepsExpiry(t *testing.T) {
+	oldKey := "dk5123456789abcdef0123456789abcdef01234567"
+	newKey := "dk6123456789abcdef0123456789abcdef01234567"
+	now := time.Now()
+	oldExpiry, newExpiry := now.Add(time.Hour), now.Add(48*time.Hour)
+	rotated := newStoredAPIKey(oldKey, APIKeyInfo{Name: "batch", ExpiresAt: &oldExpiry}).rotate(newKey, 24*time.Hour, &newExpiry, now)
+	keys := []storedAPIKey{rotated}
+
+	// The grace period does not extend the replaced key past its own expiry
+	if _, ok := matchAPIKey(oldKey, keys, oldExpiry.Add(time.Second)); ok {
+		t.Error("matchAPIKey() accepted the replaced key after its own expiry")
+	}
+	if _, ok := matchAPIKey(newKey, keys, newExpiry.Add(-time.Second)); !ok {
+		t.Error("matchAPIKey() refused the new key before its expiry")
+	}
+	if _, ok := matchAPIKey(newKey, keys, newExpiry); ok {
+		t.Error("matchAPIKey() accepted the new key at its expiry")
+	}
+}
@@ -0,0 +1,363 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// migrationsFS holds the numbered SQL migrations, named `NNNN_description.up.sql` and `NNNN_description.down.sql`.
+// The SQL is a template that can reference the configured table names as {{.DataTable}}, {{.APIKeyTable}},
+// {{.OrgTable}}, {{.ProjectTable}}, {{.PricingTable}} and {{.AlertTable}}. An up migration starting with the
+// line `-- +decompress` needs the data table uncompressed; its chunks are decompressed one at a time and
+// compression is turned off before the migration transaction begins.
+//
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migrationsTable records the migrations applied to the database.
+const migrationsTable = "schema_migrations"
+
+// decompressDirective is the first line of the up migrations that need the data table uncompressed.
+const decompressDirective = "-- +decompress"
+
+// migrationLockKey is the key of the advisory lock held while migrations run, so that
+// several ingesters starting at the same time do not apply the same migration twice.
+const migrationLockKey = 4419834722
+
+// migration is a numbered schema change with the SQL to apply and revert it.
+type migration struct {
+	version    int
+	name       string
+	up         string
+	down       string
+	decompress bool // decompress is set when the data table is decompressed before the up migration runs
+}
+
+// MigrationStatus reports whether a migration has been applied to the database.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// migrationTables holds the values available to the migration templates.
+type migrationTables struct {
+	DataTable    string
+	APIKeyTable  string
+	OrgTable     string
+	ProjectTable string
+	PricingTable string
+	AlertTable   string
+}
+
+// configuredTables returns the configured table names for the migration templates.
+func configuredTables() migrationTables {
+	return migrationTables{
+		DataTable:    dbConfig.DataTableName,
+		APIKeyTable:  dbConfig.ApiKeyTableName,
+		OrgTable:     dbConfig.OrgTableName,
+		ProjectTable: dbConfig.ProjectTableName,
+		PricingTable: dbConfig.PricingTableName,
+		AlertTable:   dbConfig.AlertTableName,
+	}
+}
+
+// loadMigrations reads the migrations under 'migrations/' in fsys, renders them with the given
+// table names and returns them ordered by version.
+func loadMigrations(fsys fs.FS, tables migrationTables) ([]migration, error) {
+	files, err := fs.Glob(fsys, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*migration{}
+	for _, file := range files {
+		base := path.Base(file)
+		stem, direction, found := strings.Cut(strings.TrimSuffix(base, ".sql"), ".")
+		if !found || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("Migration file '%s' must end with '.up.sql' or '.down.sql'", base)
+		}
+		versionPart, name, found := strings.Cut(stem, "_")
+		version, err := strconv.Atoi(versionPart)
+		if !found || err != nil || version <= 0 {
+			return nil, fmt.Errorf("Migration file '%s' must start with a version number", base)
+		}
+
+		content, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		tmpl, err := template.New(base).Option("missingkey=error").Parse(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing migration '%s': %w", base, err)
+		}
+		var rendered bytes.Buffer
+		if err := tmpl.Execute(&rendered, tables); err != nil {
+			return nil, fmt.Errorf("Error rendering migration '%s': %w", base, err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: name}
+			byVersion[version] = m
+		} else if m.name != name {
+			return nil, fmt.Errorf("Migration version %d is used by both '%s' and '%s'", version, m.name, name)
+		}
+		if direction == "up" {
+			m.up = rendered.String()
+			m.decompress = strings.HasPrefix(m.up, decompressDirective+"\n")
+		} else {
+			m.down = rendered.String()
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("Migration %04d_%s has no up migration", m.version, m.name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+// withMigrationLock runs fn on a dedicated connection while holding the migration advisory lock.
+func withMigrationLock(fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		return fmt.Errorf("Error acquiring the migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey); err != nil {
+			log.Error().Err(err).Msg("Error releasing the migration lock")
+		}
+	}()
+
+	createSQL := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`, migrationsTable)
+	if _, err := conn.ExecContext(ctx, createSQL); err != nil {
+		return fmt.Errorf("Error creating table '%s': %w", migrationsTable, err)
+	}
+
+	return fn(conn)
+}
+
+// appliedMigrations returns the time each applied migration version was applied at.
+func appliedMigrations(conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(context.Background(), fmt.Sprintf("SELECT version, applied_at FROM %s", migrationsTable))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// decompressDataTable removes the compression policy of the data table, decompresses its chunks
+// one transaction at a time and turns compression off. The ingester turns it back on, along with
+// its policy, at startup when 'dbConfig.compressAfter' is set.
+func decompressDataTable(conn *sql.Conn) error {
+	ctx := context.Background()
+	tableName := dbConfig.DataTableName
+
+	var enabled bool
+	err := conn.QueryRowContext(ctx, `SELECT compression_enabled FROM timescaledb_information.hypertables
+		WHERE lower(hypertable_name) = lower($1)`, tableName).Scan(&enabled)
+	if err == sql.ErrNoRows || (err == nil && !enabled) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Error checking compression of '%s': %w", tableName, err)
+	}
+
+	if _, err := conn.ExecContext(ctx, "SELECT remove_compression_policy($1, if_exists => true)", tableName); err != nil {
+		return fmt.Errorf("Error removing the compression policy of '%s': %w", tableName, err)
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT format('%I.%I', chunk_schema, chunk_name) FROM timescaledb_information.chunks
+		WHERE lower(hypertable_name) = lower($1) AND is_compressed ORDER BY range_start`, tableName)
+	if err != nil {
+		return fmt.Errorf("Error listing the compressed chunks of '%s': %w", tableName, err)
+	}
+	var chunks []string
+	for rows.Next() {
+		var chunk string
+		if err := rows.Scan(&chunk); err != nil {
+			rows.Close()
+			return err
+		}
+		chunks = append(chunks, chunk)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, chunk := range chunks {
+		if _, err := conn.ExecContext(ctx, "SELECT decompress_chunk($1::regclass, if_compressed => true)", chunk); err != nil {
+			return fmt.Errorf("Error decompressing chunk '%s': %w", chunk, err)
+		}
+		log.Info().Msgf("Decompressed chunk %d of %d of '%s'", i+1, len(chunks), tableName)
+	}
+
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s SET (timescaledb.compress = false)", tableName)); err != nil {
+		return fmt.Errorf("Error disabling compression of '%s': %w", tableName, err)
+	}
+	return nil
+}
+
+// runMigration executes the SQL of a migration and records the change in a single transaction.
+// The data table is decompressed first, outside the transaction, when the migration needs it.
+func runMigration(conn *sql.Conn, m migration, up bool) error {
+	ctx := context.Background()
+	if up && m.decompress {
+		if err := decompressDataTable(conn); err != nil {
+			return err
+		}
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statement := m.up
+	record := fmt.Sprintf("INSERT INTO %s (version, name) VALUES ($1, $2)", migrationsTable)
+	args := []interface{}{m.version, m.name}
+	if !up {
+		statement = m.down
+		record = fmt.Sprintf("DELETE FROM %s WHERE version = $1", migrationsTable)
+		args = args[:1]
+	}
+
+	if _, err := tx.ExecContext(ctx, statement); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MigrateUp applies every migration that has not been applied to the database yet, in order.
+func MigrateUp() error {
+	migrations, err := loadMigrations(migrationsFS, configuredTables())
+	if err != nil {
+		return err
+	}
+
+	return withMigrationLock(func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return fmt.Errorf("Error reading applied migrations: %w", err)
+		}
+
+		pending := 0
+		for _, m := range migrations {
+			if _, ok := applied[m.version]; ok {
+				continue
+			}
+			if err := runMigration(conn, m, true); err != nil {
+				return fmt.Errorf("Error applying migration %04d_%s: %w", m.version, m.name, err)
+			}
+			log.Info().Msgf("Applied migration %04d_%s", m.version, m.name)
+			pending++
+		}
+		if pending == 0 {
+			log.Info().Msg("Database schema is up to date")
+		}
+
+		if err := hashPlaintextAPIKeys(conn); err != nil {
+			return fmt.Errorf("Error hashing stored API keys: %w", err)
+		}
+		return nil
+	})
+}
+
+// MigrateDown reverts the given number of most recently applied migrations.
+func MigrateDown(steps int) error {
+	migrations, err := loadMigrations(migrationsFS, configuredTables())
+	if err != nil {
+		return err
+	}
+
+	return withMigrationLock(func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return fmt.Errorf("Error reading applied migrations: %w", err)
+		}
+
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.version]; !ok {
+				continue
+			}
+			if m.down == "" {
+				return fmt.Errorf("Migration %04d_%s cannot be reverted", m.version, m.name)
+			}
+			if err := runMigration(conn, m, false); err != nil {
+				return fmt.Errorf("Error reverting migration %04d_%s: %w", m.version, m.name, err)
+			}
+			log.Info().Msgf("Reverted migration %04d_%s", m.version, m.name)
+			steps--
+		}
+		return nil
+	})
+}
+
+// GetMigrationStatus lists every known migration and when it was applied, if it was.
+func GetMigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(migrationsFS, configuredTables())
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	err = withMigrationLock(func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return fmt.Errorf("Error reading applied migrations: %w", err)
+		}
+		for _, m := range migrations {
+			status := MigrationStatus{Version: m.version, Name: m.name}
+			if appliedAt, ok := applied[m.version]; ok {
+				status.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	return statuses, err
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// migrationFiles returns a file system holding the given migration files under 'migrations/'.
+func migrationFiles(files map[string]string) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for name, content := range files {
+		fsys["migrations/"+name] = &fstest.MapFile{Data: []byte(content)}
+	}
+	return fsys
+}
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := migrationFiles(map[string]string{
+		"0002_add_column.up.sql":    "-- +decompress\nALTER TABLE {{.DataTable}} ADD COLUMN c INT;",
+		"0002_add_column.down.sql":  "ALTER TABLE {{.DataTable}} DROP COLUMN c;",
+		"0001_create_keys.up.sql":   "CREATE TABLE {{.APIKeyTable}} (id INT);",
+		"0010_create_alerts.up.sql": "CREATE TABLE {{.AlertTable}} (budget TEXT); -- +decompress",
+	})
+
+	migrations, err := loadMigrations(fsys, migrationTables{DataTable: "doku", APIKeyTable: "keys", AlertTable: "alerts"})
+	if err != nil {
+		t.Fatalf("loadMigrations() error = %v", err)
+	}
+	want := []migration{
+		{version: 1, name: "create_keys", up: "CREATE TABLE keys (id INT);"},
+		{version: 2, name: "add_column", up: "-- +decompress\nALTER TABLE doku ADD COLUMN c INT;", down: "ALTER TABLE doku DROP COLUMN c;", decompress: true},
+		{version: 10, name: "create_alerts", up: "CREATE TABLE alerts (budget TEXT); -- +decompress"},
+	}
+	if len(migrations) != len(want) {
+		t.Fatalf("loadMigrations() = %+v, want %d migrations", migrations, len(want))
+	}
+	for i := range want {
+		if migrations[i] != want[i] {
+			t.Errorf("migration %d = %+v, want %+v", i, migrations[i], want[i])
+		}
+	}
+}
+
+func TestLoadMigrationsRejectsInvalid(t *testing.T) {
+	tests := map[string]struct {
+		files map[string]string
+		want  string
+	}{
+		"unknown direction": {map[string]string{"0001_create.sql": "SELECT 1;"}, "must end with '.up.sql' or '.down.sql'"},
+		"no version":        {map[string]string{"create.up.sql": "SELECT 1;"}, "must start with a version number"},
+		"version zero":      {map[string]string{"0000_create.up.sql": "SELECT 1;"}, "must start with a version number"},
+		"no name":           {map[string]string{"0001.up.sql": "SELECT 1;"}, "must start with a version number"},
+		"version used twice": {map[string]string{
+			"0001_create.up.sql": "SELECT 1;",
+			"0001_widen.up.sql":  "SELECT 2;",
+		}, "Migration version 1 is used by both"},
+		"down without up":  {map[string]string{"0001_create.down.sql": "SELECT 1;"}, "0001_create has no up migration"},
+		"unknown table":    {map[string]string{"0001_create.up.sql": "CREATE TABLE {{.UsersTable}} (id INT);"}, "Error rendering migration '0001_create.up.sql'"},
+		"invalid template": {map[string]string{"0001_create.up.sql": "CREATE TABLE {{.DataTable (id INT);"}, "Error parsing migration '0001_create.up.sql'"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := loadMigrations(migrationFiles(test.files), migrationTables{DataTable: "doku"})
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("loadMigrations() error = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationsFS, migrationTables{DataTable: "doku", APIKeyTable: "keys", OrgTable: "orgs",
+		ProjectTable: "projects", PricingTable: "pricing", AlertTable: "alerts"})
+	if err != nil {
+		t.Fatalf("loadMigrations() error = %v", err)
+	}
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("migration %04d_%s follows version %d, want versions without gaps", m.version, m.name, i)
+		}
+		// The data rewrite of a decompression does not belong in the migration transaction
+		if strings.Contains(m.up, "decompress_chunk") {
+			t.Errorf("migration %04d_%s decompresses chunks in its transaction, use the %q directive", m.version, m.name, decompressDirective)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS {{.APIKeyTable}} (
+	id SERIAL PRIMARY KEY,
+	api_key VARCHAR(255) NOT NULL UNIQUE,
+	name VARCHAR(50) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_key ON {{.APIKeyTable}} (api_key);
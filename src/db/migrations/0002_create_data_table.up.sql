@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS {{.DataTable}} (
+	time TIMESTAMPTZ NOT NULL,
+	name VARCHAR(10) NOT NULL,
+	environment VARCHAR(50) NOT NULL,
+	endpoint VARCHAR(50) NOT NULL,
+	sourceLanguage VARCHAR(50) NOT NULL,
+	applicationName VARCHAR(50) NOT NULL,
+	completionTokens INTEGER,
+	promptTokens INTEGER,
+	totalTokens INTEGER,
+	finishReason VARCHAR(50),
+	requestDuration DOUBLE PRECISION,
+	usageCost DOUBLE PRECISION,
+	model VARCHAR(50),
+	prompt TEXT,
+	response TEXT,
+	imageSize TEXT,
+	revisedPrompt TEXT,
+	image TEXT,
+	audioVoice TEXT,
+	finetuneJobId TEXT,
+	finetuneJobStatus TEXT
+);
+
+SELECT create_hypertable('{{.DataTable}}', 'time', if_not_exists => TRUE);
@@ -0,0 +1 @@
+ALTER TABLE {{.APIKeyTable}} DROP COLUMN IF EXISTS scopes;
@@ -0,0 +1,4 @@
+ALTER TABLE {{.APIKeyTable}} ADD COLUMN IF NOT EXISTS scopes TEXT[] NOT NULL DEFAULT '{ingest}';
+
+-- Keys created before scopes existed could perform every operation
+UPDATE {{.APIKeyTable}} SET scopes = '{ingest,read,admin}';
@@ -0,0 +1,9 @@
+-- Hashed keys cannot be turned back into plaintext keys, so they are removed.
+DELETE FROM {{.APIKeyTable}} WHERE api_key IS NULL;
+
+DROP INDEX IF EXISTS idx_api_key_prefix;
+ALTER TABLE {{.APIKeyTable}} DROP COLUMN IF EXISTS last_used_at;
+ALTER TABLE {{.APIKeyTable}} DROP COLUMN IF EXISTS created_at;
+ALTER TABLE {{.APIKeyTable}} DROP COLUMN IF EXISTS key_prefix;
+ALTER TABLE {{.APIKeyTable}} DROP COLUMN IF EXISTS key_hash;
+ALTER TABLE {{.APIKeyTable}} ALTER COLUMN api_key SET NOT NULL;
@@ -0,0 +1,10 @@
+ALTER TABLE {{.APIKeyTable}} ALTER COLUMN api_key DROP NOT NULL;
+ALTER TABLE {{.APIKeyTable}} ADD COLUMN IF NOT EXISTS key_hash CHAR(64) UNIQUE;
+ALTER TABLE {{.APIKeyTable}} ADD COLUMN IF NOT EXISTS key_prefix VARCHAR(16);
+ALTER TABLE {{.APIKeyTable}} ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE {{.APIKeyTable}} ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_api_key_prefix ON {{.APIKeyTable}} (key_prefix);
+
+-- The plaintext keys of existing rows are hashed and removed by the ingester once the
+-- migrations are applied, because the hash depends on the configured secret.
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_api_key_previous_prefix;
+ALTER TABLE {{.APIKeyTable}} DROP COLUMN IF EXISTS previous_expires_at;
+ALTER TABLE {{.APIKeyTable}} DROP COLUMN IF EXISTS previous_key_prefix;
+ALTER TABLE {{.APIKeyTable}} DROP COLUMN IF EXISTS previous_key_hash;
+ALTER TABLE {{.APIKeyTable}} DROP COLUMN IF EXISTS expires_at;
@@ -0,0 +1,8 @@
+ALTER TABLE {{.APIKeyTable}} ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+
+-- A rotated key stays valid alongside its replacement until the end of the grace period
+ALTER TABLE {{.APIKeyTable}} ADD COLUMN IF NOT EXISTS previous_key_hash CHAR(64);
+ALTER TABLE {{.APIKeyTable}} ADD COLUMN IF NOT EXISTS previous_key_prefix VARCHAR(16);
+ALTER TABLE {{.APIKeyTable}} ADD COLUMN IF NOT EXISTS previous_expires_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_api_key_previous_prefix ON {{.APIKeyTable}} (previous_key_prefix);
@@ -0,0 +1,11 @@
+ALTER TABLE {{.DataTable}} DROP COLUMN IF EXISTS projectId CASCADE;
+ALTER TABLE {{.DataTable}} DROP COLUMN IF EXISTS orgId CASCADE;
+
+-- Keys of other organizations than the root organization cannot be kept
+DELETE FROM {{.APIKeyTable}} WHERE org_id <> 1;
+DROP INDEX IF EXISTS idx_api_key_org;
+ALTER TABLE {{.APIKeyTable}} DROP COLUMN IF EXISTS project_id;
+ALTER TABLE {{.APIKeyTable}} DROP COLUMN IF EXISTS org_id;
+
+DROP TABLE IF EXISTS {{.ProjectTable}};
+DROP TABLE IF EXISTS {{.OrgTable}};
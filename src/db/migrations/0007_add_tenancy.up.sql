@@ -0,0 +1,41 @@
+CREATE TABLE IF NOT EXISTS {{.OrgTable}} (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(100) NOT NULL UNIQUE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS {{.ProjectTable}} (
+	id SERIAL PRIMARY KEY,
+	org_id INTEGER NOT NULL REFERENCES {{.OrgTable}} (id) ON DELETE CASCADE,
+	name VARCHAR(100) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	UNIQUE (org_id, name)
+);
+
+-- The root organization and its default project own the existing keys and data
+INSERT INTO {{.OrgTable}} (id, name) VALUES (1, 'default') ON CONFLICT DO NOTHING;
+SELECT setval(pg_get_serial_sequence('{{.OrgTable}}', 'id'), (SELECT MAX(id) FROM {{.OrgTable}}));
+INSERT INTO {{.ProjectTable}} (id, org_id, name) VALUES (1, 1, 'default') ON CONFLICT DO NOTHING;
+SELECT setval(pg_get_serial_sequence('{{.ProjectTable}}', 'id'), (SELECT MAX(id) FROM {{.ProjectTable}}));
+
+ALTER TABLE {{.APIKeyTable}} ADD COLUMN IF NOT EXISTS org_id INTEGER NOT NULL DEFAULT 1 REFERENCES {{.OrgTable}} (id) ON DELETE CASCADE;
+ALTER TABLE {{.APIKeyTable}} ADD COLUMN IF NOT EXISTS project_id INTEGER NOT NULL DEFAULT 1 REFERENCES {{.ProjectTable}} (id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS idx_api_key_org ON {{.APIKeyTable}} (org_id, name);
+
+-- The rollups are recreated by the ingester with the tenant columns
+DROP MATERIALIZED VIEW IF EXISTS {{.DataTable}}_daily;
+DROP MATERIALIZED VIEW IF EXISTS {{.DataTable}}_hourly;
+
+ALTER TABLE {{.DataTable}} ADD COLUMN IF NOT EXISTS orgId INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE {{.DataTable}} ADD COLUMN IF NOT EXISTS projectId INTEGER NOT NULL DEFAULT 1;
+
+-- Key names can be longer than the original column allows. The type of a column cannot be
+-- changed while compression is enabled, migration 0013 widens it on compressed tables.
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM timescaledb_information.hypertables
+		WHERE lower(hypertable_name) = lower('{{.DataTable}}') AND compression_enabled) THEN
+		ALTER TABLE {{.DataTable}} ALTER COLUMN name TYPE VARCHAR(50);
+	END IF;
+END
+$$;
@@ -0,0 +1,2 @@
+ALTER TABLE {{.DataTable}} DROP COLUMN IF EXISTS cacheWriteTokens;
+ALTER TABLE {{.DataTable}} DROP COLUMN IF EXISTS cacheReadTokens;
@@ -0,0 +1,2 @@
+ALTER TABLE {{.DataTable}} ADD COLUMN IF NOT EXISTS cacheReadTokens INTEGER;
+ALTER TABLE {{.DataTable}} ADD COLUMN IF NOT EXISTS cacheWriteTokens INTEGER;
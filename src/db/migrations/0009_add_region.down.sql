@@ -0,0 +1 @@
+ALTER TABLE {{.DataTable}} DROP COLUMN IF EXISTS region;
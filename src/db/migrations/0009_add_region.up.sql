@@ -0,0 +1 @@
+ALTER TABLE {{.DataTable}} ADD COLUMN IF NOT EXISTS region VARCHAR(50);
@@ -0,0 +1,3 @@
+ALTER TABLE {{.DataTable}} DROP COLUMN IF EXISTS pricingVersion;
+
+DROP TABLE IF EXISTS {{.PricingTable}};
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS {{.PricingTable}} (
+	version SERIAL PRIMARY KEY,
+	effective_from TIMESTAMPTZ NOT NULL,
+	checksum CHAR(64) NOT NULL UNIQUE,
+	document JSONB NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_pricing_effective_from ON {{.PricingTable}} (effective_from);
+
+-- Records written before pricing was versioned have no pricing version
+ALTER TABLE {{.DataTable}} ADD COLUMN IF NOT EXISTS pricingVersion INTEGER;
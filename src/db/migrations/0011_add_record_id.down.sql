@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_data_time_id;
+ALTER TABLE {{.DataTable}} DROP COLUMN IF EXISTS id;
@@ -0,0 +1,6 @@
+-- +decompress
+-- Records get a sequence number that orders the records sharing a timestamp, so that pages of
+-- the query API are stable. A column with a generated default cannot be added while compression
+-- is enabled, so the migration runs once the chunks are decompressed and compression is off.
+ALTER TABLE {{.DataTable}} ADD COLUMN IF NOT EXISTS id BIGSERIAL;
+CREATE INDEX IF NOT EXISTS idx_data_time_id ON {{.DataTable}} (time DESC, id DESC);
@@ -0,0 +1,2 @@
+DROP MATERIALIZED VIEW IF EXISTS {{.DataTable}}_daily;
+DROP MATERIALIZED VIEW IF EXISTS {{.DataTable}}_hourly;
@@ -0,0 +1,4 @@
+-- The rollups created along with the tenant columns grouped by orgId and projectId without
+-- selecting them. The ingester recreates them with the tenant columns at startup.
+DROP MATERIALIZED VIEW IF EXISTS {{.DataTable}}_daily;
+DROP MATERIALIZED VIEW IF EXISTS {{.DataTable}}_hourly;
@@ -0,0 +1,2 @@
+-- Names are not shortened again since stored names may be longer than 10 characters
+SELECT 1;
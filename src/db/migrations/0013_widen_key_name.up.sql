@@ -0,0 +1,10 @@
+-- +decompress
+-- Migration 0007 only widened the key name column when compression was off, leaving names limited
+-- to 10 characters on compressed tables. The type of a column cannot be changed while compression
+-- is enabled, so the migration runs once the chunks are decompressed and compression is off.
+
+-- The rollups select the column and are recreated by the ingester at startup
+DROP MATERIALIZED VIEW IF EXISTS {{.DataTable}}_daily;
+DROP MATERIALIZED VIEW IF EXISTS {{.DataTable}}_hourly;
+
+ALTER TABLE {{.DataTable}} ALTER COLUMN name TYPE VARCHAR(50);
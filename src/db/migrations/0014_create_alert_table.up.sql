@@ -0,0 +1,8 @@
+-- The thresholds of each budget that were crossed and notified, so alerts are not sent again
+-- when the ingester restarts
+CREATE TABLE IF NOT EXISTS {{.AlertTable}} (
+	budget VARCHAR(100) NOT NULL,
+	threshold DOUBLE PRECISION NOT NULL,
+	alerted_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (budget, threshold)
+);
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+)
+
+// validateInterval checks that a configured value is a valid PostgreSQL interval.
+func validateInterval(db *sql.DB, setting, interval string) error {
+	if interval == "" {
+		return nil
+	}
+	if _, err := db.Exec("SELECT $1::interval", interval); err != nil {
+		return fmt.Errorf("'dbConfig.%s' value '%s' is not a valid interval: %w", setting, interval, err)
+	}
+	return nil
+}
+
+// intervalsEqual compares two PostgreSQL intervals.
+func intervalsEqual(db *sql.DB, a, b string) (bool, error) {
+	var equal bool
+	err := db.QueryRow("SELECT $1::interval = $2::interval", a, b).Scan(&equal)
+	return equal, err
+}
+
+// applyChunkTimeInterval sets the chunk time interval of the data hypertable if it differs from the configuration.
+// The new interval only applies to chunks created afterwards.
+func applyChunkTimeInterval(db *sql.DB, tableName, interval string) error {
+	if interval == "" {
+		return nil
+	}
+
+	var current string
+	err := db.QueryRow(`SELECT time_interval::text FROM timescaledb_information.dimensions
+		WHERE lower(hypertable_name) = lower($1) AND column_name = 'time'`, tableName).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("Error reading chunk time interval of '%s': %w", tableName, err)
+	}
+
+	equal, err := intervalsEqual(db, current, interval)
+	if err != nil {
+		return err
+	}
+	if equal {
+		return nil
+	}
+
+	if _, err := db.Exec("SELECT set_chunk_time_interval($1::regclass, $2::interval)", tableName, interval); err != nil {
+		return fmt.Errorf("Error setting chunk time interval of '%s': %w", tableName, err)
+	}
+	log.Info().Msgf("Chunk time interval of '%s' changed from '%s' to '%s'", tableName, current, interval)
+	return nil
+}
+
+// currentPolicyInterval returns the interval of the policy job of a hypertable, or an empty string if there is no such job.
+func currentPolicyInterval(db *sql.DB, tableName, procName, configKey string) (string, error) {
+	var current string
+	err := db.QueryRow(`SELECT config->>$3 FROM timescaledb_information.jobs
+		WHERE lower(hypertable_name) = lower($1) AND proc_name = $2`, tableName, procName, configKey).Scan(&current)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return current, err
+}
+
+// reconcilePolicy makes the policy job of a hypertable match the configured interval, replacing
+// the job when the interval changed and removing it when the interval is no longer configured.
+func reconcilePolicy(db *sql.DB, tableName, policy, procName, configKey, interval string) error {
+	current, err := currentPolicyInterval(db, tableName, procName, configKey)
+	if err != nil {
+		return fmt.Errorf("Error reading %s policy of '%s': %w", policy, tableName, err)
+	}
+
+	if current != "" && interval != "" {
+		equal, err := intervalsEqual(db, current, interval)
+		if err != nil {
+			return err
+		}
+		if equal {
+			log.Info().Msgf("The %s policy of '%s' is up to date", policy, tableName)
+			return nil
+		}
+	}
+
+	if current != "" {
+		removeSQL := fmt.Sprintf("SELECT remove_%s_policy($1::regclass, if_exists => true)", policy)
+		if _, err := db.Exec(removeSQL, tableName); err != nil {
+			return fmt.Errorf("Error removing %s policy of '%s': %w", policy, tableName, err)
+		}
+		log.Info().Msgf("Removed the %s policy of '%s' with interval '%s'", policy, tableName, current)
+	}
+
+	if interval != "" {
+		addSQL := fmt.Sprintf("SELECT add_%s_policy($1::regclass, $2::interval)", policy)
+		if _, err := db.Exec(addSQL, tableName, interval); err != nil {
+			return fmt.Errorf("Error adding %s policy of '%s': %w", policy, tableName, err)
+		}
+		log.Info().Msgf("Added a %s policy of '%s' with interval '%s'", policy, tableName, interval)
+	}
+	return nil
+}
+
+// enableCompression turns on native compression of the data hypertable if it is not enabled yet.
+func enableCompression(db *sql.DB, tableName string) error {
+	var enabled bool
+	err := db.QueryRow(`SELECT compression_enabled FROM timescaledb_information.hypertables
+		WHERE lower(hypertable_name) = lower($1)`, tableName).Scan(&enabled)
+	if err != nil {
+		return fmt.Errorf("Error checking compression of '%s': %w", tableName, err)
+	}
+	if enabled {
+		return nil
+	}
+
+	alterSQL := fmt.Sprintf(`ALTER TABLE %s SET (
+		timescaledb.compress,
+		timescaledb.compress_segmentby = 'applicationName, endpoint',
+		timescaledb.compress_orderby = 'time DESC, id DESC'
+	)`, tableName)
+	if _, err := db.Exec(alterSQL); err != nil {
+		return fmt.Errorf("Error enabling compression of '%s': %w", tableName, err)
+	}
+	log.Info().Msgf("Compression enabled on '%s'", tableName)
+	return nil
+}
+
+// applyDataTablePolicies reconciles the chunk time interval, compression and retention of the
+// data hypertable with the configuration.
+func applyDataTablePolicies(db *sql.DB) error {
+	tableName := dbConfig.DataTableName
+
+	settings := map[string]string{
+		"chunkTimeInterval": dbConfig.ChunkTimeInterval,
+		"compressAfter":     dbConfig.CompressAfter,
+		"retentionInterval": dbConfig.RetentionInterval,
+	}
+	for setting, interval := range settings {
+		if err := validateInterval(db, setting, interval); err != nil {
+			return err
+		}
+	}
+
+	if err := applyChunkTimeInterval(db, tableName, dbConfig.ChunkTimeInterval); err != nil {
+		return err
+	}
+
+	if dbConfig.CompressAfter != "" {
+		if err := enableCompression(db, tableName); err != nil {
+			return err
+		}
+	}
+	if err := reconcilePolicy(db, tableName, "compression", "policy_compression", "compress_after", dbConfig.CompressAfter); err != nil {
+		return err
+	}
+
+	return reconcilePolicy(db, tableName, "retention", "policy_retention", "drop_after", dbConfig.RetentionInterval)
+}
@@ -0,0 +1,211 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"ingester/cost"
+	"ingester/record"
+
+	"github.com/rs/zerolog/log"
+)
+
+// repriceBatchSize is the number of records read per page, and updated in one transaction, when repricing.
+const repriceBatchSize = 500
+
+// SyncPricing stores the pricing document loaded from the file or URL as a new pricing version,
+// unless the same document is already stored, and loads every stored version so that records are
+// priced with the version in effect at their time.
+func SyncPricing() error {
+	loaded := cost.LoadedVersion()
+	if loaded.Document != nil {
+		query := fmt.Sprintf(`INSERT INTO %s (effective_from, checksum, document) VALUES ($1, $2, $3)
+			ON CONFLICT (checksum) DO NOTHING`, dbConfig.PricingTableName)
+		if _, err := db.Exec(query, loaded.EffectiveFrom, loaded.Checksum, string(loaded.Document)); err != nil {
+			return fmt.Errorf("Error storing the pricing version: %w", err)
+		}
+	}
+
+	query := fmt.Sprintf("SELECT version, effective_from, document FROM %s ORDER BY effective_from, version", dbConfig.PricingTableName)
+	rows, err := db.Query(query)
+	if err != nil {
+		return fmt.Errorf("Error loading the pricing versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []cost.Version
+	for rows.Next() {
+		var version cost.Version
+		var document string
+		if err := rows.Scan(&version.Number, &version.EffectiveFrom, &document); err != nil {
+			return err
+		}
+		// A version that no longer validates, such as one stored by a newer ingester, is skipped
+		pricingModel, err := cost.ParseDocument([]byte(document))
+		if err != nil {
+			log.Warn().Err(err).Msgf("Skipping invalid pricing version %d", version.Number)
+			continue
+		}
+		version.Document, version.Pricing = []byte(document), pricingModel
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	cost.SetVersions(versions)
+	log.Info().Msgf("Loaded %d pricing versions", len(versions))
+	return nil
+}
+
+// syncPricingOnReload stores the pricing documents loaded after startup as new versions. A document
+// that cannot be stored is still used for the records from the time it applies, see cost.PricingAt.
+func syncPricingOnReload() {
+	if err := SyncPricing(); err != nil {
+		log.Error().Err(err).Msg("Error storing the reloaded pricing information, it is used without a pricing version")
+	}
+}
+
+// repricedRecord is a record whose usage cost changed when it was priced again, identified by
+// its time and sequence number.
+type repricedRecord struct {
+	time           time.Time
+	id             int64
+	usageCost      float64
+	pricingVersion *int
+}
+
+// RepriceRecords calculates the usage cost of the records in the time range again, with the pricing
+// version in effect at their time, and updates the records whose cost or pricing version changed.
+// The overrides are not versioned, so the records of tenants with a pricing override are skipped
+// unless includeOverrides is set, in which case they are priced with the overrides configured now.
+// The range is read in pages in time order, and each page is updated in a transaction that first
+// decompresses the chunks it covers. The rollups are refreshed over the range afterwards. It returns
+// the number of updated records, which on error is the number of records updated before the error.
+func RepriceRecords(from, to time.Time, includeOverrides bool) (int, error) {
+	updated, skipped := 0, 0
+	cursor := dataCursor{Time: from}
+	for {
+		page, repriced, negotiated, err := repricePage(from, to, cursor, includeOverrides)
+		if err != nil {
+			return updated, err
+		}
+		skipped += negotiated
+		if len(repriced) > 0 {
+			if err := updateRepriced(page[0], page[len(page)-1], repriced); err != nil {
+				return updated, err
+			}
+			updated += len(repriced)
+		}
+		if len(page) < repriceBatchSize {
+			break
+		}
+		cursor = page[len(page)-1]
+	}
+
+	if skipped > 0 {
+		log.Info().Msgf("Skipped %d records of tenants with a pricing override", skipped)
+	}
+
+	if err := refreshRollups(from, to); err != nil {
+		return updated, err
+	}
+	return updated, nil
+}
+
+// repricePage reads the page of records of the time range after the cursor and prices them again.
+// It returns the positions of the records read, the records whose cost or pricing version changed,
+// and the number of records skipped because a pricing override applies to them.
+func repricePage(from, to time.Time, after dataCursor, includeOverrides bool) ([]dataCursor, []repricedRecord, int, error) {
+	query := fmt.Sprintf(`SELECT time, id, name, orgId, applicationName, endpoint, model, region,
+		promptTokens, completionTokens, totalTokens, cacheReadTokens, cacheWriteTokens, imageSize, imageQuality, prompt, response,
+		usageCost, pricingVersion FROM %s WHERE time >= $1 AND time < $2 AND (time, id) > ($3, $4)
+		ORDER BY time, id LIMIT $5`, dbConfig.DataTableName)
+	rows, err := db.Query(query, from, to, after.Time, after.ID, repriceBatchSize)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("Error reading the records to reprice: %w", err)
+	}
+	defer rows.Close()
+
+	var page []dataCursor
+	var repriced []repricedRecord
+	skipped := 0
+	for rows.Next() {
+		var r record.Record
+		var target repricedRecord
+		var name, model, region, imageSize, imageQuality, prompt, response sql.NullString
+		var promptTokens, completionTokens, totalTokens, cacheReadTokens, cacheWriteTokens, pricingVersion sql.NullInt64
+		var usageCost sql.NullFloat64
+		err := rows.Scan(&target.time, &target.id, &name, &r.OrgID, &r.ApplicationName, &r.Endpoint, &model, &region,
+			&promptTokens, &completionTokens, &totalTokens, &cacheReadTokens, &cacheWriteTokens, &imageSize, &imageQuality, &prompt, &response,
+			&usageCost, &pricingVersion)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		page = append(page, dataCursor{Time: target.time, ID: target.id})
+
+		r.Time = &target.time
+		r.Name = name.String
+		r.Model, r.Region, r.ImageSize, r.ImageQuality = model.String, region.String, imageSize.String, imageQuality.String
+		r.Prompt, r.Response = prompt.String, response.String
+		r.PromptTokens, r.CompletionTokens, r.TotalTokens = nullInt(promptTokens), nullInt(completionTokens), nullInt(totalTokens)
+		r.CacheReadTokens, r.CacheWriteTokens = nullInt(cacheReadTokens), nullInt(cacheWriteTokens)
+
+		if !includeOverrides && r.Negotiated() {
+			skipped++
+			continue
+		}
+
+		r.CalculateUsageCost()
+		if r.UsageCost == nil {
+			continue
+		}
+		sameVersion := (r.PricingVersion == nil && !pricingVersion.Valid) ||
+			(r.PricingVersion != nil && pricingVersion.Valid && int64(*r.PricingVersion) == pricingVersion.Int64)
+		if usageCost.Valid && usageCost.Float64 == *r.UsageCost && sameVersion {
+			continue
+		}
+
+		target.usageCost, target.pricingVersion = *r.UsageCost, r.PricingVersion
+		repriced = append(repriced, target)
+	}
+	return page, repriced, skipped, rows.Err()
+}
+
+// updateRepriced stores the usage cost of the repriced records of a page in a transaction. The
+// compressed chunks between the first and the last record of the page are decompressed in the
+// transaction first, which also keeps the compression policy from compressing them again until
+// the records are updated.
+func updateRepriced(first, last dataCursor, repriced []repricedRecord) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	decompress := `SELECT decompress_chunk(format('%I.%I', chunk_schema, chunk_name)::regclass, if_compressed => true)
+		FROM timescaledb_information.chunks
+		WHERE lower(hypertable_name) = lower($1) AND is_compressed AND range_start <= $3 AND range_end > $2`
+	if _, err := tx.Exec(decompress, dbConfig.DataTableName, first.Time, last.Time); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Error decompressing the chunks of the records to reprice: %w", err)
+	}
+
+	update := fmt.Sprintf("UPDATE %s SET usageCost = $1, pricingVersion = $2 WHERE time = $3 AND id = $4", dbConfig.DataTableName)
+	for _, target := range repriced {
+		if _, err := tx.Exec(update, target.usageCost, target.pricingVersion, target.time, target.id); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Error updating the usage cost of a record: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// nullInt returns a pointer to the value of a nullable integer column, or nil when it is NULL.
+func nullInt(value sql.NullInt64) *int {
+	if !value.Valid {
+		return nil
+	}
+	n := int(value.Int64)
+	return &n
+}
@@ -0,0 +1,286 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// GroupByColumns are the columns that aggregates can be grouped by.
+var GroupByColumns = []string{"environment", "applicationName", "endpoint", "model"}
+
+// DataFilter holds the filters applied when reading the data table. Empty fields are not filtered on.
+// The API always sets OrgID to the organization of the caller, only internal readers such as budget
+// alerts leave it empty to read every organization.
+type DataFilter struct {
+	OrgID           int
+	ProjectID       int
+	From            time.Time
+	To              time.Time
+	Environment     string
+	ApplicationName string
+	Endpoint        string
+	Model           string
+}
+
+// DataRecord is a record read from the data table.
+type DataRecord struct {
+	ID                int64     `json:"id"`
+	Time              time.Time `json:"time"`
+	Name              string    `json:"name"`
+	Environment       string    `json:"environment"`
+	Endpoint          string    `json:"endpoint"`
+	SourceLanguage    string    `json:"sourceLanguage"`
+	ApplicationName   string    `json:"applicationName"`
+	CompletionTokens  *int64    `json:"completionTokens"`
+	PromptTokens      *int64    `json:"promptTokens"`
+	TotalTokens       *int64    `json:"totalTokens"`
+	FinishReason      *string   `json:"finishReason"`
+	RequestDuration   *float64  `json:"requestDuration"`
+	UsageCost         *float64  `json:"usageCost"`
+	Model             *string   `json:"model"`
+	Prompt            *string   `json:"prompt"`
+	Response          *string   `json:"response"`
+	ImageSize         *string   `json:"imageSize"`
+	RevisedPrompt     *string   `json:"revisedPrompt"`
+	Image             *string   `json:"image"`
+	AudioVoice        *string   `json:"audioVoice"`
+	FinetuneJobID     *string   `json:"finetuneJobId"`
+	FinetuneJobStatus *string   `json:"finetuneJobStatus"`
+	ImageQuality      *string   `json:"imageQuality"`
+	OrgID             int       `json:"orgId"`
+	ProjectID         int       `json:"projectId"`
+	CacheReadTokens   *int64    `json:"cacheReadTokens"`
+	CacheWriteTokens  *int64    `json:"cacheWriteTokens"`
+	Region            *string   `json:"region"`
+	PricingVersion    *int64    `json:"pricingVersion"`
+}
+
+// AggregateRecord holds the totals of a time bucket, optionally for one value of the grouped column.
+type AggregateRecord struct {
+	Bucket           time.Time `json:"bucket"`
+	Group            *string   `json:"group,omitempty"`
+	UsageCost        float64   `json:"usageCost"`
+	PromptTokens     int64     `json:"promptTokens"`
+	CompletionTokens int64     `json:"completionTokens"`
+	TotalTokens      int64     `json:"totalTokens"`
+	Requests         int64     `json:"requests"`
+}
+
+// dataCursor is the position of the last record returned by a page of QueryData. Records sharing
+// the timestamp of the cursor are ordered by their id.
+type dataCursor struct {
+	Time time.Time `json:"t"`
+	ID   int64     `json:"i"`
+}
+
+// encodeCursor returns the opaque representation of a cursor.
+func encodeCursor(cursor dataCursor) string {
+	content, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(content)
+}
+
+// decodeCursor parses the opaque representation of a cursor.
+func decodeCursor(value string) (*dataCursor, error) {
+	content, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("INVALIDCURSOR")
+	}
+	var cursor dataCursor
+	if err := json.Unmarshal(content, &cursor); err != nil || cursor.Time.IsZero() {
+		return nil, fmt.Errorf("INVALIDCURSOR")
+	}
+	return &cursor, nil
+}
+
+// filterConditions returns the SQL conditions and arguments of a filter, numbering the
+// placeholders after the given number of existing arguments.
+func filterConditions(filter DataFilter, timeColumn string, args []interface{}) ([]string, []interface{}) {
+	var conditions []string
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.OrgID != 0 {
+		add("orgId = $%d", filter.OrgID)
+	}
+	if filter.ProjectID != 0 {
+		add("projectId = $%d", filter.ProjectID)
+	}
+	if !filter.From.IsZero() {
+		add(timeColumn+" >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		add(timeColumn+" < $%d", filter.To)
+	}
+	if filter.Environment != "" {
+		add("environment = $%d", filter.Environment)
+	}
+	if filter.ApplicationName != "" {
+		add("applicationName = $%d", filter.ApplicationName)
+	}
+	if filter.Endpoint != "" {
+		add("endpoint = $%d", filter.Endpoint)
+	}
+	if filter.Model != "" {
+		add("model = $%d", filter.Model)
+	}
+	return conditions, args
+}
+
+// whereClause joins SQL conditions into a WHERE clause.
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+// QueryData reads a page of records matching the filter, newest first. It returns the cursor
+// of the next page, which is empty when there are no more records. Pages are read after the
+// position of the cursor, so records inserted meanwhile do not shift them.
+func QueryData(filter DataFilter, cursorValue string, limit int) ([]DataRecord, string, error) {
+	var cursor *dataCursor
+	if cursorValue != "" {
+		var err error
+		if cursor, err = decodeCursor(cursorValue); err != nil {
+			return nil, "", err
+		}
+	}
+
+	conditions, args := filterConditions(filter, "time", nil)
+	if cursor != nil {
+		args = append(args, cursor.Time, cursor.ID)
+		conditions = append(conditions, fmt.Sprintf("(time, id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+	args = append(args, limit+1)
+
+	query := fmt.Sprintf("SELECT id, time, %s FROM %s%s ORDER BY time DESC, id DESC LIMIT $%d",
+		strings.Join(validFields, ", "), dbConfig.DataTableName, whereClause(conditions), len(args))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Error().Err(err).Msg("Error querying the data table")
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	records := []DataRecord{}
+	for rows.Next() {
+		var r DataRecord
+		err := rows.Scan(&r.ID, &r.Time, &r.Name, &r.Environment, &r.Endpoint, &r.SourceLanguage, &r.ApplicationName,
+			&r.CompletionTokens, &r.PromptTokens, &r.TotalTokens, &r.FinishReason, &r.RequestDuration, &r.UsageCost,
+			&r.Model, &r.Prompt, &r.Response, &r.ImageSize, &r.RevisedPrompt, &r.Image, &r.AudioVoice,
+			&r.FinetuneJobID, &r.FinetuneJobStatus, &r.ImageQuality,
+			&r.OrgID, &r.ProjectID, &r.CacheReadTokens, &r.CacheWriteTokens, &r.Region, &r.PricingVersion)
+		if err != nil {
+			return nil, "", err
+		}
+		records = append(records, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if len(records) <= limit {
+		return records, "", nil
+	}
+	records = records[:limit]
+
+	last := records[len(records)-1]
+	return records, encodeCursor(dataCursor{Time: last.Time, ID: last.ID}), nil
+}
+
+// AggregateData returns the cost, token and request totals of the records matching the filter,
+// bucketed by the given interval and optionally grouped by one of the GroupByColumns. Long time
+// ranges are read from the hourly or daily rollups when the bucket is aligned to them.
+func AggregateData(filter DataFilter, bucket time.Duration, groupBy string) ([]AggregateRecord, error) {
+	groupColumn := "NULL::text"
+	if groupBy != "" {
+		groupColumn = ""
+		for _, column := range GroupByColumns {
+			if column == groupBy {
+				groupColumn = column
+			}
+		}
+		if groupColumn == "" {
+			return nil, fmt.Errorf("INVALIDGROUP")
+		}
+	}
+
+	table, timeColumn, fromRollup := aggregateSource(filter, bucket)
+	requests := "COUNT(*)"
+	if fromRollup {
+		requests = "SUM(requests)"
+	}
+
+	args := []interface{}{fmt.Sprintf("%d seconds", int64(bucket.Seconds()))}
+	conditions, args := filterConditions(filter, timeColumn, args)
+
+	query := fmt.Sprintf(`SELECT time_bucket($1::interval, %s) AS bkt, %s AS grp,
+		COALESCE(SUM(usageCost), 0), COALESCE(SUM(promptTokens), 0), COALESCE(SUM(completionTokens), 0),
+		COALESCE(SUM(totalTokens), 0), %s
+		FROM %s%s GROUP BY bkt, grp ORDER BY bkt, grp`, timeColumn, groupColumn, requests, table, whereClause(conditions))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Error().Err(err).Msg("Error aggregating the data table")
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []AggregateRecord{}
+	for rows.Next() {
+		var r AggregateRecord
+		if err := rows.Scan(&r.Bucket, &r.Group, &r.UsageCost, &r.PromptTokens, &r.CompletionTokens, &r.TotalTokens, &r.Requests); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// TotalUsageCost returns the total cost of the records matching the filter.
+func TotalUsageCost(filter DataFilter) (float64, error) {
+	query, args := totalUsageCostQuery(filter)
+
+	var total float64
+	if err := db.QueryRow(query, args...).Scan(&total); err != nil {
+		log.Error().Err(err).Msg("Error summing the usage cost of the data table")
+		return 0, err
+	}
+	return total, nil
+}
+
+// totalUsageCostQuery returns the query summing the cost of the records matching the filter. The
+// whole hours of a time range are summed from the hourly rollup, and only the partial hours at
+// its ends from the data table.
+func totalUsageCostQuery(filter DataFilter) (string, []interface{}) {
+	sumQuery := func(table, timeColumn string, filter DataFilter, args []interface{}) (string, []interface{}) {
+		conditions, args := filterConditions(filter, timeColumn, args)
+		return fmt.Sprintf("SELECT COALESCE(SUM(usageCost), 0) FROM %s%s", table, whereClause(conditions)), args
+	}
+
+	firstHour := filter.From.Truncate(time.Hour)
+	if firstHour.Before(filter.From) {
+		firstHour = firstHour.Add(time.Hour)
+	}
+	lastHour := filter.To.Truncate(time.Hour)
+	if filter.From.IsZero() || filter.To.IsZero() || !firstHour.Before(lastHour) {
+		return sumQuery(dbConfig.DataTableName, "time", filter, nil)
+	}
+
+	head, tail, hours := filter, filter, filter
+	head.To, tail.From = firstHour, lastHour
+	hours.From, hours.To = firstHour, lastHour
+
+	headQuery, args := sumQuery(dbConfig.DataTableName, "time", head, nil)
+	hoursQuery, args := sumQuery(dbConfig.DataTableName+"_hourly", "bucket", hours, args)
+	tailQuery, args := sumQuery(dbConfig.DataTableName, "time", tail, args)
+	return fmt.Sprintf("SELECT (%s) + (%s) + (%s)", headQuery, hoursQuery, tailQuery), args
+}
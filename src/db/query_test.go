@@ -0,0 +1,77 @@
+package db
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	cursor := dataCursor{Time: time.Date(2024, 3, 1, 12, 0, 0, 123456000, time.UTC), ID: 42}
+
+	decoded, err := decodeCursor(encodeCursor(cursor))
+	if err != nil {
+		t.Fatalf("decodeCursor() error = %v", err)
+	}
+	if !decoded.Time.Equal(cursor.Time) || decoded.ID != cursor.ID {
+		t.Errorf("decodeCursor() = %+v, want %+v", decoded, cursor)
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	for name, value := range map[string]string{
+		"not base64":   "%%%",
+		"not json":     base64.RawURLEncoding.EncodeToString([]byte("cursor")),
+		"missing time": base64.RawURLEncoding.EncodeToString([]byte(`{"i":3}`)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := decodeCursor(value); err == nil || err.Error() != "INVALIDCURSOR" {
+				t.Fatalf("decodeCursor(%q) error = %v, want INVALIDCURSOR", value, err)
+			}
+		})
+	}
+}
+
+func TestFilterConditions(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	filter := DataFilter{OrgID: 2, From: from, ApplicationName: "chatbot"}
+
+	conditions, args := filterConditions(filter, "bucket", []interface{}{"1 hour"})
+	want := []string{"orgId = $2", "bucket >= $3", "applicationName = $4"}
+	if strings.Join(conditions, ", ") != strings.Join(want, ", ") {
+		t.Errorf("conditions = %v, want %v", conditions, want)
+	}
+	if len(args) != 4 || args[1] != 2 || args[2] != from || args[3] != "chatbot" {
+		t.Errorf("args = %v, want the interval followed by the filter values", args)
+	}
+	if whereClause(nil) != "" || whereClause(conditions) != " WHERE orgId = $2 AND bucket >= $3 AND applicationName = $4" {
+		t.Errorf("whereClause() = %q", whereClause(conditions))
+	}
+}
+
+func TestTotalUsageCostQuery(t *testing.T) {
+	dbConfig.DataTableName = "DOKU"
+	from := time.Date(2024, 3, 1, 10, 20, 0, 0, time.UTC)
+	to := time.Date(2024, 3, 2, 10, 5, 0, 0, time.UTC)
+
+	query, args := totalUsageCostQuery(DataFilter{OrgID: 2, From: from, To: to})
+	want := "SELECT (SELECT COALESCE(SUM(usageCost), 0) FROM DOKU WHERE orgId = $1 AND time >= $2 AND time < $3) + " +
+		"(SELECT COALESCE(SUM(usageCost), 0) FROM DOKU_hourly WHERE orgId = $4 AND bucket >= $5 AND bucket < $6) + " +
+		"(SELECT COALESCE(SUM(usageCost), 0) FROM DOKU WHERE orgId = $7 AND time >= $8 AND time < $9)"
+	if query != want {
+		t.Errorf("query = %s, want %s", query, want)
+	}
+	firstHour, lastHour := time.Date(2024, 3, 1, 11, 0, 0, 0, time.UTC), time.Date(2024, 3, 2, 10, 0, 0, 0, time.UTC)
+	for i, bound := range []time.Time{from, firstHour, firstHour, lastHour, lastHour, to} {
+		if got := args[[]int{1, 2, 4, 5, 7, 8}[i]]; got != bound {
+			t.Errorf("bound %d = %v, want %v", i, got, bound)
+		}
+	}
+
+	// Ranges without a whole hour are summed from the data table only
+	query, _ = totalUsageCostQuery(DataFilter{From: from, To: from.Add(50 * time.Minute)})
+	if query != "SELECT COALESCE(SUM(usageCost), 0) FROM DOKU WHERE time >= $1 AND time < $2" {
+		t.Errorf("query = %s, want the data table only", query)
+	}
+}
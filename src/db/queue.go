@@ -0,0 +1,174 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"ingester/metrics"
+	"ingester/record"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrQueueFull is returned when the ingestion queue cannot accept more records.
+	ErrQueueFull = errors.New("QUEUEFULL")
+	// ErrQueueClosed is returned when the ingestion queue is draining for shutdown.
+	ErrQueueClosed = errors.New("QUEUECLOSED")
+
+	insertQueue  chan record.Record // insertQueue holds the records waiting to be written to the database
+	queueMu      sync.RWMutex       // queueMu guards closing the queue against concurrent enqueues
+	queueClosed  bool               // queueClosed is set once the queue starts draining
+	queueWorkers sync.WaitGroup     // queueWorkers tracks the running insert workers
+)
+
+func init() {
+	metrics.NewGaugeFunc("doku_ingester_queue_length", "Number of records waiting in the ingestion queue.", func() float64 {
+		return float64(QueueLength())
+	})
+}
+
+// startInsertWorkers creates the ingestion queue of the given size and starts a pool of insert workers.
+func startInsertWorkers(size, workers int) {
+	queueMu.Lock()
+	insertQueue = make(chan record.Record, size)
+	queueMu.Unlock()
+	for i := 0; i < workers; i++ {
+		queueWorkers.Add(1)
+		go runInsertWorker()
+	}
+	log.Info().Msgf("Started %d insert workers with a queue size of %d", workers, size)
+}
+
+// runInsertWorker reads records from the queue and writes them to the database
+// once the batch size is reached or the flush interval has passed.
+func runInsertWorker() {
+	defer queueWorkers.Done()
+
+	batch := make([]record.Record, 0, dbConfig.QueueBatchSize)
+	ticker := time.NewTicker(dbConfig.QueueFlushInterval)
+	defer ticker.Stop()
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		flushBatch(batch)
+		batch = make([]record.Record, 0, dbConfig.QueueBatchSize)
+	}
+
+	for {
+		select {
+		case r, ok := <-insertQueue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, r)
+			if len(batch) >= dbConfig.QueueBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// flushBatch writes a batch of queued records and logs the records that failed.
+func flushBatch(batch []record.Record) {
+	results, _ := insertBatchToDB(batch)
+
+	failed := 0
+	for _, result := range results {
+		if result.Status != http.StatusCreated {
+			failed++
+			log.Warn().Msgf("Dropped queued record for endpoint '%v': %s", batch[result.Index].Endpoint, result.Message)
+		}
+	}
+	if failed > 0 {
+		log.Error().Msgf("Failed to insert %d of %d queued records", failed, len(batch))
+	}
+}
+
+// EnqueueInsertion adds a record to the ingestion queue without waiting for it to be written.
+func EnqueueInsertion(r record.Record) error {
+	queueMu.RLock()
+	defer queueMu.RUnlock()
+
+	if queueClosed || insertQueue == nil {
+		metrics.QueueRejections.Inc("closed")
+		return ErrQueueClosed
+	}
+	// The record is timed when it is received rather than when the queue writes it
+	setReceiveTime(&r)
+
+	select {
+	case insertQueue <- r:
+		return nil
+	default:
+		metrics.QueueRejections.Inc("full")
+		return ErrQueueFull
+	}
+}
+
+// EnqueueBatch adds records to the ingestion queue without waiting for them to be written. Either
+// every record is queued or, when the queue does not have room for all of them, none is.
+func EnqueueBatch(records []record.Record) error {
+	// Holding the write lock keeps other enqueues out, so the room left cannot shrink meanwhile
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	if queueClosed || insertQueue == nil {
+		metrics.QueueRejections.Inc("closed")
+		return ErrQueueClosed
+	}
+	if cap(insertQueue)-len(insertQueue) < len(records) {
+		metrics.QueueRejections.Inc("full")
+		return ErrQueueFull
+	}
+
+	for _, r := range records {
+		setReceiveTime(&r)
+		insertQueue <- r
+	}
+	return nil
+}
+
+// QueueLength returns the number of records waiting in the ingestion queue.
+func QueueLength() int {
+	queueMu.RLock()
+	defer queueMu.RUnlock()
+	return len(insertQueue)
+}
+
+// DrainQueue stops accepting new records and waits for the pending records to be written.
+func DrainQueue(ctx context.Context) error {
+	queueMu.Lock()
+	if queueClosed || insertQueue == nil {
+		queueMu.Unlock()
+		return nil
+	}
+	queueClosed = true
+	pending := len(insertQueue)
+	close(insertQueue)
+	queueMu.Unlock()
+
+	log.Info().Msgf("Draining %d pending records from the ingestion queue", pending)
+
+	done := make(chan struct{})
+	go func() {
+		queueWorkers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("Ingestion queue was not drained, %d records were not written: %w", QueueLength(), ctx.Err())
+	}
+}
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"ingester/record"
+)
+
+// withQueue replaces the ingestion queue with an open queue of the given size for a test.
+func withQueue(t *testing.T, size int) {
+	t.Helper()
+	previousQueue, previousClosed := insertQueue, queueClosed
+	insertQueue, queueClosed = make(chan record.Record, size), false
+	t.Cleanup(func() { insertQueue, queueClosed = previousQueue, previousClosed })
+}
+
+func TestEnqueueBatch(t *testing.T) {
+	withQueue(t, 3)
+
+	if err := EnqueueBatch(make([]record.Record, 2)); err != nil {
+		t.Fatalf("EnqueueBatch() error = %v", err)
+	}
+	if QueueLength() != 2 {
+		t.Fatalf("QueueLength() = %d, want 2", QueueLength())
+	}
+	for len(insertQueue) > 0 {
+		if r := <-insertQueue; r.Time == nil {
+			t.Error("queued record has no receive time")
+		}
+	}
+}
+
+func TestEnqueueBatchAllOrNothing(t *testing.T) {
+	withQueue(t, 3)
+
+	if err := EnqueueInsertion(record.Record{}); err != nil {
+		t.Fatalf("EnqueueInsertion() error = %v", err)
+	}
+	if err := EnqueueBatch(make([]record.Record, 3)); err != ErrQueueFull {
+		t.Fatalf("EnqueueBatch() error = %v, want ErrQueueFull", err)
+	}
+	if QueueLength() != 1 {
+		t.Errorf("QueueLength() = %d, want 1 since no record of the rejected batch is queued", QueueLength())
+	}
+}
+
+func TestEnqueueBatchClosed(t *testing.T) {
+	withQueue(t, 3)
+	queueClosed = true
+
+	if err := EnqueueBatch(make([]record.Record, 1)); err != ErrQueueClosed {
+		t.Fatalf("EnqueueBatch() error = %v, want ErrQueueClosed", err)
+	}
+}
+
+func TestQueueLengthConcurrent(t *testing.T) {
+	withQueue(t, 0)
+
+	// Run with -race: the length is read while the queue is created, filled and closed
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		startInsertWorkers(100, 0)
+	}()
+	QueueLength()
+	wg.Wait()
+
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			EnqueueBatch(make([]record.Record, 5))
+		}()
+		go func() {
+			defer wg.Done()
+			QueueLength()
+		}()
+	}
+	if err := DrainQueue(context.Background()); err != nil {
+		t.Fatalf("DrainQueue() error = %v", err)
+	}
+	wg.Wait()
+	if err := EnqueueInsertion(record.Record{}); err != ErrQueueClosed {
+		t.Errorf("EnqueueInsertion() error = %v, want ErrQueueClosed after draining", err)
+	}
+}
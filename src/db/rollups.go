@@ -0,0 +1,119 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// rollupMinRange is the shortest time range that is aggregated from the rollups instead of the raw data.
+const rollupMinRange = 7 * 24 * time.Hour
+
+// rollup describes a continuous aggregate over the data table.
+type rollup struct {
+	suffix        string        // suffix is appended to the data table name to name the view.
+	width         time.Duration // width is the size of the time buckets of the view.
+	bucket        string        // bucket is the bucket width as a PostgreSQL interval.
+	startOffset   string        // startOffset is the start of the window refreshed by the policy.
+	endOffset     string        // endOffset is the end of the window refreshed by the policy.
+	scheduleEvery string        // scheduleEvery is how often the policy refreshes the view.
+}
+
+// rollups are the continuous aggregates maintained over the data table, widest first.
+var rollups = []rollup{
+	{suffix: "_daily", width: 24 * time.Hour, bucket: "1 day", startOffset: "7 days", endOffset: "1 day", scheduleEvery: "1 hour"},
+	{suffix: "_hourly", width: time.Hour, bucket: "1 hour", startOffset: "3 days", endOffset: "1 hour", scheduleEvery: "30 minutes"},
+}
+
+// getCreateRollupSQL returns the SQL query to create a continuous aggregate over the data table.
+// Real-time aggregation is enabled so the most recent, not yet materialized, data is included.
+func getCreateRollupSQL(r rollup) string {
+	return fmt.Sprintf(`
+	CREATE MATERIALIZED VIEW IF NOT EXISTS %s%s
+	WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+	SELECT
+		time_bucket(INTERVAL '%s', time) AS bucket,
+		orgId,
+		projectId,
+		name,
+		environment,
+		applicationName,
+		endpoint,
+		model,
+		SUM(usageCost) AS usageCost,
+		SUM(promptTokens) AS promptTokens,
+		SUM(completionTokens) AS completionTokens,
+		SUM(totalTokens) AS totalTokens,
+		COUNT(*) AS requests
+	FROM %s
+	GROUP BY bucket, orgId, projectId, name, environment, applicationName, endpoint, model
+	WITH NO DATA;`, dbConfig.DataTableName, r.suffix, r.bucket, dbConfig.DataTableName)
+}
+
+// createRollups creates the continuous aggregates over the data table and their refresh policies.
+// A newly created aggregate is materialized over all the existing data, since the refresh policy
+// only covers the most recent data.
+func createRollups(db *sql.DB) error {
+	for _, r := range rollups {
+		viewName := dbConfig.DataTableName + r.suffix
+
+		var exists bool
+		err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM timescaledb_information.continuous_aggregates
+			WHERE lower(view_name) = lower($1))`, viewName).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("Error checking continuous aggregate '%s': %w", viewName, err)
+		}
+
+		if !exists {
+			if _, err := db.Exec(getCreateRollupSQL(r)); err != nil {
+				return fmt.Errorf("Error creating continuous aggregate '%s': %w", viewName, err)
+			}
+			log.Info().Msgf("Materializing continuous aggregate '%s' over the existing data", viewName)
+			// The refresh cannot run in a transaction, so it is sent without parameters as a simple query
+			refreshSQL := fmt.Sprintf("CALL refresh_continuous_aggregate('%s', NULL, NOW() - INTERVAL '%s')", viewName, r.endOffset)
+			_, err := db.Exec(refreshSQL)
+			if err != nil {
+				return fmt.Errorf("Error materializing continuous aggregate '%s': %w", viewName, err)
+			}
+		}
+
+		_, err = db.Exec(`SELECT add_continuous_aggregate_policy($1::regclass,
+			start_offset => $2::interval, end_offset => $3::interval,
+			schedule_interval => $4::interval, if_not_exists => true)`,
+			viewName, r.startOffset, r.endOffset, r.scheduleEvery)
+		if err != nil {
+			return fmt.Errorf("Error adding refresh policy to continuous aggregate '%s': %w", viewName, err)
+		}
+		log.Info().Msgf("Continuous aggregate '%s' checked/created with a refresh policy", viewName)
+	}
+	return nil
+}
+
+// aggregateSource returns the table and time column that an aggregate over the filter should read.
+// Long time ranges with buckets aligned to a rollup read the rollup instead of the raw data.
+func aggregateSource(filter DataFilter, bucket time.Duration) (table, timeColumn string, fromRollup bool) {
+	if filter.To.Sub(filter.From) >= rollupMinRange {
+		for _, r := range rollups {
+			if bucket%r.width == 0 {
+				return dbConfig.DataTableName + r.suffix, "bucket", true
+			}
+		}
+	}
+	return dbConfig.DataTableName, "time", false
+}
+
+// refreshRollups materializes the continuous aggregates again over a time range whose records changed.
+func refreshRollups(from, to time.Time) error {
+	for _, r := range rollups {
+		viewName := dbConfig.DataTableName + r.suffix
+		// The refresh cannot run in a transaction, so it is sent without parameters as a simple query
+		refreshSQL := fmt.Sprintf("CALL refresh_continuous_aggregate('%s', '%s', '%s')",
+			viewName, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339))
+		if _, err := db.Exec(refreshSQL); err != nil {
+			return fmt.Errorf("Error refreshing continuous aggregate '%s': %w", viewName, err)
+		}
+	}
+	return nil
+}
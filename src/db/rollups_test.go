@@ -0,0 +1,65 @@
+package db
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateRollupSQLSelectsGroupedColumns(t *testing.T) {
+	dbConfig.DataTableName = "DOKU"
+	groupBy := regexp.MustCompile(`GROUP BY ([^\n]+)`)
+
+	for _, r := range rollups {
+		query := getCreateRollupSQL(r)
+		match := groupBy.FindStringSubmatch(query)
+		if match == nil {
+			t.Fatalf("rollup %s has no GROUP BY:\n%s", r.suffix, query)
+		}
+		selectList := query[strings.Index(query, "SELECT"):strings.Index(query, "FROM")]
+		for _, column := range strings.Split(match[1], ",") {
+			column = strings.TrimSpace(column)
+			if column == "bucket" {
+				continue
+			}
+			if !regexp.MustCompile(`(?m)^\s*` + column + `,?$`).MatchString(selectList) {
+				t.Errorf("rollup %s groups by %s without selecting it", r.suffix, column)
+			}
+		}
+		// Every filter of the query API must be available on the rollups
+		for _, column := range append([]string{"orgId", "projectId"}, GroupByColumns...) {
+			if !strings.Contains(selectList, column) {
+				t.Errorf("rollup %s does not select %s", r.suffix, column)
+			}
+		}
+	}
+}
+
+func TestAggregateSource(t *testing.T) {
+	dbConfig.DataTableName = "DOKU"
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		to         time.Time
+		bucket     time.Duration
+		wantTable  string
+		wantRollup bool
+	}{
+		{"short range", from.Add(24 * time.Hour), 24 * time.Hour, "DOKU", false},
+		{"daily buckets", from.Add(30 * 24 * time.Hour), 24 * time.Hour, "DOKU_daily", true},
+		{"hourly buckets", from.Add(7 * 24 * time.Hour), 6 * time.Hour, "DOKU_hourly", true},
+		{"unaligned buckets", from.Add(30 * 24 * time.Hour), 90 * time.Minute, "DOKU", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			table, timeColumn, fromRollup := aggregateSource(DataFilter{From: from, To: test.to}, test.bucket)
+			if table != test.wantTable || fromRollup != test.wantRollup {
+				t.Errorf("aggregateSource() = %s, %v, want %s, %v", table, fromRollup, test.wantTable, test.wantRollup)
+			}
+			if wantColumn := map[bool]string{true: "bucket", false: "time"}[test.wantRollup]; timeColumn != wantColumn {
+				t.Errorf("time column = %s, want %s", timeColumn, wantColumn)
+			}
+		})
+	}
+}
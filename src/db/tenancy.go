@@ -0,0 +1,152 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// RootOrgID is the organization that owns the data and keys created before tenancy existed.
+// Its admin keys can manage the other organizations.
+const RootOrgID = 1
+
+// defaultProjectName is the name of the project created along with every organization.
+const defaultProjectName = "default"
+
+// Organization is a tenant of the ingester. Keys and data of an organization are not visible to the others.
+type Organization struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Project groups the keys and data of an organization.
+type Project struct {
+	ID        int       `json:"id"`
+	OrgID     int       `json:"orgId"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// CreateOrganization creates an organization along with its default project and an admin API key with
+// the given name in a single transaction, so an organization never exists without a key to manage it.
+// The admin key is only returned here.
+func CreateOrganization(name, adminKeyName string) (Organization, Project, string, error) {
+	var org Organization
+	var project Project
+
+	apiKey, err := generateSecureRandomKey()
+	if err != nil {
+		return org, project, "", err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return org, project, "", err
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf("INSERT INTO %s (name) VALUES ($1) RETURNING id, name, created_at", dbConfig.OrgTableName)
+	if err := tx.QueryRow(query, name).Scan(&org.ID, &org.Name, &org.CreatedAt); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return org, project, "", fmt.Errorf("ORGEXISTS")
+		}
+		log.Error().Err(err).Msgf("Error creating the organization '%s'", name)
+		return org, project, "", err
+	}
+
+	query = fmt.Sprintf("INSERT INTO %s (org_id, name) VALUES ($1, $2) RETURNING id, org_id, name, created_at", dbConfig.ProjectTableName)
+	if err := tx.QueryRow(query, org.ID, defaultProjectName).Scan(&project.ID, &project.OrgID, &project.Name, &project.CreatedAt); err != nil {
+		log.Error().Err(err).Msgf("Error creating the default project of the organization '%s'", name)
+		return org, project, "", err
+	}
+
+	adminKey := newStoredAPIKey(apiKey, APIKeyInfo{OrgID: org.ID, ProjectID: project.ID, Name: adminKeyName, Scopes: ValidScopes})
+	if err := insertAPIKey(tx, adminKey); err != nil {
+		log.Error().Err(err).Msgf("Error creating the admin API key of the organization '%s'", name)
+		return org, project, "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return org, project, "", err
+	}
+	log.Info().Msgf("Organization '%s' created successfully", name)
+	return org, project, apiKey, nil
+}
+
+// ListOrganizations retrieves every organization from the database, ordered by ID.
+func ListOrganizations() ([]Organization, error) {
+	query := fmt.Sprintf("SELECT id, name, created_at FROM %s ORDER BY id", dbConfig.OrgTableName)
+	rows, err := db.Query(query)
+	if err != nil {
+		log.Error().Err(err).Msg("Error listing organizations")
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []Organization{}
+	for rows.Next() {
+		var org Organization
+		if err := rows.Scan(&org.ID, &org.Name, &org.CreatedAt); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, rows.Err()
+}
+
+// CreateProject creates a project in the organization.
+func CreateProject(orgID int, name string) (Project, error) {
+	var project Project
+	query := fmt.Sprintf("INSERT INTO %s (org_id, name) VALUES ($1, $2) RETURNING id, org_id, name, created_at", dbConfig.ProjectTableName)
+	err := db.QueryRow(query, orgID, name).Scan(&project.ID, &project.OrgID, &project.Name, &project.CreatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return project, fmt.Errorf("PROJECTEXISTS")
+		}
+		log.Error().Err(err).Msgf("Error creating the project '%s'", name)
+		return project, err
+	}
+	log.Info().Msgf("Project '%s' created successfully", name)
+	return project, nil
+}
+
+// ListProjects retrieves the projects of the organization from the database, ordered by ID.
+func ListProjects(orgID int) ([]Project, error) {
+	query := fmt.Sprintf("SELECT id, org_id, name, created_at FROM %s WHERE org_id = $1 ORDER BY id", dbConfig.ProjectTableName)
+	rows, err := db.Query(query, orgID)
+	if err != nil {
+		log.Error().Err(err).Msg("Error listing projects")
+		return nil, err
+	}
+	defer rows.Close()
+
+	projects := []Project{}
+	for rows.Next() {
+		var project Project
+		if err := rows.Scan(&project.ID, &project.OrgID, &project.Name, &project.CreatedAt); err != nil {
+			return nil, err
+		}
+		projects = append(projects, project)
+	}
+	return projects, rows.Err()
+}
+
+// ResolveProject returns the ID of the project of the organization with the given name, or of
+// the default project of the organization when the name is empty.
+func ResolveProject(orgID int, name string) (int, error) {
+	if name == "" {
+		name = defaultProjectName
+	}
+
+	var projectID int
+	query := fmt.Sprintf("SELECT id FROM %s WHERE org_id = $1 AND name = $2", dbConfig.ProjectTableName)
+	err := db.QueryRow(query, orgID, name).Scan(&projectID)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("PROJECTNOTFOUND")
+	}
+	return projectID, err
+}
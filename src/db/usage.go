@@ -0,0 +1,43 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"ingester/limits"
+
+	"github.com/rs/zerolog/log"
+)
+
+// seedQuotaUsage loads the usage of the current quota periods from the daily rollup, so quotas
+// carry over when the ingester restarts.
+func seedQuotaUsage(db *sql.DB) error {
+	for _, period := range limits.Periods() {
+		start := limits.PeriodStart(period, time.Now())
+		query := fmt.Sprintf(`SELECT orgId, name, applicationName, COALESCE(SUM(totalTokens), 0), COALESCE(SUM(usageCost), 0)
+			FROM %s_daily WHERE bucket >= $1 GROUP BY orgId, name, applicationName`, dbConfig.DataTableName)
+		rows, err := db.Query(query, start)
+		if err != nil {
+			return fmt.Errorf("Error reading the %s usage: %w", period, err)
+		}
+
+		for rows.Next() {
+			var orgID int
+			var name, application string
+			var tokens int64
+			var cost float64
+			if err := rows.Scan(&orgID, &name, &application, &tokens, &cost); err != nil {
+				rows.Close()
+				return err
+			}
+			limits.SeedUsage(period, orgID, name, application, tokens, cost)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		log.Info().Msgf("Loaded the %s quota usage since %s", period, start.Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package limits
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ingester/config"
+	"ingester/record"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Quota scopes and periods that can be configured.
+const (
+	ScopeKey         = "key"
+	ScopeApplication = "application"
+	PeriodDaily      = "daily"
+	PeriodMonthly    = "monthly"
+)
+
+var (
+	rateLimit float64                        // rateLimit is the number of requests per second allowed for each API key, 0 disables rate limiting.
+	rateBurst float64                        // rateBurst is the number of requests an API key can make at once.
+	buckets   sync.Map                       // buckets holds the token bucket of each API key.
+	quotas    []quota                        // quotas holds the configured quotas.
+	usageMu   sync.Mutex                     // usageMu guards usage.
+	usage     = map[usageKey]*usageCounter{} // usage holds the usage of each quota subject in the current period.
+)
+
+// usageKey identifies the usage of a quota by a subject, which is an API key or an application.
+type usageKey struct {
+	quota   int
+	subject string
+}
+
+// quota is a limit on the tokens or cost ingested per API key or application over a period.
+type quota struct {
+	scope     string
+	period    string
+	maxTokens int64
+	maxCost   float64
+}
+
+// usageCounter holds the usage of a quota subject since the start of the period.
+type usageCounter struct {
+	periodStart time.Time
+	tokens      int64
+	cost        float64
+}
+
+// tokenBucket is the rate limiter state of an API key.
+type tokenBucket struct {
+	mu       sync.Mutex
+	tokens   float64
+	lastSeen time.Time
+}
+
+// Usage reports the usage of a quota by the API key or an application of its organization.
+type Usage struct {
+	Scope     string    `json:"scope"`
+	Subject   string    `json:"subject"`
+	Period    string    `json:"period"`
+	Tokens    int64     `json:"tokens"`
+	Cost      float64   `json:"cost"`
+	MaxTokens int64     `json:"maxTokens,omitempty"`
+	MaxCost   float64   `json:"maxCost,omitempty"`
+	ResetsAt  time.Time `json:"resetsAt"`
+}
+
+// Init validates and loads the rate limit and quota configuration. The requests and usage counted
+// under a previous configuration are cleared.
+func Init(cfg config.Configuration) error {
+	buckets.Range(func(key, _ any) bool {
+		buckets.Delete(key)
+		return true
+	})
+	usageMu.Lock()
+	usage = map[usageKey]*usageCounter{}
+	usageMu.Unlock()
+
+	rateLimit = cfg.Limits.RateLimit.RequestsPerSecond
+	rateBurst = float64(cfg.Limits.RateLimit.Burst)
+	if rateLimit < 0 {
+		return fmt.Errorf("'limits.rateLimit.requestsPerSecond' must not be negative")
+	}
+	if rateBurst < 1 {
+		rateBurst = math.Max(1, math.Ceil(rateLimit))
+	}
+
+	quotas = nil
+	for i, q := range cfg.Limits.Quotas {
+		if q.Scope != ScopeKey && q.Scope != ScopeApplication {
+			return fmt.Errorf("'limits.quotas[%d].scope' must be '%s' or '%s'", i, ScopeKey, ScopeApplication)
+		}
+		if q.Period != PeriodDaily && q.Period != PeriodMonthly {
+			return fmt.Errorf("'limits.quotas[%d].period' must be '%s' or '%s'", i, PeriodDaily, PeriodMonthly)
+		}
+		if q.MaxTokens <= 0 && q.MaxCost <= 0 {
+			return fmt.Errorf("'limits.quotas[%d]' must set 'maxTokens' or 'maxCost'", i)
+		}
+		quotas = append(quotas, quota{scope: q.Scope, period: q.Period, maxTokens: q.MaxTokens, maxCost: q.MaxCost})
+	}
+
+	if rateLimit > 0 {
+		log.Info().Msgf("Rate limiting API keys to %v requests per second with a burst of %v", rateLimit, rateBurst)
+	}
+	if len(quotas) > 0 {
+		log.Info().Msgf("Enforcing %d ingestion quotas", len(quotas))
+	}
+	return nil
+}
+
+// Allow takes a request from the token bucket of the API key. When the bucket is empty it
+// returns false and how long to wait before the next request is allowed.
+func Allow(orgID int, keyName string) (bool, time.Duration) {
+	if rateLimit <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+	val, _ := buckets.LoadOrStore(keySubject(orgID, keyName), &tokenBucket{tokens: rateBurst, lastSeen: now})
+	bucket := val.(*tokenBucket)
+
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	bucket.tokens = math.Min(rateBurst, bucket.tokens+now.Sub(bucket.lastSeen).Seconds()*rateLimit)
+	bucket.lastSeen = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - bucket.tokens) / rateLimit * float64(time.Second))
+	return false, wait
+}
+
+// keySubject identifies an API key across organizations.
+func keySubject(orgID int, keyName string) string {
+	return fmt.Sprintf("%d/%s", orgID, keyName)
+}
+
+// subject returns the quota subject of a record for the scope of a quota.
+func (q quota) subject(orgID int, keyName, application string) string {
+	if q.scope == ScopeKey {
+		return keySubject(orgID, keyName)
+	}
+	return fmt.Sprintf("%d/%s", orgID, application)
+}
+
+// PeriodStart returns the start of the period containing the given time, in UTC.
+func PeriodStart(period string, t time.Time) time.Time {
+	t = t.UTC()
+	if period == PeriodMonthly {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// periodEnd returns the end of the period starting at the given time.
+func periodEnd(period string, start time.Time) time.Time {
+	if period == PeriodMonthly {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// Periods returns the periods of the configured quotas.
+func Periods() []string {
+	var periods []string
+	seen := map[string]bool{}
+	for _, q := range quotas {
+		if !seen[q.period] {
+			seen[q.period] = true
+			periods = append(periods, q.period)
+		}
+	}
+	return periods
+}
+
+// counter returns the usage counter of a quota subject for the current period, resetting it when
+// a new period started. The caller must hold usageMu.
+func counter(index int, subject string, now time.Time) *usageCounter {
+	key := usageKey{quota: index, subject: subject}
+	start := PeriodStart(quotas[index].period, now)
+
+	c, ok := usage[key]
+	if !ok || c.periodStart.Before(start) {
+		c = &usageCounter{periodStart: start}
+		usage[key] = c
+	}
+	return c
+}
+
+// SeedUsage adds usage recorded before the ingester started to the quotas of the period.
+func SeedUsage(period string, orgID int, keyName, application string, tokens int64, cost float64) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	now := time.Now()
+	for i, q := range quotas {
+		if q.period != period {
+			continue
+		}
+		c := counter(i, q.subject(orgID, keyName, application), now)
+		c.tokens += tokens
+		c.cost += cost
+	}
+}
+
+// CheckQuota checks the quotas of the API key and the application. When a quota is exhausted it
+// returns false and how long until the quota resets.
+func CheckQuota(orgID int, keyName, application string) (bool, time.Duration) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	now := time.Now()
+	for i, q := range quotas {
+		c := counter(i, q.subject(orgID, keyName, application), now)
+		if (q.maxTokens > 0 && c.tokens >= q.maxTokens) || (q.maxCost > 0 && c.cost >= q.maxCost) {
+			return false, periodEnd(q.period, c.periodStart).Sub(now)
+		}
+	}
+	return true, 0
+}
+
+// Record adds the tokens and cost of a record written to the database to the quotas.
+func Record(r record.Record) {
+	if len(quotas) == 0 {
+		return
+	}
+
+	var tokens int64
+	if r.TotalTokens != nil {
+		tokens = int64(*r.TotalTokens)
+	} else {
+		if r.PromptTokens != nil {
+			tokens += int64(*r.PromptTokens)
+		}
+		if r.CompletionTokens != nil {
+			tokens += int64(*r.CompletionTokens)
+		}
+	}
+	var cost float64
+	if r.UsageCost != nil {
+		cost = *r.UsageCost
+	}
+
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	now := time.Now()
+	for i, q := range quotas {
+		c := counter(i, q.subject(r.OrgID, r.Name, r.ApplicationName), now)
+		c.tokens += tokens
+		c.cost += cost
+	}
+}
+
+// GetUsage returns the usage of the quotas of the API key and of the applications of its organization.
+func GetUsage(orgID int, keyName string) []Usage {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	now := time.Now()
+	orgPrefix := fmt.Sprintf("%d/", orgID)
+	result := []Usage{}
+	for i, q := range quotas {
+		var subjects []string
+		if q.scope == ScopeKey {
+			subjects = []string{keySubject(orgID, keyName)}
+		} else {
+			for key := range usage {
+				if key.quota == i && strings.HasPrefix(key.subject, orgPrefix) {
+					subjects = append(subjects, key.subject)
+				}
+			}
+			sort.Strings(subjects)
+		}
+
+		for _, subject := range subjects {
+			c := counter(i, subject, now)
+			result = append(result, Usage{
+				Scope:     q.scope,
+				Subject:   strings.TrimPrefix(subject, orgPrefix),
+				Period:    q.period,
+				Tokens:    c.tokens,
+				Cost:      c.cost,
+				MaxTokens: q.maxTokens,
+				MaxCost:   q.maxCost,
+				ResetsAt:  periodEnd(q.period, c.periodStart),
+			})
+		}
+	}
+	return result
+}
@@ -0,0 +1,153 @@
+package limits
+
+import (
+	"testing"
+	"time"
+
+	"ingester/config"
+	"ingester/record"
+)
+
+// configureLimits loads the limits of the configuration for a test, which also clears the requests
+// and usage counted by the previous tests.
+func configureLimits(t *testing.T, cfg config.Configuration) {
+	t.Helper()
+	if err := Init(cfg); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+}
+
+// rateLimitConfig returns a configuration with the given rate limit.
+func rateLimitConfig(requestsPerSecond float64, burst int) config.Configuration {
+	var cfg config.Configuration
+	cfg.Limits.RateLimit.RequestsPerSecond = requestsPerSecond
+	cfg.Limits.RateLimit.Burst = burst
+	return cfg
+}
+
+// dailyKeyQuota adds a daily quota of tokens per API key to the configuration.
+func dailyKeyQuota(cfg config.Configuration, maxTokens int64) config.Configuration {
+	cfg.Limits.Quotas = append(cfg.Limits.Quotas, struct {
+		Scope     string  `yaml:"scope"`
+		Period    string  `yaml:"period"`
+		MaxTokens int64   `yaml:"maxTokens"`
+		MaxCost   float64 `yaml:"maxCost"`
+	}{Scope: ScopeKey, Period: PeriodDaily, MaxTokens: maxTokens})
+	return cfg
+}
+
+func TestAllowBurst(t *testing.T) {
+	configureLimits(t, rateLimitConfig(1, 3))
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := Allow(1, "key"); !ok {
+			t.Fatalf("request %d of the burst was rejected", i+1)
+		}
+	}
+	ok, wait := Allow(1, "key")
+	if ok {
+		t.Fatal("request past the burst was allowed")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("wait = %v, want at most the time to refill one token", wait)
+	}
+}
+
+func TestAllowRefills(t *testing.T) {
+	configureLimits(t, rateLimitConfig(10, 1))
+
+	if ok, _ := Allow(1, "key"); !ok {
+		t.Fatal("first request was rejected")
+	}
+	// Backdating the last request refills the bucket as if the time had passed
+	val, _ := buckets.Load(keySubject(1, "key"))
+	bucket := val.(*tokenBucket)
+	bucket.lastSeen = bucket.lastSeen.Add(-200 * time.Millisecond)
+
+	if ok, _ := Allow(1, "key"); !ok {
+		t.Fatal("request after the refill was rejected")
+	}
+	if bucket.tokens > 1 {
+		t.Errorf("tokens = %v, want the bucket capped at the burst", bucket.tokens)
+	}
+}
+
+func TestAllowSeparatesKeys(t *testing.T) {
+	configureLimits(t, rateLimitConfig(1, 1))
+
+	Allow(1, "key")
+	if ok, _ := Allow(2, "key"); !ok {
+		t.Error("a key of the same name in another organization shares the bucket")
+	}
+	if ok, _ := Allow(1, "other"); !ok {
+		t.Error("another key shares the bucket")
+	}
+}
+
+func TestAllowDisabled(t *testing.T) {
+	configureLimits(t, rateLimitConfig(0, 0))
+
+	for i := 0; i < 100; i++ {
+		if ok, _ := Allow(1, "key"); !ok {
+			t.Fatal("request was rejected without a rate limit")
+		}
+	}
+}
+
+func TestInitDefaultBurst(t *testing.T) {
+	configureLimits(t, rateLimitConfig(2.5, 0))
+
+	if rateBurst != 3 {
+		t.Errorf("rateBurst = %v, want the rate rounded up", rateBurst)
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	configureLimits(t, dailyKeyQuota(config.Configuration{}, 100))
+
+	SeedUsage(PeriodDaily, 1, "key", "app", 60, 0)
+	if ok, _ := CheckQuota(1, "key", "app"); !ok {
+		t.Fatal("quota rejected a key under its limit")
+	}
+
+	tokens := 40
+	Record(record.Record{OrgID: 1, Name: "key", ApplicationName: "app", TotalTokens: &tokens})
+	ok, wait := CheckQuota(1, "key", "app")
+	if ok {
+		t.Fatal("quota allowed a key at its limit")
+	}
+	if wait <= 0 || wait > 24*time.Hour {
+		t.Errorf("wait = %v, want the time until the end of the day", wait)
+	}
+	if ok, _ := CheckQuota(2, "key", "app"); !ok {
+		t.Error("quota counted the usage of a key of another organization")
+	}
+}
+
+func TestInitClearsCountedUsage(t *testing.T) {
+	cfg := dailyKeyQuota(rateLimitConfig(1, 1), 100)
+	configureLimits(t, cfg)
+	Allow(1, "key")
+	SeedUsage(PeriodDaily, 1, "key", "app", 100, 0)
+	if ok, _ := CheckQuota(1, "key", "app"); ok {
+		t.Fatal("quota allowed a key at its limit")
+	}
+
+	configureLimits(t, cfg)
+	if ok, _ := Allow(1, "key"); !ok {
+		t.Error("the request counted under the previous configuration was kept")
+	}
+	if ok, _ := CheckQuota(1, "key", "app"); !ok {
+		t.Error("the usage counted under the previous configuration was kept")
+	}
+}
+
+func TestPeriodStart(t *testing.T) {
+	at := time.Date(2024, 3, 15, 13, 30, 0, 0, time.FixedZone("CET", 3600))
+	if got := PeriodStart(PeriodDaily, at); !got.Equal(time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("daily PeriodStart() = %v", got)
+	}
+	if got := PeriodStart(PeriodMonthly, at); !got.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("monthly PeriodStart() = %v", got)
+	}
+}
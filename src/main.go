@@ -9,11 +9,14 @@ import (
 	"syscall"
 	"time"
 
+	"ingester/alerts"
 	"ingester/api"
 	"ingester/auth"
 	"ingester/config"
 	"ingester/cost"
 	"ingester/db"
+	"ingester/limits"
+	"ingester/metrics"
 	"ingester/obsPlatform"
 
 	"github.com/common-nighthawk/go-figure"
@@ -22,7 +25,7 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-func waitForShutdown(server *http.Server) {
+func waitForShutdown(server *http.Server, metricsServer *http.Server) {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 
@@ -41,6 +44,33 @@ func waitForShutdown(server *http.Server) {
 	} else {
 		log.Info().Msg("Server gracefully shutdown")
 	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(ctx); err != nil {
+			log.Error().Err(err).Msg("Metrics server shutdown failed")
+		}
+	}
+
+	// Write the records still waiting in the ingestion queue before exiting
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer drainCancel()
+
+	if err := db.DrainQueue(drainCtx); err != nil {
+		log.Error().Err(err).Msg("Ingestion queue drain failed")
+	} else {
+		log.Info().Msg("Ingestion queue drained")
+	}
+}
+
+// reloadPricingOnSignal reloads the pricing information whenever the process receives SIGHUP.
+func reloadPricingOnSignal() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			cost.ReloadAndLog("SIGHUP")
+		}
+	}()
 }
 
 // main is the entrypoint for the Doku Ingester service. It sets up logging,
@@ -50,6 +80,19 @@ func main() {
 	figure.NewColorFigure("DOKU Ingester", "", "yellow", true).Print()
 	// Configure global settings for the zerolog logger
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+
+	// The `migrate` subcommand manages the database schema and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
+	// The `reprice` subcommand recomputes the usage cost of stored records and exits
+	if len(os.Args) > 1 && os.Args[1] == "reprice" {
+		runReprice(os.Args[2:])
+		return
+	}
+
 	log.Info().Msg("Starting Doku Ingester")
 
 	// Use flag package to parse the configuration file
@@ -63,18 +106,31 @@ func main() {
 	}
 
 	// Load pricing information, either from a local file or from a URL
-	if cfg.PricingInfo.URL != "" {
-		log.Info().Msgf("Initializing LLM Pricing Information from URL '%s'", cfg.PricingInfo.URL)
-		err = cost.LoadPricing("", cfg.PricingInfo.URL)
-	} else if cfg.PricingInfo.LocalFile.Path != "" {
-		log.Info().Msgf("Initializing LLM Pricing Information from local file '%s", cfg.PricingInfo.LocalFile.Path)
-		err = cost.LoadPricing(cfg.PricingInfo.LocalFile.Path, "")
-	}
-	if err != nil {
+	if err := cost.LoadConfiguredPricing(*cfg); err != nil {
 		log.Fatal().Err(err).Msg("Failed to load LLM pricing information")
 	}
 	log.Info().Msg("Successfully initialized LLM pricing information")
 
+	// Reload the pricing information on SIGHUP, through the admin endpoint and on the refresh interval if set
+	reloadPricingOnSignal()
+	if cfg.PricingInfo.RefreshInterval != "" {
+		interval, _ := time.ParseDuration(cfg.PricingInfo.RefreshInterval)
+		cost.StartRefresh(interval)
+		log.Info().Msgf("Refreshing LLM pricing information every %s", interval)
+	}
+
+	// Load the deployment aliases the pricing of Azure OpenAI requests is looked up with
+	err = cost.Init(*cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid deployment configuration")
+	}
+
+	// Load the rate limits and quotas, the quota usage is loaded from the database during its initialization
+	err = limits.Init(*cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid rate limit or quota configuration")
+	}
+
 	// Initialize the backend database connection with loaded configuration
 	log.Info().Msg("Initializing connection to the backend database")
 	err = db.Init(*cfg)
@@ -83,12 +139,21 @@ func main() {
 	}
 	log.Info().Msg("Successfully initialized connection to the backend database")
 
+	// Start evaluating the budgets if any is configured
+	budgetsConfigured, err := alerts.Init(*cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid budget alert configuration")
+	}
+	if budgetsConfigured {
+		alerts.Start()
+	}
+
 	// Initialize observability platform if configured
 	if cfg.ObservabilityPlatform.Enabled == true {
 		log.Info().Msg("Initializing for your Observability Platform")
 		err := obsPlatform.Init(*cfg)
 		if err != nil {
-			log.Fatal().Msg("Exiting due to error in initializing for your Observability Platform")
+			log.Fatal().Err(err).Msg("Exiting due to error in initializing for your Observability Platform")
 		}
 		log.Info().Msgf("Setup complete for sending data to %s", obsPlatform.ObservabilityPlatform)
 	}
@@ -99,9 +164,30 @@ func main() {
 	// Initialize the HTTP server routing
 	r := mux.NewRouter()
 	r.HandleFunc("/api/push", api.DataHandler).Methods("POST")
+	r.HandleFunc("/api/push/batch", api.BatchDataHandler).Methods("POST")
+	r.HandleFunc("/api/data", api.DataQueryHandler).Methods("GET")
+	r.HandleFunc("/api/data/aggregate", api.DataAggregateHandler).Methods("GET")
+	r.HandleFunc("/v1/traces", api.OTLPTracesHandler).Methods("POST")
 	r.HandleFunc("/api/keys", api.APIKeyHandler).Methods("GET", "POST", "DELETE")
+	r.HandleFunc("/api/keys/rotate", api.RotateAPIKeyHandler).Methods("POST")
+	r.HandleFunc("/api/keys/stale", api.StaleAPIKeysHandler).Methods("GET")
+	r.HandleFunc("/api/usage", api.UsageHandler).Methods("GET")
+	r.HandleFunc("/api/orgs", api.OrganizationHandler).Methods("GET", "POST")
+	r.HandleFunc("/api/projects", api.ProjectHandler).Methods("GET", "POST")
+	r.HandleFunc("/api/pricing/reload", api.PricingReloadHandler).Methods("POST")
+	r.HandleFunc("/openapi.json", api.OpenAPIHandler).Methods("GET")
 	r.HandleFunc("/", api.BaseEndpoint).Methods("GET")
 
+	// The metrics are served without authentication only on their own listen address, which is
+	// meant to be reachable by the Prometheus server alone
+	metrics.SetSeriesLimit(cfg.Metrics.MaxSeries)
+	var metricsServer *http.Server
+	if cfg.Metrics.ListenAddress == "" {
+		r.HandleFunc("/metrics", api.MetricsHandler).Methods("GET")
+	} else {
+		metricsServer = startMetricsServer(cfg.Metrics.ListenAddress)
+	}
+
 	// Define and start the HTTP server
 	server := &http.Server{
 		Addr:         ":" + cfg.IngesterPort,
@@ -119,5 +205,26 @@ func main() {
 		}
 	}()
 
-	waitForShutdown(server)
+	waitForShutdown(server, metricsServer)
+}
+
+// startMetricsServer serves the metrics on `/metrics` of a separate listen address.
+func startMetricsServer(address string) *http.Server {
+	r := mux.NewRouter()
+	r.HandleFunc("/metrics", metrics.Handler).Methods("GET")
+	server := &http.Server{
+		Addr:         address,
+		Handler:      r,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  30 * time.Second,
+	}
+
+	go func() {
+		log.Info().Msg("Metrics server listening on " + address)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal().Err(err).Msg("Could not listen on " + address)
+		}
+	}()
+	return server
 }
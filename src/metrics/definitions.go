@@ -0,0 +1,37 @@
+package metrics
+
+var (
+	// durationBuckets are the histogram buckets, in seconds, for LLM request durations.
+	durationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 40, 80}
+	// tokenBuckets are the histogram buckets for the number of tokens of a single LLM request.
+	tokenBuckets = []float64{16, 64, 256, 1024, 4096, 16384, 65536, 262144}
+	// insertBuckets are the histogram buckets, in seconds, for database writes.
+	insertBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+)
+
+// LLM usage metrics, labelled by environment, applicationName, endpoint and model.
+var (
+	LLMRequests        = NewCounterVec("doku_llm_requests_total", "Number of LLM requests ingested.", "environment", "applicationName", "endpoint", "model")
+	LLMTokens          = NewCounterVec("doku_llm_tokens_total", "Number of tokens used by the ingested LLM requests.", "environment", "applicationName", "endpoint", "model", "type")
+	LLMUsageCost       = NewCounterVec("doku_llm_usage_cost_total", "Cost in USD of the ingested LLM requests.", "environment", "applicationName", "endpoint", "model")
+	LLMRequestDuration = NewHistogramVec("doku_llm_request_duration_seconds", "Duration of the ingested LLM requests.", durationBuckets, "environment", "applicationName", "endpoint", "model")
+	LLMRequestTokens   = NewHistogramVec("doku_llm_request_tokens", "Number of tokens used per ingested LLM request.", tokenBuckets, "environment", "applicationName", "endpoint", "model", "type")
+)
+
+// Ingester health metrics.
+var (
+	InsertDuration  = NewHistogramVec("doku_ingester_insert_duration_seconds", "Time taken to write records to the database.", insertBuckets, "mode")
+	Records         = NewCounterVec("doku_ingester_records_total", "Number of records processed, by outcome.", "status")
+	QueueRejections = NewCounterVec("doku_ingester_queue_rejections_total", "Number of records rejected because the ingestion queue was full or closed.", "reason")
+	AuthCacheHits   = NewCounterVec("doku_ingester_auth_cache_hits_total", "Number of API key lookups served from the cache.")
+	AuthCacheMisses = NewCounterVec("doku_ingester_auth_cache_misses_total", "Number of API key lookups that needed the database.")
+	ExportFailures  = NewCounterVec("doku_ingester_export_failures_total", "Number of records that failed to export to an observability platform.", "exporter")
+	AlertsSent      = NewCounterVec("doku_ingester_alerts_sent_total", "Number of budget alert notifications sent, by webhook format and outcome.", "format", "status")
+	PricingReloads  = NewCounterVec("doku_ingester_pricing_reloads_total", "Number of pricing reloads, by outcome: updated, unchanged or failed.", "status")
+)
+
+func init() {
+	// Expose the label-less counters from the first scrape
+	AuthCacheHits.Add(0)
+	AuthCacheMisses.Add(0)
+}
@@ -0,0 +1,275 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// overflowLabelValue replaces every label value of the observations made once a family already
+// has the maximum number of series.
+const overflowLabelValue = "other"
+
+// collector is a metric family that can be written in the Prometheus text format.
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+var (
+	registryMu  sync.Mutex  // registryMu guards the registry.
+	registry    []collector // registry holds the metric families exposed on `/metrics`.
+	seriesLimit int         // seriesLimit is the maximum number of series of a family, 0 means no limit.
+)
+
+// SetSeriesLimit limits the number of series of every metric family. Label values come from the
+// pushed records, so observations that would create a series past the limit are counted in a
+// series whose label values are all 'other'.
+func SetSeriesLimit(limit int) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	seriesLimit = limit
+}
+
+// seriesLabels returns the label values an observation is recorded with: its own, or the overflow
+// label values when it would create a series past the limit of a family with the given number of
+// series. The warning of a family is logged the first time it overflows.
+func seriesLabels(name string, labelValues []string, exists bool, series int, warned *bool) []string {
+	registryMu.Lock()
+	limit := seriesLimit
+	registryMu.Unlock()
+	if exists || limit <= 0 || series < limit {
+		return labelValues
+	}
+
+	if !*warned {
+		*warned = true
+		log.Warn().Msgf("Metric '%s' reached %d series, new label values are counted as '%s'", name, limit, overflowLabelValue)
+	}
+	overflow := make([]string, len(labelValues))
+	for i := range overflow {
+		overflow[i] = overflowLabelValue
+	}
+	return overflow
+}
+
+// register adds a metric family to the registry.
+func register(c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, c)
+}
+
+// labelKey joins label values into a map key.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// escapeLabelValue escapes a label value for the Prometheus text format.
+func escapeLabelValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return strings.ReplaceAll(value, `"`, `\"`)
+}
+
+// formatLabels formats label names and values as `{name="value",...}`.
+func formatLabels(names, values []string, extra ...string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escapeLabelValue(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], escapeLabelValue(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// formatFloat formats a sample value for the Prometheus text format.
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric family.
+func writeHeader(w *bufio.Writer, name, help, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// CounterVec is a family of counters partitioned by label values.
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu         sync.Mutex
+	values     map[string]float64
+	series     map[string][]string
+	overflowed bool
+}
+
+// NewCounterVec creates a counter family and registers it.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: map[string]float64{}, series: map[string][]string{}}
+	register(c)
+	return c
+}
+
+// Add increases the counter with the given label values by delta, negative deltas are ignored.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 || len(labelValues) != len(c.labels) {
+		return
+	}
+	key := labelKey(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, exists := c.series[key]
+	labelValues = seriesLabels(c.name, labelValues, exists, len(c.series), &c.overflowed)
+	key = labelKey(labelValues)
+	if _, ok := c.series[key]; !ok {
+		c.series[key] = append([]string{}, labelValues...)
+	}
+	c.values[key] += delta
+}
+
+// Inc increases the counter with the given label values by one.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.series) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, c.series[key]), formatFloat(c.values[key]))
+	}
+}
+
+// HistogramVec is a family of histograms partitioned by label values.
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu         sync.Mutex
+	series     map[string]*histogram
+	overflowed bool
+}
+
+// histogram holds the observations of a single series of a HistogramVec.
+type histogram struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+// NewHistogramVec creates a histogram family with the given upper bucket bounds and registers it.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogram{}}
+	register(h)
+	return h
+}
+
+// Observe adds an observation to the histogram with the given label values.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) || math.IsNaN(value) {
+		return
+	}
+	key := labelKey(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, exists := h.series[key]
+	labelValues = seriesLabels(h.name, labelValues, exists, len(h.series), &h.overflowed)
+	key = labelKey(labelValues)
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogram{labelValues: append([]string{}, labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+	for _, key := range sortedKeys(h.series) {
+		series := h.series[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, series.labelValues, "le", formatFloat(bound)), series.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, series.labelValues, "le", "+Inf"), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, series.labelValues), formatFloat(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, series.labelValues), series.count)
+	}
+}
+
+// GaugeFunc is a gauge whose value is read when the metrics are scraped.
+type GaugeFunc struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+// NewGaugeFunc creates a gauge that reports the value returned by the function and registers it.
+func NewGaugeFunc(name, help string, value func() float64) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, value: value}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.value()))
+}
+
+// sortedKeys returns the keys of a map in sorted order so the output is stable between scrapes.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Handler serves the registered metrics in the Prometheus text exposition format on `/metrics`.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	registryMu.Lock()
+	collectors := append([]collector{}, registry...)
+	registryMu.Unlock()
+
+	writer := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(writer)
+	}
+	writer.Flush()
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+// output returns the exposition of a single metric family.
+func output(c collector) string {
+	var b strings.Builder
+	w := bufio.NewWriter(&b)
+	c.write(w)
+	w.Flush()
+	return b.String()
+}
+
+// withSeriesLimit sets the series limit for a test.
+func withSeriesLimit(t *testing.T, limit int) {
+	t.Helper()
+	SetSeriesLimit(limit)
+	t.Cleanup(func() { SetSeriesLimit(0) })
+}
+
+func TestCounterVecSeriesLimit(t *testing.T) {
+	withSeriesLimit(t, 2)
+	c := &CounterVec{name: "test_total", help: "Test.", labels: []string{"app"}, values: map[string]float64{}, series: map[string][]string{}}
+
+	c.Inc("a")
+	c.Inc("b")
+	c.Inc("c")
+	c.Inc("d")
+	c.Inc("a")
+
+	got := output(c)
+	for _, want := range []string{`test_total{app="a"} 2`, `test_total{app="b"} 1`, `test_total{app="other"} 2`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output is missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, `app="c"`) || strings.Contains(got, `app="d"`) {
+		t.Errorf("output has series past the limit:\n%s", got)
+	}
+}
+
+func TestHistogramVecSeriesLimit(t *testing.T) {
+	withSeriesLimit(t, 1)
+	h := &HistogramVec{name: "test_seconds", help: "Test.", labels: []string{"app", "model"}, buckets: []float64{1}, series: map[string]*histogram{}}
+
+	h.Observe(0.5, "a", "m")
+	h.Observe(2, "b", "m")
+
+	got := output(h)
+	if !strings.Contains(got, `test_seconds_count{app="a",model="m"} 1`) || !strings.Contains(got, `test_seconds_count{app="other",model="other"} 1`) {
+		t.Errorf("output does not fold the second series into 'other':\n%s", got)
+	}
+}
+
+func TestCounterVecWithoutLimit(t *testing.T) {
+	c := &CounterVec{name: "test_total", help: "Test.", labels: []string{"app"}, values: map[string]float64{}, series: map[string][]string{}}
+	for _, app := range []string{"a", "b", "c"} {
+		c.Inc(app)
+	}
+	if got := output(c); strings.Contains(got, "other") {
+		t.Errorf("output folds series without a limit:\n%s", got)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"ingester/config"
+	"ingester/db"
+
+	"github.com/rs/zerolog/log"
+)
+
+// migrateUsage describes the `migrate` subcommand.
+const migrateUsage = `Usage: ingester migrate <up|down|status> [-config ./config.yml] [-steps 1]
+
+  up      apply all pending schema migrations
+  down    revert the most recently applied schema migrations
+  status  list the schema migrations and when they were applied
+`
+
+// runMigrate handles the `migrate` subcommand, which manages the database schema without starting the server.
+func runMigrate(args []string) {
+	if len(args) == 0 || (args[0] != "up" && args[0] != "down" && args[0] != "status") {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+	action := args[0]
+
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, migrateUsage) }
+	configFilePath := flags.String("config", "./config.yml", "Path to the Doku Ingester config file")
+	steps := flags.Int("steps", 1, "Number of migrations to revert with 'down'")
+	flags.Parse(args[1:])
+
+	cfg, err := config.LoadConfiguration(*configFilePath)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load configuration file")
+	}
+
+	if err := db.Connect(*cfg); err != nil {
+		log.Fatal().Err(err).Msg("Unable to initialize connection to the backend database")
+	}
+
+	switch action {
+	case "up":
+		err = db.MigrateUp()
+	case "down":
+		if *steps <= 0 {
+			log.Fatal().Msg("'-steps' must be at least 1")
+		}
+		err = db.MigrateDown(*steps)
+	case "status":
+		var statuses []db.MigrationStatus
+		if statuses, err = db.GetMigrationStatus(); err == nil {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+			for _, status := range statuses {
+				appliedAt := "pending"
+				if status.AppliedAt != nil {
+					appliedAt = status.AppliedAt.Format(time.RFC3339)
+				}
+				fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+			}
+			w.Flush()
+		}
+	}
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Migrate %s failed", action)
+	}
+}
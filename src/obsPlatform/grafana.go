@@ -0,0 +1,132 @@
+package obsPlatform
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ingester/config"
+	"ingester/record"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// grafanaExporter sends metrics to Grafana Cloud Prometheus and logs to Grafana Cloud Loki.
+type grafanaExporter struct {
+	promURL      string // promURL is the URL used to send data to Grafana Prometheus.
+	promUsername string // promUsername is the username used to send data to Grafana Prometheus.
+	lokiURL      string // lokiURL is the URL used to send data to Grafana Loki.
+	lokiUsername string // lokiUsername is the username used to send data to Grafana Loki.
+	accessToken  string // accessToken is the access token used to send data to Grafana.
+}
+
+func init() {
+	RegisterExporter("Grafana Cloud", newGrafanaExporter)
+}
+
+// newGrafanaExporter creates the Grafana Cloud exporter if the 'grafanaCloud' block is configured.
+func newGrafanaExporter(cfg config.Configuration) (Exporter, error) {
+	grafanaCfg := cfg.ObservabilityPlatform.GrafanaCloud
+	if grafanaCfg.LokiURL == "" {
+		return nil, nil
+	}
+
+	return &grafanaExporter{
+		promURL:      grafanaCfg.PromURL,
+		promUsername: grafanaCfg.PromUsername,
+		lokiURL:      grafanaCfg.LokiURL,
+		lokiUsername: grafanaCfg.LokiUsername,
+		accessToken:  grafanaCfg.AccessToken,
+	}, nil
+}
+
+// Name returns the name of the Grafana Cloud exporter.
+func (e *grafanaExporter) Name() string {
+	return "Grafana Cloud"
+}
+
+// Export sends the metrics and logs of a record to Grafana Cloud.
+func (e *grafanaExporter) Export(data record.Record) error {
+	endpoint, ok := record.LookupEndpoint(data.Endpoint)
+	if !ok {
+		return nil
+	}
+	var errs []error
+
+	// Metrics are sent in the Influx line protocol, one line per numeric field of the endpoint
+	tags := fmt.Sprintf("doku_llm,environment=%v,endpoint=%v,applicationName=%v,source=%v,model=%v",
+		data.Environment, data.Endpoint, data.ApplicationName, data.SourceLanguage, data.Model)
+	for _, label := range endpoint.Labels {
+		tags += fmt.Sprintf(",%s=%v", label, labelValue(data, label))
+	}
+	var metrics []string
+	for _, metric := range endpoint.Metrics {
+		if value, ok := data.Field(metric); ok {
+			metrics = append(metrics, fmt.Sprintf("%s %s=%v", tags, metric, value))
+		}
+	}
+	if len(metrics) > 0 {
+		var metricsBody = []byte(strings.Join(metrics, "\n"))
+		authHeader := fmt.Sprintf("Bearer %v:%v", e.promUsername, e.accessToken)
+		err := sendTelemetry(metricsBody, authHeader, e.promURL, "POST")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Error sending data to Grafana Cloud Prometheus: %w", err))
+		}
+	}
+
+	authHeader := fmt.Sprintf("Bearer %v:%v", e.lokiUsername, e.accessToken)
+	for _, logField := range endpoint.Logs {
+		message := logMessage(data, logField)
+		if message == "" {
+			continue
+		}
+		stream := map[string]interface{}{
+			"streams": []interface{}{map[string]interface{}{
+				"stream": map[string]string{
+					"environment":     data.Environment,
+					"endpoint":        data.Endpoint,
+					"applicationName": data.ApplicationName,
+					"source":          data.SourceLanguage,
+					"model":           data.Model,
+					"type":            logField.Type,
+				},
+				"values": [][]string{{strconv.FormatInt(time.Now().UnixNano(), 10), message}},
+			}},
+		}
+		body, _ := json.Marshal(stream)
+		err := sendTelemetry(body, authHeader, e.lokiURL, "POST")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Error sending data to Grafana Cloud Loki: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func sendTelemetry(telemetryData []byte, authHeader string, url string, requestType string) error {
+
+	req, err := http.NewRequest(requestType, url, bytes.NewBuffer(telemetryData))
+	if err != nil {
+		return fmt.Errorf("Error creating request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", authHeader)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Error sending request to %v", url)
+	} else if resp.StatusCode == 404 {
+		return fmt.Errorf("Provided URL %v is not valid", url)
+	} else if resp.StatusCode == 401 {
+		return fmt.Errorf("Provided credentials are not valid")
+	}
+
+	defer resp.Body.Close()
+
+	log.Info().Msgf("Successfully exported data to %v", url)
+	return nil
+}
@@ -2,393 +2,145 @@ package obsPlatform
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"ingester/config"
+	"ingester/record"
 	"net/http"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/rs/zerolog/log"
 )
 
-func configureNewRelicData(data map[string]interface{}) {
-	// The current time for the timestamp field.
-	currentTime := strconv.FormatInt(time.Now().Unix(), 10)
-
-	if data["endpoint"] == "openai.chat.completions" || data["endpoint"] == "openai.completions" || data["endpoint"] == "cohere.generate" || data["endpoint"] == "cohere.chat" || data["endpoint"] == "cohere.summarize" || data["endpoint"] == "anthropic.completions" {
-		if data["finishReason"] == nil {
-			data["finishReason"] = "null"
-		}
-
-		jsonMetrics := []string{
-			fmt.Sprintf(`{
-			"name": "doku.LLM.Completion.Tokens",
-			"type": "gauge",
-			"value": %f,
-			"timestamp": %s,
-			"attributes": {"environment": "%v", "endpoint": "%v", "applicationName": "%v", "source": "%v", "model": "%v", "finishReason": "%v"}
-		}`, float64(data["completionTokens"].(int)), currentTime, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["finishReason"]),
-			fmt.Sprintf(`{
-			"name": "doku.LLM.Prompt.Tokens",
-			"type": "gauge",
-			"value": %f,
-			"timestamp": %s,
-			"attributes": {"environment": "%v", "endpoint": "%v", "applicationName": "%v", "source": "%v", "model": "%v", "finishReason": "%v"}
-		}`, float64(data["promptTokens"].(int)), currentTime, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["finishReason"]),
-			fmt.Sprintf(`{
-			"name": "doku.LLM.Total.Tokens",
-			"type": "gauge",
-			"value": %f,
-			"timestamp": %s,
-			"attributes": {"environment": "%v", "endpoint": "%v", "applicationName": "%v", "source": "%v", "model": "%v", "finishReason": "%v"}
-		}`, float64(data["totalTokens"].(int)), currentTime, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["finishReason"]),
-			fmt.Sprintf(`{
-			"name": "doku.LLM.Request.Duration",
-			"type": "gauge",
-			"value": %v,
-			"timestamp": %s,
-			"attributes": {"environment": "%v", "endpoint": "%v", "applicationName": "%v", "source": "%v", "model": "%v", "finishReason": "%v"}
-		}`, data["requestDuration"], currentTime, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["finishReason"]),
-			fmt.Sprintf(`{
-			"name": "doku.LLM.Usage.Cost",
-			"type": "gauge",
-			"value": %v,
-			"timestamp": %s,
-			"attributes": {"environment": "%v", "endpoint": "%v", "applicationName": "%v", "source": "%v", "model": "%v", "finishReason": "%v"}
-		}`, data["usageCost"], currentTime, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["finishReason"]),
-		}
-
-		// Join the individual metric strings into a comma-separated string and enclose in a JSON array.
-		jsonData := fmt.Sprintf(`[{"metrics": [%s]}]`, strings.Join(jsonMetrics, ","))
-
-		err := sendTelemetryNewRelic(jsonData, newRelicLicenseKey, "Api-Key", newRelicMetricsUrl, "POST")
-		if err != nil {
-			log.Error().Err(err).Msgf("Error sending Metrics to New Relic")
-		}
-
-		// Use the values from the provided log lines and adapt them to the desired format.
-		logs := []string{
-			fmt.Sprintf(`{
-				"timestamp": %s,
-				"message": "%s",
-				"attributes": {
-					"environment": "%v",
-					"endpoint": "%v",
-					"applicationName": "%v",
-					"source": "%v",
-					"model": "%v",
-					"type": "response"
-				}
-			}`, currentTime, normalizeString(data["response"].(string)), data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"]), // Assuming 'response' is present in data and is a string.
-
-			fmt.Sprintf(`{
-				"timestamp": %s,
-				"message": "%s",
-				"attributes": {
-					"environment": "%v",
-					"endpoint": "%v",
-					"applicationName": "%v",
-					"source": "%v",
-					"model": "%v",
-					"type": "prompt"
-				}
-			}`, currentTime, normalizeString(data["prompt"].(string)), data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"]), // Assuming 'prompt' is present in data and is a string.
-		}
-
-		// Combine the individual log entries into a full JSON payload
-		jsonData = fmt.Sprintf(`[{"logs": [%s]}]`, strings.Join(logs, ","))
-
-		err = sendTelemetryNewRelic(jsonData, newRelicLicenseKey, "Api-Key", newRelicLogsUrl, "POST")
-		if err != nil {
-			log.Error().Err(err).Msgf("Error sending Logs to New Relic")
-		}
-
-	} else if data["endpoint"] == "openai.embeddings" || data["endpoint"] == "cohere.embed" {
-		if data["endpoint"] == "openai.embeddings" {
-			jsonMetrics := []string{
-				fmt.Sprintf(`{
-					"name": "doku.LLM.Prompt.Tokens",
-					"type": "gauge",
-					"value": %v,
-					"timestamp": %s,
-					"attributes": { "environment": "%v", "endpoint": "%v", "applicationName": "%v", "source": "%v", "model": "%v"}
-				}`, float64(data["promptTokens"].(int)), currentTime, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"]),
-				fmt.Sprintf(`{
-					"name": "doku.LLM.Total.Tokens",
-					"type": "gauge",
-					"value": %v,
-					"timestamp": %s,
-					"attributes": {"environment": "%v", "endpoint": "%v", "applicationName": "%v", "source": "%v", "model": "%v"}
-				}`, float64(data["totalTokens"].(int)), currentTime, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"]),
-				fmt.Sprintf(`{
-					"name": "doku.LLM.Request.Duration",
-					"type": "gauge",
-					"value": %v,
-					"timestamp": %s,
-					"attributes": { "environment": "%v", "endpoint": "%v", "applicationName": "%v", "source": "%v", "model": "%v"}
-				}`, data["requestDuration"], currentTime, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"]),
-				fmt.Sprintf(`{
-					"name": "doku.LLM.Usage.Cost",
-					"type": "gauge",
-					"value": %v,
-					"timestamp": %s,
-					"attributes": { "environment": "%v", "endpoint": "%v", "applicationName": "%v", "source": "%v", "model": "%v"}
-				}`, data["usageCost"], currentTime, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"]),
-			}
-
-			// Join the individual metric strings into a comma-separated string and enclose in a JSON array.
-			jsonData := fmt.Sprintf(`[{"metrics": [%s]}]`, strings.Join(jsonMetrics, ","))
-
-			err := sendTelemetryNewRelic(jsonData, newRelicLicenseKey, "Api-Key", newRelicMetricsUrl, "POST")
-			if err != nil {
-				log.Error().Err(err).Msgf("Error sending Metrics to New Relic")
-			}
-
-			// Build log entries with varying labels
-			logs := []string{
-				fmt.Sprintf(`{
-					"timestamp": %s,
-					"message": "%s",
-					"attributes": {
-						"environment": "%v",
-						"endpoint": "%v",
-						"applicationName": "%v",
-						"source": "%v",
-						"model": "%v",
-						"type": "prompt"
-					}
-				}`, currentTime, normalizeString(data["prompt"].(string)), data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"]),
-			}
-			// Combine the individual log entries into a full JSON payload
-			jsonData = fmt.Sprintf(`[{"logs": [%s]}]`, strings.Join(logs, ","))
-
-			err = sendTelemetryNewRelic(jsonData, newRelicLicenseKey, "Api-Key", newRelicLogsUrl, "POST")
-			if err != nil {
-				log.Error().Err(err).Msgf("Error sending Logs to New Relic")
-			}
-		} else {
-			jsonMetrics := []string{
-				fmt.Sprintf(`{
-					"name": "doku.LLM.Prompt.Tokens",
-					"type": "gauge",
-					"value": %v,
-					"timestamp": %s,
-					"attributes": {"environment": "%v", "endpoint": "%v", "applicationName": "%v", "source": "%v", "model": "%v"}
-				}`, float64(data["promptTokens"].(int)), currentTime, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"]),
-				fmt.Sprintf(`{
-					"name": "doku.LLM.Request.Duration",
-					"type": "gauge",
-					"value": %v,
-					"timestamp": %s,
-					"attributes": {"environment": "%v", "endpoint": "%v", "applicationName": "%v", "source": "%v", "model": "%v"}
-				}`, data["requestDuration"], currentTime, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"]),
-				fmt.Sprintf(`{
-					"name": "doku.LLM.Usage.Cost",
-					"type": "gauge",
-					"value": %v,
-					"timestamp": %s,
-					"attributes": {"environment": "%v", "endpoint": "%v", "applicationName": "%v", "source": "%v", "model": "%v"}
-				}`, data["usageCost"], currentTime, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"]),
-			}
-
-			// Join the individual metric strings into a comma-separated string and enclose in a JSON array.
-			jsonData := fmt.Sprintf(`[{"metrics": [%s]}]`, strings.Join(jsonMetrics, ","))
-
-			err := sendTelemetryNewRelic(jsonData, newRelicLicenseKey, "Api-Key", newRelicMetricsUrl, "POST")
-			if err != nil {
-				log.Error().Err(err).Msgf("Error sending Merics to New Relic")
-			}
-
-			logs := []string{
-				// Assuming 'prompt' exists and is a string in data
-				fmt.Sprintf(`{
-					"timestamp": "%s",
-					"message": "%s",
-					"attributes": {
-						"environment": "%v",
-						"endpoint": "%v",
-						"applicationName": "%v",
-						"source": "%v",
-						"model": "%v",
-						"type": "prompt"
-					}
-				}`,
-					currentTime,
-					data["prompt"],
-					data["environment"],
-					data["endpoint"],
-					data["applicationName"],
-					data["sourceLanguage"],
-					data["model"]),
-			}
-
-			// Combine the individual log entries into a full JSON payload
-			jsonData = fmt.Sprintf(`[{"logs": [%s]}]`, strings.Join(logs, ","))
+// newRelicExporter sends metrics and logs to the New Relic Metric and Log APIs.
+type newRelicExporter struct {
+	licenseKey string // licenseKey is the key used to send data to New Relic.
+	metricsURL string // metricsURL is the URL used to send metrics to New Relic.
+	logsURL    string // logsURL is the URL used to send logs to New Relic.
+}
 
-			err = sendTelemetryNewRelic(jsonData, newRelicLicenseKey, "Api-Key", newRelicLogsUrl, "POST")
-			if err != nil {
-				log.Error().Err(err).Msgf("Error sending Logs to New Relic")
-			}
+func init() {
+	RegisterExporter("New Relic", newNewRelicExporter)
+}
 
-		}
-	} else if data["endpoint"] == "openai.fine_tuning" {
-		jsonMetrics := []string{
-			fmt.Sprintf(`{
-					"name": "doku.LLM.Request.Duration",
-					"type": "gauge",
-					"value": %v,
-					"timestamp": %s,
-					"attributes": {"environment": "%v", "endpoint": "%v", "applicationName": "%v", "source": "%v", "model": "%v", "finetuneJobId": "%v"}
-				}`, data["requestDuration"], currentTime, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["finetuneJobId"]),
-		}
-		// Join the individual metric strings into a comma-separated string and enclose in a JSON array.
-		jsonData := fmt.Sprintf(`[{"metrics": [%s]}]`, strings.Join(jsonMetrics, ","))
+// newNewRelicExporter creates the New Relic exporter if the 'newRelic' block is configured.
+func newNewRelicExporter(cfg config.Configuration) (Exporter, error) {
+	newRelicCfg := cfg.ObservabilityPlatform.NewRelic
+	if newRelicCfg.Key == "" {
+		return nil, nil
+	}
 
-		err := sendTelemetryNewRelic(jsonData, newRelicLicenseKey, "Api-Key", newRelicMetricsUrl, "POST")
-		if err != nil {
-			log.Error().Err(err).Msgf("Error sending Metrics to New Relic")
-		}
-	} else if data["endpoint"] == "openai.images.create" || data["endpoint"] == "openai.images.create.variations" {
-		jsonMetrics := []string{
-			fmt.Sprintf(`{
-					"name": "doku_llm.RequestDuration",
-					"type": "gauge",
-					"value": %v,
-					"timestamp": %s,
-					"attributes": {
-						"environment": "%v",
-						"endpoint": "%v",
-						"applicationName": "%v",
-						"source": "%v",
-						"model": "%v",
-						"imageSize": "%v",
-						"imageQuality": "%v"
-					}
-				}`, data["requestDuration"], currentTime, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["imageSize"], data["imageQuality"]),
-			fmt.Sprintf(`{
-					"name": "doku_llm.UsageCost",
-					"type": "gauge",
-					"value": %v,
-					"timestamp": %s,
-					"attributes": {
-						"environment": "%v",
-						"endpoint": "%v",
-						"applicationName": "%v",
-						"source": "%v",
-						"model": "%v",
-						"imageSize": "%v",
-						"imageQuality": "%v"
-					}
-				}`, data["usageCost"], currentTime, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["imageSize"], data["imageQuality"]),
-		}
+	return &newRelicExporter{
+		licenseKey: newRelicCfg.Key,
+		metricsURL: newRelicCfg.MetricsURL,
+		logsURL:    newRelicCfg.LogsURL,
+	}, nil
+}
 
-		// Join the individual metric strings into a comma-separated string and enclose in a JSON array.
-		jsonData := fmt.Sprintf(`[{"metrics": [%s]}]`, strings.Join(jsonMetrics, ","))
+// Name returns the name of the New Relic exporter.
+func (e *newRelicExporter) Name() string {
+	return "New Relic"
+}
 
-		err := sendTelemetryNewRelic(jsonData, newRelicLicenseKey, "Api-Key", newRelicMetricsUrl, "POST")
-		if err != nil {
-			log.Error().Err(err).Msgf("Error sending Metrics to New Relic")
-		}
+// newRelicMetricNames maps the numeric fields of a record to the New Relic metrics they are sent as.
+var newRelicMetricNames = map[string]string{
+	"completionTokens": "doku.LLM.Completion.Tokens",
+	"promptTokens":     "doku.LLM.Prompt.Tokens",
+	"totalTokens":      "doku.LLM.Total.Tokens",
+	"cacheReadTokens":  "doku.LLM.Cache.Read.Tokens",
+	"cacheWriteTokens": "doku.LLM.Cache.Write.Tokens",
+	"requestDuration":  "doku.LLM.Request.Duration",
+	"usageCost":        "doku.LLM.Usage.Cost",
+}
 
-		var logs []string
-		// Check the condition for the prompt
-		if data["endpoint"] != "openai.images.create.variations" {
-			var promptMessage string
-			if data["model"] == "dall-e-2" {
-				// Assuming data["prompt"] exists and is a string
-				promptMessage = normalizeString(data["prompt"].(string))
-			} else {
-				// Assuming data["revisedPrompt"] exists and is a string
-				promptMessage = normalizeString(data["revisedPrompt"].(string))
-			}
+// newRelicLegacyMetricNames keeps the metric names image and audio records have always been sent
+// as, so that existing dashboards keep working.
+var newRelicLegacyMetricNames = map[string]string{
+	"requestDuration": "doku_llm.RequestDuration",
+	"usageCost":       "doku_llm.UsageCost",
+}
 
-			// Build the prompt log
-			logs = append(logs, fmt.Sprintf(`{
-				"timestamp": "%s",
-				"message": "%s",
-				"attributes": {
-					"environment": "%v",
-					"endpoint": "%v",
-					"applicationName": "%v",
-					"source": "%v",
-					"model": "%v",
-					"type": "prompt"
-				}
-			}`, currentTime, promptMessage, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"]))
+// newRelicMetricName returns the name of the New Relic metric a numeric field of a record is sent as.
+func newRelicMetricName(category record.Category, field string) string {
+	if category == record.CategoryImage || category == record.CategoryAudio {
+		if name, ok := newRelicLegacyMetricNames[field]; ok {
+			return name
 		}
+	}
+	return newRelicMetricNames[field]
+}
 
-		// Build the image log
-		logs = append(logs, fmt.Sprintf(`{
-			"timestamp": "%s",
-			"message": "%s",
-			"attributes": {
-				"environment": "%v",
-				"endpoint": "%v",
-				"applicationName": "%v",
-				"source": "%v",
-				"model": "%v",
-				"type": "image"
-			}
-		}`, currentTime, data["image"], data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"]))
-
-		// Combine the individual log entries into a full JSON payload
-		jsonData = fmt.Sprintf(`[{"logs": [%s]}]`, strings.Join(logs, ","))
+// Export sends the metrics and logs of a record to New Relic.
+func (e *newRelicExporter) Export(data record.Record) error {
+	endpoint, ok := record.LookupEndpoint(data.Endpoint)
+	if !ok {
+		return nil
+	}
+	var errs []error
 
-		err = sendTelemetryNewRelic(jsonData, newRelicLicenseKey, "Api-Key", newRelicLogsUrl, "POST")
-		if err != nil {
-			log.Error().Err(err).Msgf("Error sending Logs to New Relic")
-		}
+	// The current time for the timestamp field.
+	currentTime := time.Now().Unix()
+
+	attributes := map[string]string{
+		"environment":     data.Environment,
+		"endpoint":        data.Endpoint,
+		"applicationName": data.ApplicationName,
+		"source":          data.SourceLanguage,
+		"model":           data.Model,
+	}
+	for _, label := range endpoint.Labels {
+		attributes[label] = labelValue(data, label)
+	}
 
-	} else if data["endpoint"] == "openai.audio.speech.create" {
-		jsonMetrics := []string{
-			fmt.Sprintf(`{
-					"name": "doku_llm.RequestDuration",
-					"type": "gauge",
-					"value": %v,
-					"timestamp": %s,
-					"attributes": {"environment": "%v", "endpoint": "%v", "applicationName": "%v", "source": "%v", "model": "%v", "audioVoice": "%v"}
-				}`, data["requestDuration"], currentTime, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["audioVoice"]),
-			fmt.Sprintf(`{
-					"name": "doku_llm.UsageCost",
-					"type": "gauge",
-					"value": %v,
-					"timestamp": %s,
-					"attributes": {"environment": "%v", "endpoint": "%v", "applicationName": "%v", "source": "%v", "model": "%v", "audioVoice": "%v"}
-				}`, data["usageCost"], currentTime, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["audioVoice"]),
+	var metrics []interface{}
+	for _, metric := range endpoint.Metrics {
+		if value, ok := data.Field(metric); ok {
+			metrics = append(metrics, map[string]interface{}{
+				"name":       newRelicMetricName(endpoint.Category, metric),
+				"type":       "gauge",
+				"value":      value,
+				"timestamp":  currentTime,
+				"attributes": attributes,
+			})
 		}
-
-		// Join the individual metric strings into a comma-separated string and enclose in a JSON array.
-		jsonData := fmt.Sprintf(`[{"metrics": [%s]}]`, strings.Join(jsonMetrics, ","))
-
-		err := sendTelemetryNewRelic(jsonData, newRelicLicenseKey, "Api-Key", newRelicMetricsUrl, "POST")
+	}
+	if len(metrics) > 0 {
+		jsonData, _ := json.Marshal([]interface{}{map[string]interface{}{"metrics": metrics}})
+		err := sendTelemetryNewRelic(string(jsonData), e.licenseKey, "Api-Key", e.metricsURL, "POST")
 		if err != nil {
-			log.Error().Err(err).Msgf("Error sending Metrics to New Relic")
-		}
-
-		logs := []string{
-			// Assuming 'prompt' exists and is a string in data
-			fmt.Sprintf(`{
-				"timestamp": "%s",
-				"message": "%s",
-				"attributes": {
-					"environment": "%v",
-					"endpoint": "%v",
-					"applicationName": "%v",
-					"source": "%v",
-					"model": "%v",
-					"type": "prompt"
-				}
-			}`, currentTime, data["prompt"], data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"]),
+			errs = append(errs, fmt.Errorf("Error sending Metrics to New Relic: %w", err))
 		}
+	}
 
-		// Combine the individual log entries into a full JSON payload
-		jsonData = fmt.Sprintf(`[{"logs": [%s]}]`, strings.Join(logs, ","))
-
-		err = sendTelemetryNewRelic(jsonData, newRelicLicenseKey, "Api-Key", newRelicLogsUrl, "POST")
+	var logs []interface{}
+	for _, logField := range endpoint.Logs {
+		message := logMessage(data, logField)
+		if message == "" {
+			continue
+		}
+		logs = append(logs, map[string]interface{}{
+			"timestamp": currentTime,
+			"message":   message,
+			"attributes": map[string]string{
+				"environment":     data.Environment,
+				"endpoint":        data.Endpoint,
+				"applicationName": data.ApplicationName,
+				"source":          data.SourceLanguage,
+				"model":           data.Model,
+				"type":            logField.Type,
+			},
+		})
+	}
+	if len(logs) > 0 {
+		jsonData, _ := json.Marshal([]interface{}{map[string]interface{}{"logs": logs}})
+		err := sendTelemetryNewRelic(string(jsonData), e.licenseKey, "Api-Key", e.logsURL, "POST")
 		if err != nil {
-			log.Error().Err(err).Msgf("Error sending Logs to New Relic")
+			errs = append(errs, fmt.Errorf("Error sending Logs to New Relic: %w", err))
 		}
 	}
+
+	return errors.Join(errs...)
 }
 
 func sendTelemetryNewRelic(telemetryData, authHeader string, headerKey string, url string, requestType string) error {
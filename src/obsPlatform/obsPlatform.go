@@ -1,31 +1,55 @@
 package obsPlatform
 
 import (
-	"bytes"
 	"fmt"
 	"ingester/config"
+	"ingester/metrics"
+	"ingester/record"
 	"net/http"
 	"regexp"
-	"strconv"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/rs/zerolog/log"
 )
 
+// Exporter sends the observability data of an ingested record to a platform.
+type Exporter interface {
+	// Name returns the name of the platform the exporter sends data to.
+	Name() string
+	// Export sends the metrics and logs of a single record to the platform.
+	Export(data record.Record) error
+}
+
+// ExporterFactory creates an exporter from its configuration block under
+// 'observabilityPlatform'. It returns a nil Exporter when the block is not configured.
+type ExporterFactory func(cfg config.Configuration) (Exporter, error)
+
+// registeredExporter is an entry in the exporter registry.
+type registeredExporter struct {
+	name    string
+	factory ExporterFactory
+}
+
 var (
-	ObservabilityPlatform string       // ObservabilityPlatform contains the information on the platform in use.
-	httpClient            *http.Client // httpClient is the HTTP client used to send data to the Observability Platform.
-	grafanaPromUrl        string       // grafanaPrometheusUrl is the URL used to send data to Grafana Prometheus.
-	grafanaPromUsername   string       // grafanaPrometheusUsername is the username used to send data to Grafana Prometheus.
-	grafanaLokiUrl        string       // grafanaPostUrl is the URL used to send data to Grafana Loki.
-	grafanaLokiUsername   string       // grafanaLokiUsername is the username used to send data to Grafana Loki.
-	grafanaAccessToken    string       // grafanaAccessToken is the access token used to send data to Grafana.
-	newRelicLicenseKey    string       // newRelicKey is the key used to send data to New Relic.
-	newRelicMetricsUrl    string       // newRelicMetricsUrl is the URL used to send data to New Relic.
-	newRelicLogsUrl       string       // newRelicLogsUrl is the URL used to send logs to New Relic.
+	ObservabilityPlatform string               // ObservabilityPlatform contains the information on the platforms in use.
+	httpClient            *http.Client         // httpClient is the HTTP client used to send data to the Observability Platform.
+	registry              []registeredExporter // registry holds the exporters available to be configured.
+	exporters             []Exporter           // exporters holds the exporters configured in Init.
 )
 
+// RegisterExporter adds an exporter to the registry. It is meant to be called from
+// the init function of the file implementing the exporter.
+func RegisterExporter(name string, factory ExporterFactory) {
+	for _, entry := range registry {
+		if entry.name == name {
+			panic(fmt.Sprintf("obsPlatform: exporter '%s' registered twice", name))
+		}
+	}
+	registry = append(registry, registeredExporter{name: name, factory: factory})
+}
+
 func normalizeString(s string) string {
 	// Remove backslashes
 	s = strings.ReplaceAll(s, `\`, "")
@@ -48,181 +72,70 @@ func normalizeString(s string) string {
 	return s
 }
 
-func Init(cfg config.Configuration) error {
-	httpClient = &http.Client{Timeout: 5 * time.Second}
-	if cfg.ObservabilityPlatform.GrafanaCloud.LokiURL != "" {
-		grafanaPromUrl = cfg.ObservabilityPlatform.GrafanaCloud.PromURL
-		grafanaPromUsername = cfg.ObservabilityPlatform.GrafanaCloud.PromUsername
-		grafanaLokiUrl = cfg.ObservabilityPlatform.GrafanaCloud.LokiURL
-		grafanaLokiUsername = cfg.ObservabilityPlatform.GrafanaCloud.LokiUsername
-		grafanaAccessToken = cfg.ObservabilityPlatform.GrafanaCloud.AccessToken
-	} else if cfg.ObservabilityPlatform.NewRelic.Key != "" {
-		newRelicLicenseKey = cfg.ObservabilityPlatform.NewRelic.Key
-		newRelicMetricsUrl = cfg.ObservabilityPlatform.NewRelic.MetricsURL
-		newRelicLogsUrl = cfg.ObservabilityPlatform.NewRelic.LogsURL
+// labelValue returns the value of a field of a record used as a metric label, or 'null' when it is not set.
+func labelValue(data record.Record, field string) string {
+	if value := data.String(field); value != "" {
+		return value
 	}
-	return nil
+	return "null"
 }
 
-// SendToPlatform sends observability data to the appropriate platform.
-func SendToPlatform(data map[string]interface{}) {
-	if grafanaLokiUrl != "" {
-		if data["endpoint"] == "openai.chat.completions" || data["endpoint"] == "openai.completions" || data["endpoint"] == "cohere.generate" || data["endpoint"] == "cohere.chat" || data["endpoint"] == "cohere.summarize" || data["endpoint"] == "anthropic.completions" {
-			if data["finishReason"] == nil {
-				data["finishReason"] = "null"
-			}
-			metrics := []string{
-				fmt.Sprintf(`doku_llm,environment=%v,endpoint=%v,applicationName=%v,source=%v,model=%v,finishReason=%v completionTokens=%v`, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["finishReason"], data["completionTokens"]),
-				fmt.Sprintf(`doku_llm,environment=%v,endpoint=%v,applicationName=%v,source=%v,model=%v,finishReason=%v promptTokens=%v`, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["finishReason"], data["promptTokens"]),
-				fmt.Sprintf(`doku_llm,environment=%v,endpoint=%v,applicationName=%v,source=%v,model=%v,finishReason=%v totalTokens=%v`, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["finishReason"], data["totalTokens"]),
-				fmt.Sprintf(`doku_llm,environment=%v,endpoint=%v,applicationName=%v,source=%v,model=%v,finishReason=%v requestDuration=%v`, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["finishReason"], data["requestDuration"]),
-				fmt.Sprintf(`doku_llm,environment=%v,endpoint=%v,applicationName=%v,source=%v,model=%v,finishReason=%v usageCost=%v`, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["finishReason"], data["usageCost"]),
-			}
-			var metricsBody = []byte(strings.Join(metrics, "\n"))
-			authHeader := fmt.Sprintf("Bearer %v:%v", grafanaPromUsername, grafanaAccessToken)
-			err := sendTelemetry(metricsBody, authHeader, grafanaPromUrl, "POST")
-			if err != nil {
-				log.Error().Err(err).Msgf("Error sending data to Grafana Cloud Prometheus")
-			}
-
-			authHeader = fmt.Sprintf("Bearer %v:%v", grafanaLokiUsername, grafanaAccessToken)
-
-			response_log := []byte(fmt.Sprintf("{\"streams\": [{\"stream\": {\"environment\": \"%v\",\"endpoint\": \"%v\", \"applicationName\": \"%v\", \"source\": \"%v\", \"model\": \"%v\", \"type\": \"response\" }, \"values\": [[\"%s\", \"%v\"]]}]}", data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], strconv.FormatInt(time.Now().UnixNano(), 10), normalizeString(data["response"].(string))))
-			err = sendTelemetry(response_log, authHeader, grafanaLokiUrl, "POST")
-			if err != nil {
-				log.Error().Err(err).Msgf("Error sending data to Grafana Cloud Loki")
-			}
-
-			prompt_log := []byte(fmt.Sprintf("{\"streams\": [{\"stream\": {\"environment\": \"%v\",\"endpoint\": \"%v\", \"applicationName\": \"%v\", \"source\": \"%v\", \"model\": \"%v\", \"type\": \"prompt\" }, \"values\": [[\"%s\", \"%v\"]]}]}", data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], strconv.FormatInt(time.Now().UnixNano(), 10), normalizeString(data["prompt"].(string))))
-			err = sendTelemetry(prompt_log, authHeader, grafanaLokiUrl, "POST")
-			if err != nil {
-				log.Error().Err(err).Msgf("Error sending data to Grafana Cloud Loki")
-			}
-		} else if data["endpoint"] == "openai.embeddings" || data["endpoint"] == "cohere.embed" {
-			if data["endpoint"] == "openai.embeddings" {
-				metrics := []string{
-					fmt.Sprintf(`doku_llm,environment=%v,endpoint=%v,applicationName=%v,source=%v,model=%v promptTokens=%v`, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["promptTokens"]),
-					fmt.Sprintf(`doku_llm,environment=%v,endpoint=%v,applicationName=%v,source=%v,model=%v totalTokens=%v`, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["totalTokens"]),
-					fmt.Sprintf(`doku_llm,environment=%v,endpoint=%v,applicationName=%v,source=%v,model=%v requestDuration=%v`, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["requestDuration"]),
-					fmt.Sprintf(`doku_llm,environment=%v,endpoint=%v,applicationName=%v,source=%v,model=%v usageCost=%v`, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["usageCost"]),
-				}
-				var metricsBody = []byte(strings.Join(metrics, "\n"))
-				authHeader := fmt.Sprintf("Bearer %v:%v", grafanaPromUsername, grafanaAccessToken)
-				err := sendTelemetry(metricsBody, authHeader, grafanaPromUrl, "POST")
-				if err != nil {
-					log.Error().Err(err).Msgf("Error sending data to Grafana Cloud Prometheus")
-				}
-
-				authHeader = fmt.Sprintf("Bearer %v:%v", grafanaLokiUsername, grafanaAccessToken)
-				prompt_log := []byte(fmt.Sprintf("{\"streams\": [{\"stream\": {\"environment\": \"%v\",\"endpoint\": \"%v\", \"applicationName\": \"%v\", \"source\": \"%v\", \"model\": \"%v\", \"type\": \"prompt\" }, \"values\": [[\"%s\", \"%v\"]]}]}", data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], strconv.FormatInt(time.Now().UnixNano(), 10), data["prompt"]))
-				err = sendTelemetry(prompt_log, authHeader, grafanaLokiUrl, "POST")
-				if err != nil {
-					log.Error().Err(err).Msgf("Error sending data to Grafana Cloud Loki")
-				}
-			} else {
-				metrics := []string{
-					fmt.Sprintf(`doku_llm,environment=%v,endpoint=%v,applicationName=%v,source=%v,model=%v promptTokens=%v`, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["promptTokens"]),
-					fmt.Sprintf(`doku_llm,environment=%v,endpoint=%v,applicationName=%v,source=%v,model=%v requestDuration=%v`, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["requestDuration"]),
-					fmt.Sprintf(`doku_llm,environment=%v,endpoint=%v,applicationName=%v,source=%v,model=%v usageCost=%v`, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["usageCost"]),
-				}
-				var metricsBody = []byte(strings.Join(metrics, "\n"))
-				authHeader := fmt.Sprintf("Bearer %v:%v", grafanaPromUsername, grafanaAccessToken)
-				err := sendTelemetry(metricsBody, authHeader, grafanaPromUrl, "POST")
-				if err != nil {
-					log.Error().Err(err).Msgf("Error sending data to Grafana Cloud Prometheus")
-				}
-
-				authHeader = fmt.Sprintf("Bearer %v:%v", grafanaLokiUsername, grafanaAccessToken)
-				prompt_log := []byte(fmt.Sprintf("{\"streams\": [{\"stream\": {\"environment\": \"%v\",\"endpoint\": \"%v\", \"applicationName\": \"%v\", \"source\": \"%v\", \"model\": \"%v\", \"type\": \"prompt\" }, \"values\": [[\"%s\", \"%v\"]]}]}", data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], strconv.FormatInt(time.Now().UnixNano(), 10), data["prompt"]))
-				err = sendTelemetry(prompt_log, authHeader, grafanaLokiUrl, "POST")
-				if err != nil {
-					log.Error().Err(err).Msgf("Error sending data to Grafana Cloud Loki")
-				}
-			}
-		} else if data["endpoint"] == "openai.fine_tuning" {
-			metrics := []string{
-				fmt.Sprintf(`doku_llm,environment=%v,endpoint=%v,applicationName=%v,source=%v,model=%v,finetuneJobId=%v requestDuration=%v`, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["finetuneJobId"], data["requestDuration"]),
-			}
-			var metricsBody = []byte(strings.Join(metrics, "\n"))
-			authHeader := fmt.Sprintf("Bearer %v:%v", grafanaPromUsername, grafanaAccessToken)
-			err := sendTelemetry(metricsBody, authHeader, grafanaPromUrl, "POST")
-			if err != nil {
-				log.Error().Err(err).Msgf("Error sending data to Grafana Cloud Prometheus")
-			}
-		} else if data["endpoint"] == "openai.images.create" || data["endpoint"] == "openai.images.create.variations" {
-			metrics := []string{
-				fmt.Sprintf(`doku_llm,environment=%v,endpoint=%v,applicationName=%v,source=%v,model=%v,imageSize=%v,imageQuality=%v requestDuration=%v`, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["imageSize"], data["imageQuality"], data["requestDuration"]),
-				fmt.Sprintf(`doku_llm,environment=%v,endpoint=%v,applicationName=%v,source=%v,model=%v,imageSize=%v,imageQuality=%v usageCost=%v`, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["imageSize"], data["imageQuality"], data["usageCost"]),
-			}
-			var metricsBody = []byte(strings.Join(metrics, "\n"))
-			authHeader := fmt.Sprintf("Bearer %v:%v", grafanaPromUsername, grafanaAccessToken)
-			err := sendTelemetry(metricsBody, authHeader, grafanaPromUrl, "POST")
-			if err != nil {
-				log.Error().Err(err).Msgf("Error sending data to Grafana Cloud Prometheus")
-			}
-
-			authHeader = fmt.Sprintf("Bearer %v:%v", grafanaLokiUsername, grafanaAccessToken)
-			if data["endpoint"] != "openai.images.create.variations" {
-				prompt_log := []byte(fmt.Sprintf("{\"streams\": [{\"stream\": {\"environment\": \"%v\",\"endpoint\": \"%v\", \"applicationName\": \"%v\", \"source\": \"%v\", \"model\": \"%v\", \"type\": \"prompt\" }, \"values\": [[\"%s\", \"%v\"]]}]}", data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], strconv.FormatInt(time.Now().UnixNano(), 10), data["revisedPrompt"]))
-				if data["model"] == "dall-e-2" {
-					prompt_log = []byte(fmt.Sprintf("{\"streams\": [{\"stream\": {\"environment\": \"%v\",\"endpoint\": \"%v\", \"applicationName\": \"%v\", \"source\": \"%v\", \"model\": \"%v\", \"type\": \"prompt\" }, \"values\": [[\"%s\", \"%v\"]]}]}", data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], strconv.FormatInt(time.Now().UnixNano(), 10), data["prompt"]))
-				}
-				err = sendTelemetry(prompt_log, authHeader, grafanaLokiUrl, "POST")
-				if err != nil {
-					log.Error().Err(err).Msgf("Error sending data to Grafana Cloud Loki")
-				}
-			}
-			image_log := []byte(fmt.Sprintf("{\"streams\": [{\"stream\": {\"environment\": \"%v\",\"endpoint\": \"%v\", \"applicationName\": \"%v\", \"source\": \"%v\", \"model\": \"%v\", \"type\": \"image\" }, \"values\": [[\"%s\", \"%v\"]]}]}", data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], strconv.FormatInt(time.Now().UnixNano(), 10), data["image"]))
-			err = sendTelemetry(image_log, authHeader, grafanaLokiUrl, "POST")
-			if err != nil {
-				log.Error().Err(err).Msgf("Error sending data to Grafana Cloud Loki")
-			}
-		} else if data["endpoint"] == "openai.audio.speech.create" {
-			metrics := []string{
-				fmt.Sprintf(`doku_llm,environment=%v,endpoint=%v,applicationName=%v,source=%v,model=%v,audioVoice=%v requestDuration=%v`, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["audioVoice"], data["requestDuration"]),
-				fmt.Sprintf(`doku_llm,environment=%v,endpoint=%v,applicationName=%v,source=%v,model=%v,audioVoice=%v usageCost=%v`, data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], data["audioVoice"], data["usageCost"]),
-			}
-			var metricsBody = []byte(strings.Join(metrics, "\n"))
-			authHeader := fmt.Sprintf("Bearer %v:%v", grafanaPromUsername, grafanaAccessToken)
-			err := sendTelemetry(metricsBody, authHeader, grafanaPromUrl, "POST")
-			if err != nil {
-				log.Error().Err(err).Msgf("Error sending data to Grafana Cloud Prometheus")
-			}
-
-			authHeader = fmt.Sprintf("Bearer %v:%v", grafanaLokiUsername, grafanaAccessToken)
-			prompt_log := []byte(fmt.Sprintf("{\"streams\": [{\"stream\": {\"environment\": \"%v\",\"endpoint\": \"%v\", \"applicationName\": \"%v\", \"source\": \"%v\", \"model\": \"%v\", \"type\": \"prompt\" }, \"values\": [[\"%s\", \"%v\"]]}]}", data["environment"], data["endpoint"], data["applicationName"], data["sourceLanguage"], data["model"], strconv.FormatInt(time.Now().UnixNano(), 10), data["prompt"]))
-			err = sendTelemetry(prompt_log, authHeader, grafanaLokiUrl, "POST")
-			if err != nil {
-				log.Error().Err(err).Msgf("Error sending data to Grafana Cloud Loki")
-			}
+// logMessage returns the normalized message of a log exported for a record, or an empty string
+// when none of its fields is set.
+func logMessage(data record.Record, logField record.LogField) string {
+	for _, field := range logField.Fields {
+		if value := data.String(field); value != "" {
+			return normalizeString(value)
 		}
-	} else if newRelicMetricsUrl != "" {
-		configureNewRelicData(data)
-	} else {
-		log.Info().Msg("No Observability Platform configured")
 	}
+	return ""
 }
 
-func sendTelemetry(telemetryData []byte, authHeader string, url string, requestType string) error {
+// Init creates every registered exporter that has a configuration block defined.
+func Init(cfg config.Configuration) error {
+	httpClient = &http.Client{Timeout: 5 * time.Second}
+	exporters = nil
 
-	req, err := http.NewRequest(requestType, url, bytes.NewBuffer(telemetryData))
-	if err != nil {
-		return fmt.Errorf("Error creating request")
+	var unknown []string
+	for name := range cfg.ObservabilityPlatform.Unknown {
+		unknown = append(unknown, name)
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", authHeader)
-
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("Error sending request to %v", url)
-	} else if resp.StatusCode == 404 {
-		return fmt.Errorf("Provided URL %v is not valid", url)
-	} else if resp.StatusCode == 401 {
-		return fmt.Errorf("Provided credentials are not valid")
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("Unknown observability platform '%s'", strings.Join(unknown, "', '"))
 	}
 
-	defer resp.Body.Close()
+	var names []string
+	for _, entry := range registry {
+		exporter, err := entry.factory(cfg)
+		if err != nil {
+			return fmt.Errorf("Error configuring the %s exporter: %w", entry.name, err)
+		}
+		if exporter == nil {
+			continue
+		}
+		exporters = append(exporters, exporter)
+		names = append(names, exporter.Name())
+	}
 
-	log.Info().Msgf("Successfully exported data to %v", url)
+	if len(exporters) == 0 {
+		log.Warn().Msg("Observability Platform is enabled but no platform is configured")
+	}
+	ObservabilityPlatform = strings.Join(names, ", ")
 	return nil
 }
+
+// SendToPlatform sends observability data to every configured platform.
+func SendToPlatform(data record.Record) {
+	if len(exporters) == 0 {
+		log.Info().Msg("No Observability Platform configured")
+		return
+	}
+
+	for _, exporter := range exporters {
+		if err := exporter.Export(data); err != nil {
+			metrics.ExportFailures.Inc(exporter.Name())
+			log.Error().Err(err).Msgf("Error exporting data to %s", exporter.Name())
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package obsPlatform
+
+import (
+	"strings"
+	"testing"
+
+	"ingester/config"
+)
+
+// configuredNames returns the names of the exporters configured by Init.
+func configuredNames() []string {
+	var names []string
+	for _, exporter := range exporters {
+		names = append(names, exporter.Name())
+	}
+	return names
+}
+
+func TestInitConfiguresRegisteredExporters(t *testing.T) {
+	grafana, newRelic, otlpCfg, all := config.Configuration{}, config.Configuration{}, config.Configuration{}, config.Configuration{}
+	grafana.ObservabilityPlatform.GrafanaCloud.LokiURL = "http://loki"
+	newRelic.ObservabilityPlatform.NewRelic.Key = "key"
+	otlpCfg.ObservabilityPlatform.OTLP.Endpoint = "http://collector"
+	all.ObservabilityPlatform.GrafanaCloud = grafana.ObservabilityPlatform.GrafanaCloud
+	all.ObservabilityPlatform.NewRelic = newRelic.ObservabilityPlatform.NewRelic
+	all.ObservabilityPlatform.OTLP = otlpCfg.ObservabilityPlatform.OTLP
+
+	tests := []struct {
+		name string
+		cfg  config.Configuration
+		want string
+	}{
+		{"grafana cloud", grafana, "Grafana Cloud"},
+		{"new relic", newRelic, "New Relic"},
+		{"otlp", otlpCfg, "OpenTelemetry"},
+		{"every platform", all, "Grafana Cloud, New Relic, OpenTelemetry"},
+		{"no platform", config.Configuration{}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := Init(test.cfg); err != nil {
+				t.Fatalf("Init() error = %v", err)
+			}
+			if got := strings.Join(configuredNames(), ", "); got != test.want || ObservabilityPlatform != test.want {
+				t.Errorf("exporters = %q, platform = %q, want %q", got, ObservabilityPlatform, test.want)
+			}
+		})
+	}
+}
+
+func TestInitRejectsUnknownPlatform(t *testing.T) {
+	var cfg config.Configuration
+	cfg.ObservabilityPlatform.NewRelic.Key = "key"
+	cfg.ObservabilityPlatform.Unknown = map[string]interface{}{"datadog": map[interface{}]interface{}{"key": "key"}}
+
+	err := Init(cfg)
+	if err == nil || !strings.Contains(err.Error(), "'datadog'") {
+		t.Errorf("Init() error = %v, want the unknown platform reported", err)
+	}
+}
+
+func TestRegisterExporterTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("RegisterExporter() did not panic for a name already registered")
+		}
+	}()
+	RegisterExporter("New Relic", newNewRelicExporter)
+}
@@ -0,0 +1,343 @@
+package obsPlatform
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ingester/config"
+	"ingester/otlp"
+	"ingester/record"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// otlpScope is the instrumentation scope reported for all OTLP telemetry sent by the ingester.
+var otlpScope = otlp.InstrumentationScope{Name: "doku-ingester"}
+
+var (
+	// otlpTokenBuckets are the bucket boundaries recommended by the GenAI semantic conventions for token usage.
+	otlpTokenBuckets = []float64{1, 4, 16, 64, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216, 67108864}
+	// otlpDurationBuckets are the bucket boundaries recommended by the GenAI semantic conventions for operation duration.
+	otlpDurationBuckets = []float64{0.01, 0.02, 0.04, 0.08, 0.16, 0.32, 0.64, 1.28, 2.56, 5.12, 10.24, 20.48, 40.96, 81.92}
+)
+
+// otlpExporter sends metrics, logs and spans to an OpenTelemetry collector over OTLP/HTTP with JSON encoding.
+type otlpExporter struct {
+	endpoint string            // endpoint is the base URL of the collector, the signal paths are appended to it.
+	headers  map[string]string // headers are added to every export request, for example for authentication.
+}
+
+func init() {
+	RegisterExporter("OpenTelemetry", newOTLPExporter)
+}
+
+// newOTLPExporter creates the OTLP exporter if the 'otlp' block is configured.
+func newOTLPExporter(cfg config.Configuration) (Exporter, error) {
+	otlpCfg := cfg.ObservabilityPlatform.OTLP
+	if otlpCfg.Endpoint == "" {
+		return nil, nil
+	}
+
+	return &otlpExporter{
+		endpoint: strings.TrimSuffix(otlpCfg.Endpoint, "/"),
+		headers:  otlpCfg.Headers,
+	}, nil
+}
+
+// Name returns the name of the OTLP exporter.
+func (e *otlpExporter) Name() string {
+	return "OpenTelemetry"
+}
+
+// Export sends the record as a span, as metrics and as log records to the collector.
+func (e *otlpExporter) Export(data record.Record) error {
+	var errs []error
+
+	start, end := otlpSpanTimes(data)
+
+	traceID, spanID := newTraceID(), newSpanID()
+	resource := otlpResource(data)
+	attributes := otlpMetricAttributes(data)
+
+	span := otlp.Span{
+		TraceID:           traceID,
+		SpanID:            spanID,
+		Name:              strings.TrimSpace(fmt.Sprintf("%s %s", otlpOperationName(data.Endpoint), data.Model)),
+		Kind:              otlp.SpanKindClient,
+		StartTimeUnixNano: otlp.Uint64(start.UnixNano()),
+		EndTimeUnixNano:   otlp.Uint64(end.UnixNano()),
+		Attributes:        otlpSpanAttributes(data),
+	}
+	traces := otlp.ExportTraceServiceRequest{
+		ResourceSpans: []otlp.ResourceSpans{{
+			Resource:   resource,
+			ScopeSpans: []otlp.ScopeSpans{{Scope: otlpScope, Spans: []otlp.Span{span}}},
+		}},
+	}
+	if err := e.send("/v1/traces", traces); err != nil {
+		errs = append(errs, fmt.Errorf("Error sending spans to the OpenTelemetry collector: %w", err))
+	}
+
+	metrics := otlp.ExportMetricsServiceRequest{
+		ResourceMetrics: []otlp.ResourceMetrics{{
+			Resource:     resource,
+			ScopeMetrics: []otlp.ScopeMetrics{{Scope: otlpScope, Metrics: otlpMetrics(data, attributes, start, end)}},
+		}},
+	}
+	if err := e.send("/v1/metrics", metrics); err != nil {
+		errs = append(errs, fmt.Errorf("Error sending metrics to the OpenTelemetry collector: %w", err))
+	}
+
+	if records := otlpLogRecords(data, traceID, spanID, end); len(records) > 0 {
+		logs := otlp.ExportLogsServiceRequest{
+			ResourceLogs: []otlp.ResourceLogs{{
+				Resource:  resource,
+				ScopeLogs: []otlp.ScopeLogs{{Scope: otlpScope, LogRecords: records}},
+			}},
+		}
+		if err := e.send("/v1/logs", logs); err != nil {
+			errs = append(errs, fmt.Errorf("Error sending logs to the OpenTelemetry collector: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// send posts an OTLP/HTTP JSON payload to the given signal path of the collector.
+func (e *otlpExporter) send(path string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("Error encoding OTLP payload: %w", err)
+	}
+
+	url := e.endpoint + path
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("Error creating request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Error sending request to %v", url)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode == 401 || resp.StatusCode == 403 {
+		return fmt.Errorf("Provided credentials are not valid")
+	} else if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Collector at %v responded with status %d", url, resp.StatusCode)
+	}
+
+	log.Info().Msgf("Successfully exported data to %v", url)
+	return nil
+}
+
+// otlpSpanTimes returns when the request of a record started and ended. The record time is when
+// the request was made, records without one are taken to have ended when they are exported.
+func otlpSpanTimes(data record.Record) (time.Time, time.Time) {
+	var duration time.Duration
+	if data.RequestDuration != nil {
+		duration = time.Duration(*data.RequestDuration * float64(time.Second))
+	}
+	if data.Time != nil {
+		return *data.Time, data.Time.Add(duration)
+	}
+	end := time.Now()
+	return end.Add(-duration), end
+}
+
+// otlpResource describes the LLM application that produced the record.
+func otlpResource(data record.Record) otlp.Resource {
+	return otlp.Resource{Attributes: []otlp.KeyValue{
+		otlp.String("service.name", data.ApplicationName),
+		otlp.String("deployment.environment", data.Environment),
+		otlp.String("telemetry.sdk.language", data.SourceLanguage),
+	}}
+}
+
+// otlpMetricAttributes returns the GenAI semantic convention attributes used to identify metrics.
+func otlpMetricAttributes(data record.Record) []otlp.KeyValue {
+	attributes := []otlp.KeyValue{
+		otlp.String("gen_ai.system", strings.SplitN(data.Endpoint, ".", 2)[0]),
+		otlp.String("gen_ai.operation.name", otlpOperationName(data.Endpoint)),
+		otlp.String("doku.endpoint", data.Endpoint),
+	}
+	if data.Model != "" {
+		attributes = append(attributes, otlp.String("gen_ai.request.model", data.Model), otlp.String("gen_ai.response.model", data.Model))
+	}
+	return attributes
+}
+
+// otlpSpanAttributes returns the GenAI semantic convention attributes of the span of the record.
+func otlpSpanAttributes(data record.Record) []otlp.KeyValue {
+	attributes := otlpMetricAttributes(data)
+	if data.PromptTokens != nil {
+		attributes = append(attributes, otlp.Int("gen_ai.usage.input_tokens", int64(*data.PromptTokens)))
+	}
+	if data.CompletionTokens != nil {
+		attributes = append(attributes, otlp.Int("gen_ai.usage.output_tokens", int64(*data.CompletionTokens)))
+	}
+	if data.CacheReadTokens != nil {
+		attributes = append(attributes, otlp.Int("gen_ai.usage.cache_read_input_tokens", int64(*data.CacheReadTokens)))
+	}
+	if data.CacheWriteTokens != nil {
+		attributes = append(attributes, otlp.Int("gen_ai.usage.cache_creation_input_tokens", int64(*data.CacheWriteTokens)))
+	}
+	if data.FinishReason != "" && data.FinishReason != "null" {
+		attributes = append(attributes, otlp.StringSlice("gen_ai.response.finish_reasons", []string{data.FinishReason}))
+	}
+	if data.UsageCost != nil {
+		attributes = append(attributes, otlp.Double("doku.usage.cost", *data.UsageCost))
+	}
+	return attributes
+}
+
+// otlpMetrics returns the token usage, duration and cost metrics of the record.
+func otlpMetrics(data record.Record, attributes []otlp.KeyValue, start, end time.Time) []otlp.Metric {
+	var tokenPoints []otlp.HistogramDataPoint
+	if data.PromptTokens != nil {
+		tokenPoints = append(tokenPoints, histogramPoint(withAttribute(attributes, otlp.String("gen_ai.token.type", "input")), float64(*data.PromptTokens), otlpTokenBuckets, start, end))
+	}
+	if data.CompletionTokens != nil {
+		tokenPoints = append(tokenPoints, histogramPoint(withAttribute(attributes, otlp.String("gen_ai.token.type", "output")), float64(*data.CompletionTokens), otlpTokenBuckets, start, end))
+	}
+
+	var metrics []otlp.Metric
+	if len(tokenPoints) > 0 {
+		metrics = append(metrics, otlp.Metric{
+			Name:        "gen_ai.client.token.usage",
+			Description: "Measures number of input and output tokens used",
+			Unit:        "{token}",
+			Histogram:   &otlp.Histogram{DataPoints: tokenPoints, AggregationTemporality: otlp.AggregationTemporalityDelta},
+		})
+	}
+	if data.RequestDuration != nil {
+		metrics = append(metrics, otlp.Metric{
+			Name:        "gen_ai.client.operation.duration",
+			Description: "GenAI operation duration",
+			Unit:        "s",
+			Histogram: &otlp.Histogram{
+				DataPoints:             []otlp.HistogramDataPoint{histogramPoint(attributes, *data.RequestDuration, otlpDurationBuckets, start, end)},
+				AggregationTemporality: otlp.AggregationTemporalityDelta,
+			},
+		})
+	}
+	if data.UsageCost != nil {
+		metrics = append(metrics, otlp.Metric{
+			Name:        "doku.llm.usage.cost",
+			Description: "Cost of the LLM request",
+			Unit:        "USD",
+			Sum: &otlp.Sum{
+				DataPoints: []otlp.NumberDataPoint{{
+					Attributes:        attributes,
+					StartTimeUnixNano: otlp.Uint64(start.UnixNano()),
+					TimeUnixNano:      otlp.Uint64(end.UnixNano()),
+					AsDouble:          data.UsageCost,
+				}},
+				AggregationTemporality: otlp.AggregationTemporalityDelta,
+				IsMonotonic:            true,
+			},
+		})
+	}
+	return metrics
+}
+
+// otlpLogRecords returns the prompt, response and image of the record as log records linked to its span.
+func otlpLogRecords(data record.Record, traceID, spanID string, timestamp time.Time) []otlp.LogRecord {
+	contents := []struct {
+		message   string
+		logType   string
+		eventName string
+	}{
+		{data.Prompt, "prompt", "gen_ai.content.prompt"},
+		{data.RevisedPrompt, "revisedPrompt", "gen_ai.content.prompt"},
+		{data.Response, "response", "gen_ai.content.completion"},
+		{data.Image, "image", "gen_ai.content.completion"},
+	}
+
+	var records []otlp.LogRecord
+	for _, content := range contents {
+		message := content.message
+		if message == "" {
+			continue
+		}
+		records = append(records, otlp.LogRecord{
+			TimeUnixNano:         otlp.Uint64(timestamp.UnixNano()),
+			ObservedTimeUnixNano: otlp.Uint64(timestamp.UnixNano()),
+			SeverityNumber:       otlp.SeverityNumberInfo,
+			SeverityText:         "INFO",
+			Body:                 otlp.StringValue(message),
+			Attributes: []otlp.KeyValue{
+				otlp.String("event.name", content.eventName),
+				otlp.String("type", content.logType),
+				otlp.String("doku.endpoint", data.Endpoint),
+				otlp.String("gen_ai.request.model", data.Model),
+			},
+			TraceID: traceID,
+			SpanID:  spanID,
+		})
+	}
+	return records
+}
+
+// otlpOperationName maps a Doku endpoint to a GenAI semantic convention operation name.
+func otlpOperationName(name string) string {
+	if endpoint, ok := record.LookupEndpoint(name); ok && endpoint.Operation != "" {
+		return endpoint.Operation
+	}
+	return name
+}
+
+// histogramPoint returns a histogram data point holding a single observation.
+func histogramPoint(attributes []otlp.KeyValue, value float64, bounds []float64, start, end time.Time) otlp.HistogramDataPoint {
+	counts := make([]otlp.Uint64, len(bounds)+1)
+	bucket := len(bounds)
+	for i, bound := range bounds {
+		if value <= bound {
+			bucket = i
+			break
+		}
+	}
+	counts[bucket] = 1
+
+	return otlp.HistogramDataPoint{
+		Attributes:        attributes,
+		StartTimeUnixNano: otlp.Uint64(start.UnixNano()),
+		TimeUnixNano:      otlp.Uint64(end.UnixNano()),
+		Count:             1,
+		Sum:               &value,
+		BucketCounts:      counts,
+		ExplicitBounds:    bounds,
+	}
+}
+
+// withAttribute returns a copy of the attributes with one more attribute appended.
+func withAttribute(attributes []otlp.KeyValue, attribute otlp.KeyValue) []otlp.KeyValue {
+	return append(append([]otlp.KeyValue{}, attributes...), attribute)
+}
+
+// newTraceID returns a random hex encoded 16 byte trace ID.
+func newTraceID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// newSpanID returns a random hex encoded 8 byte span ID.
+func newSpanID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,224 @@
+package obsPlatform
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"ingester/config"
+	"ingester/otlp"
+	"ingester/record"
+)
+
+// collector is a stand-in OpenTelemetry collector that keeps the bodies it receives by path.
+type collector struct {
+	mu       sync.Mutex
+	payloads map[string][]byte
+	headers  http.Header
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	c.mu.Lock()
+	c.payloads[r.URL.Path] = body
+	c.headers = r.Header.Clone()
+	c.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+// decode decodes the payload received on a path into v.
+func (c *collector) decode(t *testing.T, path string, v interface{}) {
+	t.Helper()
+	c.mu.Lock()
+	body, ok := c.payloads[path]
+	c.mu.Unlock()
+	if !ok {
+		t.Fatalf("collector did not receive %s", path)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		t.Fatalf("decoding %s: %v", path, err)
+	}
+}
+
+// attribute returns the attribute with the given key, failing the test when it is missing.
+func attribute(t *testing.T, attributes []otlp.KeyValue, key string) otlp.AnyValue {
+	t.Helper()
+	for _, attribute := range attributes {
+		if attribute.Key == key {
+			return attribute.Value
+		}
+	}
+	t.Fatalf("attribute %s is missing from %+v", key, attributes)
+	return otlp.AnyValue{}
+}
+
+func newTestOTLPExporter(t *testing.T) (*otlpExporter, *collector) {
+	t.Helper()
+	c := &collector{payloads: map[string][]byte{}}
+	server := httptest.NewServer(c)
+	t.Cleanup(server.Close)
+	httpClient = server.Client()
+
+	var cfg config.Configuration
+	cfg.ObservabilityPlatform.OTLP.Endpoint = server.URL + "/"
+	cfg.ObservabilityPlatform.OTLP.Headers = map[string]string{"Authorization": "Bearer token"}
+	exporter, err := newOTLPExporter(cfg)
+	if err != nil || exporter == nil {
+		t.Fatalf("newOTLPExporter() = %v, %v", exporter, err)
+	}
+	return exporter.(*otlpExporter), c
+}
+
+func testChatRecord() record.Record {
+	promptTokens, completionTokens, cacheReadTokens := 100, 20, 40
+	duration, usageCost := 1.5, 0.25
+	eventTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	return record.Record{
+		Time: &eventTime, Environment: "prod", Endpoint: "openai.chat.completions", SourceLanguage: "python",
+		ApplicationName: "chatbot", Model: "gpt-4", PromptTokens: &promptTokens, CompletionTokens: &completionTokens,
+		CacheReadTokens: &cacheReadTokens, RequestDuration: &duration, UsageCost: &usageCost, FinishReason: "stop",
+		Prompt: "Hello", Response: "Hi there",
+	}
+}
+
+func TestOTLPExportTraces(t *testing.T) {
+	exporter, c := newTestOTLPExporter(t)
+	data := testChatRecord()
+	if err := exporter.Export(data); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if got := c.headers.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("Authorization header = %q, want the configured header", got)
+	}
+
+	var traces otlp.ExportTraceServiceRequest
+	c.decode(t, "/v1/traces", &traces)
+	resource := traces.ResourceSpans[0].Resource
+	if got := *attribute(t, resource.Attributes, "service.name").StringValue; got != "chatbot" {
+		t.Errorf("service.name = %q, want chatbot", got)
+	}
+
+	span := traces.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if span.Name != "chat gpt-4" || span.Kind != otlp.SpanKindClient {
+		t.Errorf("span = %q kind %d, want 'chat gpt-4' of kind client", span.Name, span.Kind)
+	}
+	if want := otlp.Uint64(data.Time.UnixNano()); span.StartTimeUnixNano != want {
+		t.Errorf("span start = %d, want the record time %d", span.StartTimeUnixNano, want)
+	}
+	if want := otlp.Uint64(data.Time.Add(1500 * time.Millisecond).UnixNano()); span.EndTimeUnixNano != want {
+		t.Errorf("span end = %d, want the record time plus its duration %d", span.EndTimeUnixNano, want)
+	}
+
+	for key, want := range map[string]string{"gen_ai.system": "openai", "gen_ai.operation.name": "chat", "gen_ai.request.model": "gpt-4"} {
+		if got := *attribute(t, span.Attributes, key).StringValue; got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	for key, want := range map[string]otlp.Int64{"gen_ai.usage.input_tokens": 100, "gen_ai.usage.output_tokens": 20, "gen_ai.usage.cache_read_input_tokens": 40} {
+		if got := *attribute(t, span.Attributes, key).IntValue; got != want {
+			t.Errorf("%s = %d, want %d", key, got, want)
+		}
+	}
+	if got := attribute(t, span.Attributes, "gen_ai.response.finish_reasons").ArrayValue; got == nil || *got.Values[0].StringValue != "stop" {
+		t.Errorf("gen_ai.response.finish_reasons = %+v, want [stop]", got)
+	}
+}
+
+func TestOTLPExportMetrics(t *testing.T) {
+	exporter, c := newTestOTLPExporter(t)
+	data := testChatRecord()
+	if err := exporter.Export(data); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+
+	var metrics otlp.ExportMetricsServiceRequest
+	c.decode(t, "/v1/metrics", &metrics)
+	byName := map[string]otlp.Metric{}
+	for _, metric := range metrics.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		byName[metric.Name] = metric
+	}
+
+	tokens, ok := byName["gen_ai.client.token.usage"]
+	if !ok || tokens.Histogram == nil || len(tokens.Histogram.DataPoints) != 2 {
+		t.Fatalf("gen_ai.client.token.usage = %+v, want an input and an output data point", tokens)
+	}
+	input := tokens.Histogram.DataPoints[0]
+	if *attribute(t, input.Attributes, "gen_ai.token.type").StringValue != "input" || *input.Sum != 100 || input.Count != 1 {
+		t.Errorf("input token data point = %+v, want a single observation of 100", input)
+	}
+	if want := otlp.Uint64(data.Time.Add(1500 * time.Millisecond).UnixNano()); input.TimeUnixNano != want {
+		t.Errorf("data point time = %d, want the end of the request %d", input.TimeUnixNano, want)
+	}
+
+	if duration, ok := byName["gen_ai.client.operation.duration"]; !ok || *duration.Histogram.DataPoints[0].Sum != 1.5 {
+		t.Errorf("gen_ai.client.operation.duration = %+v, want 1.5s", duration)
+	}
+	usageCost, ok := byName["doku.llm.usage.cost"]
+	if !ok || usageCost.Sum == nil || !usageCost.Sum.IsMonotonic || *usageCost.Sum.DataPoints[0].AsDouble != 0.25 {
+		t.Errorf("doku.llm.usage.cost = %+v, want a monotonic sum of 0.25", usageCost)
+	}
+}
+
+func TestOTLPExportLogs(t *testing.T) {
+	exporter, c := newTestOTLPExporter(t)
+	if err := exporter.Export(testChatRecord()); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+
+	var traces otlp.ExportTraceServiceRequest
+	c.decode(t, "/v1/traces", &traces)
+	span := traces.ResourceSpans[0].ScopeSpans[0].Spans[0]
+
+	var logs otlp.ExportLogsServiceRequest
+	c.decode(t, "/v1/logs", &logs)
+	records := logs.ResourceLogs[0].ScopeLogs[0].LogRecords
+	if len(records) != 2 {
+		t.Fatalf("got %d log records, want the prompt and the response", len(records))
+	}
+	for i, want := range []struct{ body, eventName string }{{"Hello", "gen_ai.content.prompt"}, {"Hi there", "gen_ai.content.completion"}} {
+		if *records[i].Body.StringValue != want.body || *attribute(t, records[i].Attributes, "event.name").StringValue != want.eventName {
+			t.Errorf("log record %d = %+v, want %q with event %q", i, records[i], want.body, want.eventName)
+		}
+		if records[i].TraceID != span.TraceID || records[i].SpanID != span.SpanID {
+			t.Errorf("log record %d is not linked to the span", i)
+		}
+	}
+}
+
+func TestOTLPExportWithoutContent(t *testing.T) {
+	exporter, c := newTestOTLPExporter(t)
+	data := testChatRecord()
+	data.Prompt, data.Response = "", ""
+	if err := exporter.Export(data); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if _, ok := c.payloads["/v1/logs"]; ok {
+		t.Error("logs were exported for a record without content")
+	}
+}
+
+func TestOTLPExportCollectorError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	httpClient = server.Client()
+
+	exporter := &otlpExporter{endpoint: server.URL}
+	if err := exporter.Export(testChatRecord()); err == nil {
+		t.Fatal("Export() error = nil, want the collector errors")
+	}
+}
+
+func TestOTLPSpanTimesWithoutRecordTime(t *testing.T) {
+	duration := 2.0
+	before := time.Now()
+	start, end := otlpSpanTimes(record.Record{RequestDuration: &duration})
+	if end.Before(before) || end.Sub(start) != 2*time.Second {
+		t.Errorf("otlpSpanTimes() = %v, %v, want a 2s span ending now", start, end)
+	}
+}
@@ -0,0 +1,211 @@
+package otlp
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"ingester/record"
+)
+
+// ExportTraceServiceResponse is the body of the response to an OTLP/HTTP trace export.
+type ExportTraceServiceResponse struct {
+	PartialSuccess *ExportTracePartialSuccess `json:"partialSuccess,omitempty"`
+}
+
+// ExportTracePartialSuccess reports the spans that were rejected from a trace export.
+type ExportTracePartialSuccess struct {
+	RejectedSpans Int64  `json:"rejectedSpans,omitempty"`
+	ErrorMessage  string `json:"errorMessage,omitempty"`
+}
+
+// legacyOperationNames maps the 'llm.request.type' values of older instrumentations to operation names.
+var legacyOperationNames = map[string]string{
+	"chat":       "chat",
+	"completion": "text_completion",
+	"embedding":  "embeddings",
+}
+
+// attributeMap gives access to attributes by key.
+type attributeMap map[string]AnyValue
+
+// newAttributeMap indexes a list of attributes by key.
+func newAttributeMap(attributes []KeyValue) attributeMap {
+	m := make(attributeMap, len(attributes))
+	for _, attribute := range attributes {
+		m[attribute.Key] = attribute.Value
+	}
+	return m
+}
+
+// str returns the first of the keys holding a non-empty string value.
+func (m attributeMap) str(keys ...string) (string, bool) {
+	for _, key := range keys {
+		value, ok := m[key]
+		if !ok {
+			continue
+		}
+		if value.StringValue != nil && *value.StringValue != "" {
+			return *value.StringValue, true
+		}
+		if value.ArrayValue != nil && len(value.ArrayValue.Values) > 0 && value.ArrayValue.Values[0].StringValue != nil {
+			return *value.ArrayValue.Values[0].StringValue, true
+		}
+	}
+	return "", false
+}
+
+// num returns the first of the keys holding a numeric value.
+func (m attributeMap) num(keys ...string) (float64, bool) {
+	for _, key := range keys {
+		value, ok := m[key]
+		if !ok {
+			continue
+		}
+		if value.IntValue != nil {
+			return float64(*value.IntValue), true
+		}
+		if value.DoubleValue != nil {
+			return *value.DoubleValue, true
+		}
+	}
+	return 0, false
+}
+
+// GenAIRecords maps the GenAI spans of a trace export onto Doku records. Spans without a
+// 'gen_ai.system' attribute are ignored, GenAI spans that cannot be stored are counted as
+// rejected and described in the returned message.
+func GenAIRecords(request *ExportTraceServiceRequest) ([]record.Record, int, string) {
+	var records []record.Record
+	var rejected int
+	var messages []string
+
+	for _, resourceSpans := range request.ResourceSpans {
+		resource := newAttributeMap(resourceSpans.Resource.Attributes)
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			for _, span := range scopeSpans.Spans {
+				attributes := newAttributeMap(span.Attributes)
+				if _, ok := attributes.str("gen_ai.system"); !ok {
+					continue
+				}
+
+				data, err := genAIRecord(resource, attributes, span)
+				if err != nil {
+					rejected++
+					messages = append(messages, fmt.Sprintf("span %s: %v", span.SpanID, err))
+					continue
+				}
+				records = append(records, data)
+			}
+		}
+	}
+
+	return records, rejected, strings.Join(messages, "; ")
+}
+
+// genAIRecord maps a single GenAI span onto a Doku record.
+func genAIRecord(resource, attributes attributeMap, span Span) (record.Record, error) {
+	system, _ := attributes.str("gen_ai.system")
+	operation, ok := attributes.str("gen_ai.operation.name")
+	if !ok {
+		requestType, _ := attributes.str("llm.request.type")
+		operation = legacyOperationNames[requestType]
+	}
+
+	endpoint, ok := record.SpanEndpoint(strings.ToLower(system), operation)
+	if !ok {
+		return record.Record{}, fmt.Errorf("unsupported GenAI system '%s' and operation '%s'", system, operation)
+	}
+
+	model, ok := attributes.str("gen_ai.response.model", "gen_ai.request.model")
+	if !ok {
+		return record.Record{}, fmt.Errorf("missing 'gen_ai.request.model' attribute")
+	}
+
+	data := record.Record{
+		Endpoint:        endpoint,
+		Model:           model,
+		Environment:     "default",
+		ApplicationName: "default",
+		SourceLanguage:  "unknown",
+	}
+	if environment, ok := resource.str("deployment.environment.name", "deployment.environment"); ok {
+		data.Environment = environment
+	}
+	if applicationName, ok := resource.str("service.name"); ok {
+		data.ApplicationName = applicationName
+	}
+	if language, ok := resource.str("telemetry.sdk.language"); ok {
+		data.SourceLanguage = language
+	}
+	if region, ok := resource.str("cloud.region"); ok {
+		data.Region = region
+	}
+
+	promptTokens, hasPrompt := attributes.num("gen_ai.usage.input_tokens", "gen_ai.usage.prompt_tokens")
+	if hasPrompt {
+		data.PromptTokens = intPointer(promptTokens)
+	}
+	completionTokens, hasCompletion := attributes.num("gen_ai.usage.output_tokens", "gen_ai.usage.completion_tokens")
+	if hasCompletion {
+		data.CompletionTokens = intPointer(completionTokens)
+	}
+	if cacheReadTokens, ok := attributes.num("gen_ai.usage.cache_read_input_tokens"); ok {
+		data.CacheReadTokens = intPointer(cacheReadTokens)
+	}
+	if cacheWriteTokens, ok := attributes.num("gen_ai.usage.cache_creation_input_tokens"); ok {
+		data.CacheWriteTokens = intPointer(cacheWriteTokens)
+	}
+	if totalTokens, ok := attributes.num("llm.usage.total_tokens"); ok {
+		data.TotalTokens = intPointer(totalTokens)
+	} else if hasPrompt || hasCompletion {
+		data.TotalTokens = intPointer(promptTokens + completionTokens)
+	}
+
+	if finishReason, ok := attributes.str("gen_ai.response.finish_reasons", "gen_ai.completion.0.finish_reason"); ok {
+		data.FinishReason = finishReason
+	}
+	if span.StartTimeUnixNano > 0 {
+		startTime := time.Unix(0, int64(span.StartTimeUnixNano))
+		data.Time = &startTime
+	}
+	if span.EndTimeUnixNano > span.StartTimeUnixNano {
+		requestDuration := float64(span.EndTimeUnixNano-span.StartTimeUnixNano) / 1e9
+		data.RequestDuration = &requestDuration
+	}
+
+	// Prompts and completions are either span attributes or attributes of span events
+	events := attributeMap{}
+	for _, event := range span.Events {
+		for key, value := range newAttributeMap(event.Attributes) {
+			events[key] = value
+		}
+	}
+	if prompt, ok := attributes.str("gen_ai.prompt", "gen_ai.prompt.0.content"); ok {
+		data.Prompt = prompt
+	} else if prompt, ok := events.str("gen_ai.prompt"); ok {
+		data.Prompt = prompt
+	}
+	if completion, ok := attributes.str("gen_ai.completion", "gen_ai.completion.0.content"); ok {
+		data.Response = completion
+	} else if completion, ok := events.str("gen_ai.completion"); ok {
+		data.Response = completion
+	}
+
+	// Embeddings are priced on the prompt tokens, which must be present for the cost calculation
+	if operation == "embeddings" && !hasPrompt {
+		return record.Record{}, fmt.Errorf("missing 'gen_ai.usage.input_tokens' attribute")
+	}
+
+	// Records are written asynchronously, so spans that would fail validation are rejected here
+	if err := data.Validate(); err != nil {
+		return record.Record{}, err
+	}
+	return data, nil
+}
+
+// intPointer returns a pointer to a token count read from a span attribute.
+func intPointer(value float64) *int {
+	n := int(value)
+	return &n
+}
@@ -0,0 +1,106 @@
+package otlp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func genAIRequest(resource []KeyValue, spans ...Span) *ExportTraceServiceRequest {
+	return &ExportTraceServiceRequest{ResourceSpans: []ResourceSpans{{
+		Resource:   Resource{Attributes: resource},
+		ScopeSpans: []ScopeSpans{{Spans: spans}},
+	}}}
+}
+
+func TestGenAIRecords(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	span := Span{
+		SpanID:            "0a",
+		StartTimeUnixNano: Uint64(start.UnixNano()),
+		EndTimeUnixNano:   Uint64(start.Add(2 * time.Second).UnixNano()),
+		Attributes: []KeyValue{
+			String("gen_ai.system", "openai"),
+			String("gen_ai.operation.name", "chat"),
+			String("gen_ai.request.model", "gpt-4"),
+			String("gen_ai.response.model", "gpt-4-0613"),
+			Int("gen_ai.usage.input_tokens", 100),
+			Int("gen_ai.usage.output_tokens", 20),
+			Int("gen_ai.usage.cache_read_input_tokens", 30),
+			StringSlice("gen_ai.response.finish_reasons", []string{"stop"}),
+		},
+		Events: []SpanEvent{{Name: "gen_ai.content.completion", Attributes: []KeyValue{String("gen_ai.completion", "Hi")}}},
+	}
+	resource := []KeyValue{String("service.name", "chatbot"), String("deployment.environment", "prod"),
+		String("telemetry.sdk.language", "python"), String("cloud.region", "eastus")}
+
+	records, rejected, message := GenAIRecords(genAIRequest(resource, span, Span{Attributes: []KeyValue{String("http.method", "GET")}}))
+	if len(records) != 1 || rejected != 0 || message != "" {
+		t.Fatalf("GenAIRecords() = %d records, %d rejected, %q, want 1 record and the other span ignored", len(records), rejected, message)
+	}
+
+	data := records[0]
+	if data.Endpoint != "openai.chat.completions" || data.Model != "gpt-4-0613" {
+		t.Errorf("endpoint, model = %q, %q, want openai.chat.completions, gpt-4-0613", data.Endpoint, data.Model)
+	}
+	if data.ApplicationName != "chatbot" || data.Environment != "prod" || data.SourceLanguage != "python" || data.Region != "eastus" {
+		t.Errorf("resource fields = %q, %q, %q, %q", data.ApplicationName, data.Environment, data.SourceLanguage, data.Region)
+	}
+	if *data.PromptTokens != 100 || *data.CompletionTokens != 20 || *data.TotalTokens != 120 || *data.CacheReadTokens != 30 {
+		t.Errorf("tokens = %d, %d, %d, %d, want 100, 20, 120, 30", *data.PromptTokens, *data.CompletionTokens, *data.TotalTokens, *data.CacheReadTokens)
+	}
+	if data.FinishReason != "stop" || data.Response != "Hi" {
+		t.Errorf("finish reason, response = %q, %q, want stop, Hi", data.FinishReason, data.Response)
+	}
+	if !data.Time.Equal(start) || *data.RequestDuration != 2 {
+		t.Errorf("time, duration = %v, %v, want the span start and 2s", data.Time, *data.RequestDuration)
+	}
+}
+
+func TestGenAIRecordsDefaultsAndAliases(t *testing.T) {
+	span := Span{Attributes: []KeyValue{
+		String("gen_ai.system", "az.ai.openai"),
+		String("llm.request.type", "embedding"),
+		String("gen_ai.request.model", "text-embedding-ada-002"),
+		Int("gen_ai.usage.prompt_tokens", 8),
+	}}
+
+	records, rejected, _ := GenAIRecords(genAIRequest(nil, span))
+	if len(records) != 1 || rejected != 0 {
+		t.Fatalf("GenAIRecords() = %d records, %d rejected, want 1 record", len(records), rejected)
+	}
+	data := records[0]
+	if data.Endpoint != "openai.embeddings" || *data.PromptTokens != 8 {
+		t.Errorf("endpoint, prompt tokens = %q, %d, want openai.embeddings, 8", data.Endpoint, *data.PromptTokens)
+	}
+	if data.ApplicationName != "default" || data.Environment != "default" || data.SourceLanguage != "unknown" || data.Time != nil {
+		t.Errorf("record = %+v, want the defaults for a span without resource attributes", data)
+	}
+}
+
+func TestGenAIRecordsRejected(t *testing.T) {
+	tests := []struct {
+		name       string
+		attributes []KeyValue
+		message    string
+	}{
+		{"unsupported system", []KeyValue{String("gen_ai.system", "acme"), String("gen_ai.operation.name", "chat"),
+			String("gen_ai.request.model", "m")}, "unsupported GenAI system"},
+		{"missing model", []KeyValue{String("gen_ai.system", "openai"), String("gen_ai.operation.name", "chat")}, "gen_ai.request.model"},
+		{"embeddings without tokens", []KeyValue{String("gen_ai.system", "openai"), String("gen_ai.operation.name", "embeddings"),
+			String("gen_ai.request.model", "text-embedding-ada-002")}, "gen_ai.usage.input_tokens"},
+		{"chat without tokens or text", []KeyValue{String("gen_ai.system", "openai"), String("gen_ai.operation.name", "chat"),
+			String("gen_ai.request.model", "gpt-4")}, "invalid"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			records, rejected, message := GenAIRecords(genAIRequest(nil, Span{SpanID: "0b", Attributes: test.attributes}))
+			if len(records) != 0 || rejected != 1 {
+				t.Fatalf("GenAIRecords() = %d records, %d rejected, want the span rejected", len(records), rejected)
+			}
+			if !strings.HasPrefix(message, "span 0b: ") || !strings.Contains(strings.ToLower(message), strings.ToLower(test.message)) {
+				t.Errorf("message = %q, want it to mention %q", message, test.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package otlp
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Aggregation temporalities of OTLP metric data points.
+const (
+	AggregationTemporalityDelta      = 1
+	AggregationTemporalityCumulative = 2
+)
+
+// Span kinds of OTLP spans.
+const (
+	SpanKindInternal = 1
+	SpanKindServer   = 2
+	SpanKindClient   = 3
+)
+
+// SeverityNumberInfo is the OTLP severity number for informational log records.
+const SeverityNumberInfo = 9
+
+// Uint64 is an unsigned integer that is encoded as a JSON string, as required by the
+// OTLP/HTTP JSON encoding for 64 bit fields. Both strings and numbers are accepted when decoding.
+type Uint64 uint64
+
+// MarshalJSON encodes the value as a JSON string.
+func (u Uint64) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strconv.FormatUint(uint64(u), 10))
+}
+
+// UnmarshalJSON decodes the value from a JSON string or number.
+func (u *Uint64) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n uint64
+		if err := json.Unmarshal(data, &n); err != nil {
+			return err
+		}
+		*u = Uint64(n)
+		return nil
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*u = Uint64(n)
+	return nil
+}
+
+// Int64 is a signed integer that is encoded as a JSON string, as required by the
+// OTLP/HTTP JSON encoding for 64 bit fields. Both strings and numbers are accepted when decoding.
+type Int64 int64
+
+// MarshalJSON encodes the value as a JSON string.
+func (i Int64) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strconv.FormatInt(int64(i), 10))
+}
+
+// UnmarshalJSON decodes the value from a JSON string or number.
+func (i *Int64) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n int64
+		if err := json.Unmarshal(data, &n); err != nil {
+			return err
+		}
+		*i = Int64(n)
+		return nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*i = Int64(n)
+	return nil
+}
+
+// AnyValue is the value of an OTLP attribute or log body, only one field is set.
+type AnyValue struct {
+	StringValue *string       `json:"stringValue,omitempty"`
+	BoolValue   *bool         `json:"boolValue,omitempty"`
+	IntValue    *Int64        `json:"intValue,omitempty"`
+	DoubleValue *float64      `json:"doubleValue,omitempty"`
+	ArrayValue  *ArrayValue   `json:"arrayValue,omitempty"`
+	KvlistValue *KeyValueList `json:"kvlistValue,omitempty"`
+	BytesValue  []byte        `json:"bytesValue,omitempty"`
+}
+
+// ArrayValue is a list of OTLP values.
+type ArrayValue struct {
+	Values []AnyValue `json:"values"`
+}
+
+// KeyValueList is a list of OTLP attributes nested in a value.
+type KeyValueList struct {
+	Values []KeyValue `json:"values"`
+}
+
+// KeyValue is an OTLP attribute.
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+// Resource describes the entity producing the telemetry.
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+// InstrumentationScope describes the library producing the telemetry.
+type InstrumentationScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// ExportTraceServiceRequest is the body of an OTLP/HTTP trace export.
+type ExportTraceServiceRequest struct {
+	ResourceSpans []ResourceSpans `json:"resourceSpans"`
+}
+
+// ResourceSpans is a collection of spans produced by a resource.
+type ResourceSpans struct {
+	Resource   Resource     `json:"resource"`
+	ScopeSpans []ScopeSpans `json:"scopeSpans"`
+}
+
+// ScopeSpans is a collection of spans produced by an instrumentation scope.
+type ScopeSpans struct {
+	Scope InstrumentationScope `json:"scope"`
+	Spans []Span               `json:"spans"`
+}
+
+// Span is a single OTLP span. Trace and span IDs are hex encoded.
+type Span struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano Uint64      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   Uint64      `json:"endTimeUnixNano"`
+	Attributes        []KeyValue  `json:"attributes"`
+	Events            []SpanEvent `json:"events,omitempty"`
+}
+
+// SpanEvent is a timestamped event recorded on a span.
+type SpanEvent struct {
+	TimeUnixNano Uint64     `json:"timeUnixNano"`
+	Name         string     `json:"name"`
+	Attributes   []KeyValue `json:"attributes"`
+}
+
+// ExportMetricsServiceRequest is the body of an OTLP/HTTP metrics export.
+type ExportMetricsServiceRequest struct {
+	ResourceMetrics []ResourceMetrics `json:"resourceMetrics"`
+}
+
+// ResourceMetrics is a collection of metrics produced by a resource.
+type ResourceMetrics struct {
+	Resource     Resource       `json:"resource"`
+	ScopeMetrics []ScopeMetrics `json:"scopeMetrics"`
+}
+
+// ScopeMetrics is a collection of metrics produced by an instrumentation scope.
+type ScopeMetrics struct {
+	Scope   InstrumentationScope `json:"scope"`
+	Metrics []Metric             `json:"metrics"`
+}
+
+// Metric is a single OTLP metric, only one of Sum, Gauge or Histogram is set.
+type Metric struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Unit        string     `json:"unit,omitempty"`
+	Sum         *Sum       `json:"sum,omitempty"`
+	Gauge       *Gauge     `json:"gauge,omitempty"`
+	Histogram   *Histogram `json:"histogram,omitempty"`
+}
+
+// Sum is a metric made of summed number data points.
+type Sum struct {
+	DataPoints             []NumberDataPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+// Gauge is a metric made of sampled number data points.
+type Gauge struct {
+	DataPoints []NumberDataPoint `json:"dataPoints"`
+}
+
+// NumberDataPoint is a single value of a Sum or Gauge metric.
+type NumberDataPoint struct {
+	Attributes        []KeyValue `json:"attributes"`
+	StartTimeUnixNano Uint64     `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      Uint64     `json:"timeUnixNano"`
+	AsDouble          *float64   `json:"asDouble,omitempty"`
+	AsInt             *Int64     `json:"asInt,omitempty"`
+}
+
+// Histogram is a metric made of explicit bucket histogram data points.
+type Histogram struct {
+	DataPoints             []HistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+}
+
+// HistogramDataPoint is a single distribution of a Histogram metric.
+type HistogramDataPoint struct {
+	Attributes        []KeyValue `json:"attributes"`
+	StartTimeUnixNano Uint64     `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      Uint64     `json:"timeUnixNano"`
+	Count             Uint64     `json:"count"`
+	Sum               *float64   `json:"sum,omitempty"`
+	BucketCounts      []Uint64   `json:"bucketCounts"`
+	ExplicitBounds    []float64  `json:"explicitBounds"`
+}
+
+// ExportLogsServiceRequest is the body of an OTLP/HTTP logs export.
+type ExportLogsServiceRequest struct {
+	ResourceLogs []ResourceLogs `json:"resourceLogs"`
+}
+
+// ResourceLogs is a collection of log records produced by a resource.
+type ResourceLogs struct {
+	Resource  Resource    `json:"resource"`
+	ScopeLogs []ScopeLogs `json:"scopeLogs"`
+}
+
+// ScopeLogs is a collection of log records produced by an instrumentation scope.
+type ScopeLogs struct {
+	Scope      InstrumentationScope `json:"scope"`
+	LogRecords []LogRecord          `json:"logRecords"`
+}
+
+// LogRecord is a single OTLP log record. Trace and span IDs are hex encoded.
+type LogRecord struct {
+	TimeUnixNano         Uint64     `json:"timeUnixNano"`
+	ObservedTimeUnixNano Uint64     `json:"observedTimeUnixNano"`
+	SeverityNumber       int        `json:"severityNumber"`
+	SeverityText         string     `json:"severityText,omitempty"`
+	Body                 AnyValue   `json:"body"`
+	Attributes           []KeyValue `json:"attributes"`
+	TraceID              string     `json:"traceId,omitempty"`
+	SpanID               string     `json:"spanId,omitempty"`
+}
+
+// String returns a string attribute.
+func String(key, value string) KeyValue {
+	return KeyValue{Key: key, Value: StringValue(value)}
+}
+
+// StringValue returns a string value.
+func StringValue(value string) AnyValue {
+	return AnyValue{StringValue: &value}
+}
+
+// Int returns an integer attribute.
+func Int(key string, value int64) KeyValue {
+	v := Int64(value)
+	return KeyValue{Key: key, Value: AnyValue{IntValue: &v}}
+}
+
+// Double returns a floating point attribute.
+func Double(key string, value float64) KeyValue {
+	return KeyValue{Key: key, Value: AnyValue{DoubleValue: &value}}
+}
+
+// StringSlice returns an attribute holding an array of strings.
+func StringSlice(key string, values []string) KeyValue {
+	array := &ArrayValue{}
+	for _, value := range values {
+		array.Values = append(array.Values, StringValue(value))
+	}
+	return KeyValue{Key: key, Value: AnyValue{ArrayValue: array}}
+}
@@ -0,0 +1,538 @@
+package otlp
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Protobuf wire types used by the OTLP messages.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// errTruncated is returned when a protobuf message ends in the middle of a field.
+var errTruncated = errors.New("truncated protobuf message")
+
+// protoReader reads the fields of a protobuf encoded message.
+type protoReader struct {
+	buf []byte
+}
+
+// done reports whether all fields of the message have been read.
+func (r *protoReader) done() bool {
+	return len(r.buf) == 0
+}
+
+// next reads the tag of the next field.
+func (r *protoReader) next() (int, int, error) {
+	tag, err := r.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(tag >> 3), int(tag & 7), nil
+}
+
+// varint reads a varint encoded value.
+func (r *protoReader) varint() (uint64, error) {
+	value, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	r.buf = r.buf[n:]
+	return value, nil
+}
+
+// fixed64 reads a little endian 64 bit value.
+func (r *protoReader) fixed64() (uint64, error) {
+	if len(r.buf) < 8 {
+		return 0, errTruncated
+	}
+	value := binary.LittleEndian.Uint64(r.buf)
+	r.buf = r.buf[8:]
+	return value, nil
+}
+
+// bytes reads a length delimited value.
+func (r *protoReader) bytes() ([]byte, error) {
+	length, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(r.buf)) < length {
+		return nil, errTruncated
+	}
+	value := r.buf[:length]
+	r.buf = r.buf[length:]
+	return value, nil
+}
+
+// skip discards the value of a field that is not decoded.
+func (r *protoReader) skip(wireType int) error {
+	var err error
+	switch wireType {
+	case wireVarint:
+		_, err = r.varint()
+	case wireFixed64:
+		_, err = r.fixed64()
+	case wireBytes:
+		_, err = r.bytes()
+	case wireFixed32:
+		if len(r.buf) < 4 {
+			return errTruncated
+		}
+		r.buf = r.buf[4:]
+	default:
+		return fmt.Errorf("unsupported protobuf wire type %d", wireType)
+	}
+	return err
+}
+
+// expect checks the wire type of a decoded field.
+func expect(field, wireType, want int) error {
+	if wireType != want {
+		return fmt.Errorf("unexpected wire type %d for field %d", wireType, field)
+	}
+	return nil
+}
+
+// UnmarshalTraceRequestProto decodes a protobuf encoded ExportTraceServiceRequest.
+// Only the fields needed to ingest GenAI spans are decoded, the others are skipped.
+func UnmarshalTraceRequestProto(data []byte) (*ExportTraceServiceRequest, error) {
+	request := &ExportTraceServiceRequest{}
+	r := &protoReader{buf: data}
+	for !r.done() {
+		field, wireType, err := r.next()
+		if err != nil {
+			return nil, err
+		}
+		if field != 1 {
+			if err := r.skip(wireType); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := expect(field, wireType, wireBytes); err != nil {
+			return nil, err
+		}
+		message, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		resourceSpans, err := decodeResourceSpans(message)
+		if err != nil {
+			return nil, err
+		}
+		request.ResourceSpans = append(request.ResourceSpans, resourceSpans)
+	}
+	return request, nil
+}
+
+// decodeResourceSpans decodes a ResourceSpans message.
+func decodeResourceSpans(data []byte) (ResourceSpans, error) {
+	var resourceSpans ResourceSpans
+	r := &protoReader{buf: data}
+	for !r.done() {
+		field, wireType, err := r.next()
+		if err != nil {
+			return resourceSpans, err
+		}
+		switch field {
+		case 1:
+			message, err := r.bytes()
+			if err != nil {
+				return resourceSpans, err
+			}
+			resourceSpans.Resource.Attributes, err = decodeAttributesMessage(message)
+			if err != nil {
+				return resourceSpans, err
+			}
+		case 2:
+			message, err := r.bytes()
+			if err != nil {
+				return resourceSpans, err
+			}
+			scopeSpans, err := decodeScopeSpans(message)
+			if err != nil {
+				return resourceSpans, err
+			}
+			resourceSpans.ScopeSpans = append(resourceSpans.ScopeSpans, scopeSpans)
+		default:
+			if err := r.skip(wireType); err != nil {
+				return resourceSpans, err
+			}
+		}
+	}
+	return resourceSpans, nil
+}
+
+// decodeAttributesMessage decodes the attributes held in field 1 of a Resource message.
+func decodeAttributesMessage(data []byte) ([]KeyValue, error) {
+	var attributes []KeyValue
+	r := &protoReader{buf: data}
+	for !r.done() {
+		field, wireType, err := r.next()
+		if err != nil {
+			return nil, err
+		}
+		if field != 1 {
+			if err := r.skip(wireType); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		message, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		attribute, err := decodeKeyValue(message)
+		if err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, attribute)
+	}
+	return attributes, nil
+}
+
+// decodeScopeSpans decodes a ScopeSpans message.
+func decodeScopeSpans(data []byte) (ScopeSpans, error) {
+	var scopeSpans ScopeSpans
+	r := &protoReader{buf: data}
+	for !r.done() {
+		field, wireType, err := r.next()
+		if err != nil {
+			return scopeSpans, err
+		}
+		switch field {
+		case 1:
+			message, err := r.bytes()
+			if err != nil {
+				return scopeSpans, err
+			}
+			scopeSpans.Scope, err = decodeScope(message)
+			if err != nil {
+				return scopeSpans, err
+			}
+		case 2:
+			message, err := r.bytes()
+			if err != nil {
+				return scopeSpans, err
+			}
+			span, err := decodeSpan(message)
+			if err != nil {
+				return scopeSpans, err
+			}
+			scopeSpans.Spans = append(scopeSpans.Spans, span)
+		default:
+			if err := r.skip(wireType); err != nil {
+				return scopeSpans, err
+			}
+		}
+	}
+	return scopeSpans, nil
+}
+
+// decodeScope decodes an InstrumentationScope message.
+func decodeScope(data []byte) (InstrumentationScope, error) {
+	var scope InstrumentationScope
+	r := &protoReader{buf: data}
+	for !r.done() {
+		field, wireType, err := r.next()
+		if err != nil {
+			return scope, err
+		}
+		switch field {
+		case 1, 2:
+			value, err := r.bytes()
+			if err != nil {
+				return scope, err
+			}
+			if field == 1 {
+				scope.Name = string(value)
+			} else {
+				scope.Version = string(value)
+			}
+		default:
+			if err := r.skip(wireType); err != nil {
+				return scope, err
+			}
+		}
+	}
+	return scope, nil
+}
+
+// decodeSpan decodes a Span message.
+func decodeSpan(data []byte) (Span, error) {
+	var span Span
+	r := &protoReader{buf: data}
+	for !r.done() {
+		field, wireType, err := r.next()
+		if err != nil {
+			return span, err
+		}
+		switch field {
+		case 1, 2, 4, 5:
+			if err := expect(field, wireType, wireBytes); err != nil {
+				return span, err
+			}
+			value, err := r.bytes()
+			if err != nil {
+				return span, err
+			}
+			switch field {
+			case 1:
+				span.TraceID = hex.EncodeToString(value)
+			case 2:
+				span.SpanID = hex.EncodeToString(value)
+			case 4:
+				span.ParentSpanID = hex.EncodeToString(value)
+			case 5:
+				span.Name = string(value)
+			}
+		case 6:
+			kind, err := r.varint()
+			if err != nil {
+				return span, err
+			}
+			span.Kind = int(kind)
+		case 7, 8:
+			if err := expect(field, wireType, wireFixed64); err != nil {
+				return span, err
+			}
+			value, err := r.fixed64()
+			if err != nil {
+				return span, err
+			}
+			if field == 7 {
+				span.StartTimeUnixNano = Uint64(value)
+			} else {
+				span.EndTimeUnixNano = Uint64(value)
+			}
+		case 9:
+			message, err := r.bytes()
+			if err != nil {
+				return span, err
+			}
+			attribute, err := decodeKeyValue(message)
+			if err != nil {
+				return span, err
+			}
+			span.Attributes = append(span.Attributes, attribute)
+		case 11:
+			message, err := r.bytes()
+			if err != nil {
+				return span, err
+			}
+			event, err := decodeSpanEvent(message)
+			if err != nil {
+				return span, err
+			}
+			span.Events = append(span.Events, event)
+		default:
+			if err := r.skip(wireType); err != nil {
+				return span, err
+			}
+		}
+	}
+	return span, nil
+}
+
+// decodeSpanEvent decodes a Span.Event message.
+func decodeSpanEvent(data []byte) (SpanEvent, error) {
+	var event SpanEvent
+	r := &protoReader{buf: data}
+	for !r.done() {
+		field, wireType, err := r.next()
+		if err != nil {
+			return event, err
+		}
+		switch field {
+		case 1:
+			if err := expect(field, wireType, wireFixed64); err != nil {
+				return event, err
+			}
+			value, err := r.fixed64()
+			if err != nil {
+				return event, err
+			}
+			event.TimeUnixNano = Uint64(value)
+		case 2:
+			value, err := r.bytes()
+			if err != nil {
+				return event, err
+			}
+			event.Name = string(value)
+		case 3:
+			message, err := r.bytes()
+			if err != nil {
+				return event, err
+			}
+			attribute, err := decodeKeyValue(message)
+			if err != nil {
+				return event, err
+			}
+			event.Attributes = append(event.Attributes, attribute)
+		default:
+			if err := r.skip(wireType); err != nil {
+				return event, err
+			}
+		}
+	}
+	return event, nil
+}
+
+// decodeKeyValue decodes a KeyValue message.
+func decodeKeyValue(data []byte) (KeyValue, error) {
+	var keyValue KeyValue
+	r := &protoReader{buf: data}
+	for !r.done() {
+		field, wireType, err := r.next()
+		if err != nil {
+			return keyValue, err
+		}
+		switch field {
+		case 1:
+			value, err := r.bytes()
+			if err != nil {
+				return keyValue, err
+			}
+			keyValue.Key = string(value)
+		case 2:
+			message, err := r.bytes()
+			if err != nil {
+				return keyValue, err
+			}
+			keyValue.Value, err = decodeAnyValue(message)
+			if err != nil {
+				return keyValue, err
+			}
+		default:
+			if err := r.skip(wireType); err != nil {
+				return keyValue, err
+			}
+		}
+	}
+	return keyValue, nil
+}
+
+// decodeAnyValue decodes an AnyValue message.
+func decodeAnyValue(data []byte) (AnyValue, error) {
+	var value AnyValue
+	r := &protoReader{buf: data}
+	for !r.done() {
+		field, wireType, err := r.next()
+		if err != nil {
+			return value, err
+		}
+		switch field {
+		case 1:
+			s, err := r.bytes()
+			if err != nil {
+				return value, err
+			}
+			str := string(s)
+			value.StringValue = &str
+		case 2:
+			b, err := r.varint()
+			if err != nil {
+				return value, err
+			}
+			boolean := b != 0
+			value.BoolValue = &boolean
+		case 3:
+			i, err := r.varint()
+			if err != nil {
+				return value, err
+			}
+			integer := Int64(int64(i))
+			value.IntValue = &integer
+		case 4:
+			if err := expect(field, wireType, wireFixed64); err != nil {
+				return value, err
+			}
+			bits, err := r.fixed64()
+			if err != nil {
+				return value, err
+			}
+			double := math.Float64frombits(bits)
+			value.DoubleValue = &double
+		case 5:
+			message, err := r.bytes()
+			if err != nil {
+				return value, err
+			}
+			value.ArrayValue = &ArrayValue{}
+			inner := &protoReader{buf: message}
+			for !inner.done() {
+				innerField, innerWireType, err := inner.next()
+				if err != nil {
+					return value, err
+				}
+				if innerField != 1 {
+					if err := inner.skip(innerWireType); err != nil {
+						return value, err
+					}
+					continue
+				}
+				element, err := inner.bytes()
+				if err != nil {
+					return value, err
+				}
+				decoded, err := decodeAnyValue(element)
+				if err != nil {
+					return value, err
+				}
+				value.ArrayValue.Values = append(value.ArrayValue.Values, decoded)
+			}
+		case 6:
+			message, err := r.bytes()
+			if err != nil {
+				return value, err
+			}
+			attributes, err := decodeAttributesMessage(message)
+			if err != nil {
+				return value, err
+			}
+			value.KvlistValue = &KeyValueList{Values: attributes}
+		case 7:
+			b, err := r.bytes()
+			if err != nil {
+				return value, err
+			}
+			value.BytesValue = append([]byte{}, b...)
+		default:
+			if err := r.skip(wireType); err != nil {
+				return value, err
+			}
+		}
+	}
+	return value, nil
+}
+
+// MarshalTraceResponseProto encodes an ExportTraceServiceResponse, reporting the
+// rejected spans as a partial success when there are any.
+func MarshalTraceResponseProto(rejectedSpans int64, errorMessage string) []byte {
+	if rejectedSpans == 0 && errorMessage == "" {
+		return []byte{}
+	}
+
+	var partialSuccess []byte
+	if rejectedSpans != 0 {
+		partialSuccess = binary.AppendUvarint(partialSuccess, 1<<3|wireVarint)
+		partialSuccess = binary.AppendUvarint(partialSuccess, uint64(rejectedSpans))
+	}
+	if errorMessage != "" {
+		partialSuccess = binary.AppendUvarint(partialSuccess, 2<<3|wireBytes)
+		partialSuccess = binary.AppendUvarint(partialSuccess, uint64(len(errorMessage)))
+		partialSuccess = append(partialSuccess, errorMessage...)
+	}
+
+	response := binary.AppendUvarint(nil, 1<<3|wireBytes)
+	response = binary.AppendUvarint(response, uint64(len(partialSuccess)))
+	return append(response, partialSuccess...)
+}
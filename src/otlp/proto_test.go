@@ -0,0 +1,134 @@
+package otlp
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// protoField encodes a length-delimited protobuf field.
+func protoField(field int, value []byte) []byte {
+	b := binary.AppendUvarint(nil, uint64(field<<3|wireBytes))
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// protoVarint encodes a varint protobuf field.
+func protoVarint(field int, value uint64) []byte {
+	return binary.AppendUvarint(binary.AppendUvarint(nil, uint64(field<<3|wireVarint)), value)
+}
+
+// protoFixed64 encodes a fixed64 protobuf field.
+func protoFixed64(field int, value uint64) []byte {
+	return binary.LittleEndian.AppendUint64(binary.AppendUvarint(nil, uint64(field<<3|wireFixed64)), value)
+}
+
+func concat(parts ...[]byte) []byte {
+	var b []byte
+	for _, part := range parts {
+		b = append(b, part...)
+	}
+	return b
+}
+
+func protoString(key, value string) []byte {
+	return concat(protoField(1, []byte(key)), protoField(2, protoField(1, []byte(value))))
+}
+
+func protoInt(key string, value int64) []byte {
+	return concat(protoField(1, []byte(key)), protoField(2, protoVarint(3, uint64(value))))
+}
+
+func protoDouble(key string, value float64) []byte {
+	return concat(protoField(1, []byte(key)), protoField(2, protoFixed64(4, math.Float64bits(value))))
+}
+
+func TestUnmarshalTraceRequestProto(t *testing.T) {
+	finishReasons := protoField(5, protoField(1, protoField(1, []byte("stop"))))
+	span := concat(
+		protoField(1, []byte{0x01, 0x02}),
+		protoField(2, []byte{0x0a}),
+		protoField(5, []byte("chat gpt-4")),
+		protoVarint(6, SpanKindClient),
+		protoFixed64(7, 1000),
+		protoFixed64(8, 2500),
+		protoField(9, protoString("gen_ai.system", "openai")),
+		protoField(9, protoInt("gen_ai.usage.input_tokens", 12)),
+		protoField(9, protoDouble("doku.usage.cost", 0.5)),
+		protoField(9, concat(protoField(1, []byte("gen_ai.response.finish_reasons")), protoField(2, finishReasons))),
+		protoField(11, concat(protoFixed64(1, 1200), protoField(2, []byte("gen_ai.content.prompt")),
+			protoField(3, protoString("gen_ai.prompt", "Hello")))),
+		protoVarint(15, 3), // an unknown field is skipped
+	)
+	scopeSpans := concat(protoField(1, protoField(1, []byte("openlit"))), protoField(2, span))
+	resourceSpans := concat(protoField(1, protoField(1, protoString("service.name", "chatbot"))), protoField(2, scopeSpans))
+
+	request, err := UnmarshalTraceRequestProto(protoField(1, resourceSpans))
+	if err != nil {
+		t.Fatalf("UnmarshalTraceRequestProto() error = %v", err)
+	}
+
+	resource := newAttributeMap(request.ResourceSpans[0].Resource.Attributes)
+	if name, _ := resource.str("service.name"); name != "chatbot" {
+		t.Errorf("service.name = %q, want chatbot", name)
+	}
+	if scope := request.ResourceSpans[0].ScopeSpans[0].Scope.Name; scope != "openlit" {
+		t.Errorf("scope name = %q, want openlit", scope)
+	}
+
+	got := request.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if got.TraceID != "0102" || got.SpanID != "0a" || got.Name != "chat gpt-4" || got.Kind != SpanKindClient {
+		t.Errorf("span = %+v, want trace 0102, span 0a, name 'chat gpt-4' of kind client", got)
+	}
+	if got.StartTimeUnixNano != 1000 || got.EndTimeUnixNano != 2500 {
+		t.Errorf("span times = %d, %d, want 1000, 2500", got.StartTimeUnixNano, got.EndTimeUnixNano)
+	}
+
+	attributes := newAttributeMap(got.Attributes)
+	if system, _ := attributes.str("gen_ai.system"); system != "openai" {
+		t.Errorf("gen_ai.system = %q, want openai", system)
+	}
+	if tokens, _ := attributes.num("gen_ai.usage.input_tokens"); tokens != 12 {
+		t.Errorf("gen_ai.usage.input_tokens = %v, want 12", tokens)
+	}
+	if cost, _ := attributes.num("doku.usage.cost"); cost != 0.5 {
+		t.Errorf("doku.usage.cost = %v, want 0.5", cost)
+	}
+	if reason, _ := attributes.str("gen_ai.response.finish_reasons"); reason != "stop" {
+		t.Errorf("gen_ai.response.finish_reasons = %q, want stop", reason)
+	}
+
+	if len(got.Events) != 1 || got.Events[0].Name != "gen_ai.content.prompt" || got.Events[0].TimeUnixNano != 1200 {
+		t.Fatalf("events = %+v, want the prompt event", got.Events)
+	}
+	if prompt, _ := newAttributeMap(got.Events[0].Attributes).str("gen_ai.prompt"); prompt != "Hello" {
+		t.Errorf("gen_ai.prompt = %q, want Hello", prompt)
+	}
+}
+
+func TestUnmarshalTraceRequestProtoErrors(t *testing.T) {
+	tests := map[string][]byte{
+		"truncated length":    {0x0a, 0x05, 0x01},
+		"wrong wire type":     protoVarint(1, 1),
+		"truncated varint":    {0x0a, 0x80},
+		"truncated span time": protoField(1, protoField(2, protoField(2, []byte{0x39, 0x01}))),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := UnmarshalTraceRequestProto(data); err == nil {
+				t.Fatal("UnmarshalTraceRequestProto() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestMarshalTraceResponseProto(t *testing.T) {
+	if got := MarshalTraceResponseProto(0, ""); len(got) != 0 {
+		t.Errorf("MarshalTraceResponseProto(0, \"\") = %x, want an empty message", got)
+	}
+
+	want := protoField(1, concat(protoVarint(1, 2), protoField(2, []byte("bad span"))))
+	if got := MarshalTraceResponseProto(2, "bad span"); string(got) != string(want) {
+		t.Errorf("MarshalTraceResponseProto(2, \"bad span\") = %x, want %x", got, want)
+	}
+}
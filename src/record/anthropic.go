@@ -0,0 +1,8 @@
+package record
+
+func init() {
+	Register(Endpoint{Name: "anthropic.completions", Category: CategoryChat, Operation: "text_completion", SpanOperations: []string{"text_completion"},
+		Required: []string{"model"}, TokensOrText: true, SeparateCacheTokens: true, Cost: chatCost})
+	Register(Endpoint{Name: "anthropic.messages", Category: CategoryChat, Operation: "chat", SpanOperations: []string{"chat"},
+		Required: []string{"model"}, TokensOrText: true, SeparateCacheTokens: true, Cost: chatCost})
+}
@@ -0,0 +1,14 @@
+package record
+
+func init() {
+	RegisterSpanSystem("aws.bedrock", "bedrock")
+
+	// Bedrock serves the models of other providers, they are priced from the common sections with
+	// their model IDs normalised and the overrides of their region
+	Register(Endpoint{Name: "bedrock.converse", Category: CategoryChat, Operation: "chat", SpanOperations: []string{"chat"},
+		Required: []string{"model"}, TokensOrText: true, SeparateCacheTokens: true, Cost: chatCost})
+	Register(Endpoint{Name: "bedrock.invoke_model", Category: CategoryChat, Operation: "text_completion", SpanOperations: []string{"text_completion"},
+		Required: []string{"model"}, TokensOrText: true, SeparateCacheTokens: true, Cost: chatCost})
+	Register(Endpoint{Name: "bedrock.embed", Category: CategoryEmbedding, Operation: "embeddings", SpanOperations: []string{"embeddings"},
+		Required: []string{"model", "promptTokens"}, Cost: embeddingsCost})
+}
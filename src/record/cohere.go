@@ -0,0 +1,12 @@
+package record
+
+func init() {
+	Register(Endpoint{Name: "cohere.chat", Category: CategoryChat, Operation: "chat", SpanOperations: []string{"chat"},
+		Required: []string{"model"}, Cost: chatCost})
+	Register(Endpoint{Name: "cohere.generate", Category: CategoryChat, Operation: "text_completion", SpanOperations: []string{"text_completion"},
+		Required: []string{"model"}, Cost: chatCost})
+	Register(Endpoint{Name: "cohere.summarize", Category: CategoryChat, Operation: "text_completion",
+		Required: []string{"model"}, Cost: chatCost})
+	Register(Endpoint{Name: "cohere.embed", Category: CategoryEmbedding, Operation: "embeddings", SpanOperations: []string{"embeddings"},
+		Required: []string{"model", "promptTokens"}, Cost: embeddingsCost})
+}
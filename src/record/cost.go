@@ -0,0 +1,109 @@
+package record
+
+import (
+	"strings"
+	"time"
+
+	"ingester/cost"
+)
+
+// pricingRequest returns how a record is priced: the pricing version set by CalculateUsageCost,
+// the provider of its endpoint, its region, the pricing key of its model and the tenant that pushed it.
+func pricingRequest(r Record) cost.Request {
+	model, region := cost.ResolveModel(r.Model)
+	if r.Region != "" {
+		region = r.Region
+	}
+	version := 0
+	if r.PricingVersion != nil {
+		version = *r.PricingVersion
+	}
+	return cost.Request{Version: version, Provider: strings.SplitN(r.Endpoint, ".", 2)[0], Region: region, Model: model,
+		Tenant: cost.Tenant{OrgID: r.OrgID, APIKey: r.Name, ApplicationName: r.ApplicationName}}
+}
+
+// Negotiated reports whether the record is priced with a pricing override of its tenant.
+func (r Record) Negotiated() bool {
+	return cost.HasOverride(pricingRequest(r))
+}
+
+// chatCost prices the prompt, completion and cached tokens of a record with the chat pricing. The
+// cached tokens are priced with the cache prices, on top of the prompt tokens for the endpoints that
+// report them apart, and instead of the price of the prompt tokens for the others.
+func chatCost(r Record) (float64, bool) {
+	if r.PromptTokens == nil || r.CompletionTokens == nil {
+		return 0, false
+	}
+	req := pricingRequest(r)
+	promptTokens := *r.PromptTokens
+	cacheReadTokens, cacheWriteTokens := intValue(r.CacheReadTokens), intValue(r.CacheWriteTokens)
+	if !registry[r.Endpoint].SeparateCacheTokens {
+		promptTokens = max(promptTokens-cacheReadTokens-cacheWriteTokens, 0)
+	}
+
+	usageCost, _ := cost.CalculateChatCost(req, float64(promptTokens), float64(*r.CompletionTokens))
+	if cacheReadTokens > 0 || cacheWriteTokens > 0 {
+		cacheCost, _ := cost.CalculateCacheCost(req, float64(cacheReadTokens), float64(cacheWriteTokens))
+		usageCost += cacheCost
+	}
+	return usageCost, true
+}
+
+// intValue returns the value of an optional token count, or zero when it is not set.
+func intValue(value *int) int {
+	if value == nil {
+		return 0
+	}
+	return *value
+}
+
+// embeddingsCost prices the prompt tokens of a record with the embeddings pricing.
+func embeddingsCost(r Record) (float64, bool) {
+	if r.PromptTokens == nil {
+		return 0, false
+	}
+	usageCost, _ := cost.CalculateEmbeddingsCost(pricingRequest(r), float64(*r.PromptTokens))
+	return usageCost, true
+}
+
+// imageCost prices a record with the image pricing of its size and quality.
+func imageCost(r Record) (float64, bool) {
+	usageCost, _ := cost.CalculateImageCost(pricingRequest(r), r.ImageSize, r.ImageQuality)
+	return usageCost, true
+}
+
+// audioCost prices the characters of the prompt of a record with the audio pricing.
+func audioCost(r Record) (float64, bool) {
+	usageCost, _ := cost.CalculateAudioCost(pricingRequest(r), r.Prompt)
+	return usageCost, true
+}
+
+// CalculateUsageCost sets the usage cost of the record with the cost function of its endpoint and
+// the pricing version in effect at the time of the record. Endpoints that accept the prompt and
+// response instead of the token counts get their tokens counted first.
+func (r *Record) CalculateUsageCost() {
+	endpoint, ok := registry[r.Endpoint]
+	if !ok || endpoint.Cost == nil {
+		return
+	}
+
+	eventTime := time.Now()
+	if r.Time != nil {
+		eventTime = *r.Time
+	}
+	r.PricingVersion = nil
+	if version := cost.PricingAt(eventTime); version.Number != 0 {
+		r.PricingVersion = &version.Number
+	}
+
+	if endpoint.TokensOrText && (r.PromptTokens == nil || r.CompletionTokens == nil) && r.Prompt != "" && r.Response != "" {
+		model := pricingRequest(*r).Model
+		promptTokens, completionTokens := cost.CountTokens(r.Prompt, model), cost.CountTokens(r.Response, model)
+		totalTokens := promptTokens + completionTokens
+		r.PromptTokens, r.CompletionTokens, r.TotalTokens = &promptTokens, &completionTokens, &totalTokens
+	}
+
+	if usageCost, ok := endpoint.Cost(*r); ok {
+		r.UsageCost = &usageCost
+	}
+}
@@ -0,0 +1,153 @@
+package record
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Category groups the endpoints that are priced and exported the same way.
+type Category string
+
+// Categories of endpoints.
+const (
+	CategoryChat      Category = "chat"
+	CategoryEmbedding Category = "embedding"
+	CategoryImage     Category = "image"
+	CategoryAudio     Category = "audio"
+	CategoryFineTune  Category = "fine-tune"
+)
+
+// LogField is a log exported for a record. Its message is the first of the fields that is set.
+type LogField struct {
+	Type   string
+	Fields []string
+}
+
+// Endpoint describes how the records of a provider endpoint are validated, priced and exported.
+type Endpoint struct {
+	// Name is the value of the 'endpoint' field of the records, such as 'openai.chat.completions'.
+	Name string
+	// Category is the kind of request, which sets the default metrics, labels and logs.
+	Category Category
+	// Operation is the GenAI semantic convention operation name the endpoint is exported as.
+	Operation string
+	// SpanOperations are the operation names of the OTLP GenAI spans stored as this endpoint.
+	SpanOperations []string
+	// Required lists the fields that must be set on top of the fields every record requires.
+	Required []string
+	// TokensOrText accepts the prompt and response instead of the token counts, the tokens are then
+	// counted by the ingester.
+	TokensOrText bool
+	// SeparateCacheTokens is set when the provider reports the prompt tokens read from and written to
+	// the prompt cache apart from the prompt tokens, as Anthropic does. Other providers, such as OpenAI
+	// and Gemini, count the cached tokens in the prompt tokens.
+	SeparateCacheTokens bool
+	// Cost calculates the usage cost of a record. It returns false when the record cannot be priced,
+	// a nil Cost leaves the usage cost of the records as sent.
+	Cost func(r Record) (float64, bool)
+	// Metrics lists the numeric fields exported as metrics, Labels the fields added to their labels and
+	// Logs the fields exported as logs. They default to those of the category.
+	Metrics []string
+	Labels  []string
+	Logs    []LogField
+}
+
+// categoryDefaults holds the metrics, labels and logs exported for each category.
+var categoryDefaults = map[Category]Endpoint{
+	CategoryChat: {
+		Metrics: []string{"completionTokens", "promptTokens", "totalTokens", "cacheReadTokens", "cacheWriteTokens", "requestDuration", "usageCost"},
+		Labels:  []string{"finishReason"},
+		Logs:    []LogField{{Type: "response", Fields: []string{"response"}}, {Type: "prompt", Fields: []string{"prompt"}}},
+	},
+	CategoryEmbedding: {
+		Metrics: []string{"promptTokens", "totalTokens", "requestDuration", "usageCost"},
+		Logs:    []LogField{{Type: "prompt", Fields: []string{"prompt"}}},
+	},
+	CategoryImage: {
+		Metrics: []string{"requestDuration", "usageCost"},
+		Labels:  []string{"imageSize", "imageQuality"},
+		Logs:    []LogField{{Type: "prompt", Fields: []string{"revisedPrompt", "prompt"}}, {Type: "image", Fields: []string{"image"}}},
+	},
+	CategoryAudio: {
+		Metrics: []string{"requestDuration", "usageCost"},
+		Labels:  []string{"audioVoice"},
+		Logs:    []LogField{{Type: "prompt", Fields: []string{"prompt"}}},
+	},
+	CategoryFineTune: {
+		Metrics: []string{"requestDuration"},
+		Labels:  []string{"finetuneJobId"},
+	},
+}
+
+// registry holds the supported endpoints by name.
+var registry = map[string]Endpoint{}
+
+// spanSystems maps the 'gen_ai.system' values of OTLP spans to the provider prefix of the endpoints,
+// for providers whose endpoints are not named after the system.
+var spanSystems = map[string]string{}
+
+// Register adds an endpoint to the registry. It is meant to be called from the init function of
+// the file registering the endpoints of a provider.
+func Register(endpoint Endpoint) {
+	if _, exists := registry[endpoint.Name]; exists {
+		panic(fmt.Sprintf("record: endpoint '%s' registered twice", endpoint.Name))
+	}
+	defaults, ok := categoryDefaults[endpoint.Category]
+	if !ok {
+		panic(fmt.Sprintf("record: endpoint '%s' has unknown category '%s'", endpoint.Name, endpoint.Category))
+	}
+
+	if endpoint.Metrics == nil {
+		endpoint.Metrics = defaults.Metrics
+	}
+	if endpoint.Labels == nil {
+		endpoint.Labels = defaults.Labels
+	}
+	if endpoint.Logs == nil {
+		endpoint.Logs = defaults.Logs
+	}
+	registry[endpoint.Name] = endpoint
+}
+
+// RegisterSpanSystem stores the OTLP GenAI spans of a 'gen_ai.system' as endpoints of a provider.
+func RegisterSpanSystem(system, provider string) {
+	if _, exists := spanSystems[system]; exists {
+		panic(fmt.Sprintf("record: span system '%s' registered twice", system))
+	}
+	spanSystems[system] = provider
+}
+
+// LookupEndpoint returns the registered endpoint with the given name.
+func LookupEndpoint(name string) (Endpoint, bool) {
+	endpoint, ok := registry[name]
+	return endpoint, ok
+}
+
+// Endpoints returns the names of the supported endpoints in alphabetical order.
+func Endpoints() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SpanEndpoint returns the endpoint an OTLP GenAI span of the system and operation is stored as.
+func SpanEndpoint(system, operation string) (string, bool) {
+	if provider, ok := spanSystems[system]; ok {
+		system = provider
+	}
+	for _, name := range Endpoints() {
+		if !strings.HasPrefix(name, system+".") {
+			continue
+		}
+		for _, spanOperation := range registry[name].SpanOperations {
+			if spanOperation == operation {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
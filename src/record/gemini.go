@@ -0,0 +1,17 @@
+package record
+
+func init() {
+	RegisterSpanSystem("gcp.gemini", "gemini")
+	RegisterSpanSystem("gcp.vertex_ai", "vertexai")
+	RegisterSpanSystem("vertex_ai", "vertexai")
+
+	// Gemini models are served by the Gemini API and by Vertex AI, which are priced apart
+	for _, provider := range []string{"gemini", "vertexai"} {
+		Register(Endpoint{Name: provider + ".chat", Category: CategoryChat, Operation: "chat", SpanOperations: []string{"chat"},
+			Required: []string{"model"}, TokensOrText: true, Cost: chatCost})
+		Register(Endpoint{Name: provider + ".generate", Category: CategoryChat, Operation: "generate_content", SpanOperations: []string{"generate_content", "text_completion"},
+			Required: []string{"model"}, TokensOrText: true, Cost: chatCost})
+		Register(Endpoint{Name: provider + ".embed", Category: CategoryEmbedding, Operation: "embeddings", SpanOperations: []string{"embeddings"},
+			Required: []string{"model", "promptTokens"}, Cost: embeddingsCost})
+	}
+}
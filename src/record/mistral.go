@@ -0,0 +1,12 @@
+package record
+
+func init() {
+	RegisterSpanSystem("mistral_ai", "mistral")
+
+	Register(Endpoint{Name: "mistral.chat", Category: CategoryChat, Operation: "chat", SpanOperations: []string{"chat"},
+		Required: []string{"model"}, TokensOrText: true, Cost: chatCost})
+	Register(Endpoint{Name: "mistral.fim.completions", Category: CategoryChat, Operation: "text_completion", SpanOperations: []string{"text_completion"},
+		Required: []string{"model"}, TokensOrText: true, Cost: chatCost})
+	Register(Endpoint{Name: "mistral.embeddings", Category: CategoryEmbedding, Operation: "embeddings", SpanOperations: []string{"embeddings"},
+		Required: []string{"model", "promptTokens"}, Cost: embeddingsCost})
+}
@@ -0,0 +1,22 @@
+package record
+
+func init() {
+	// Azure OpenAI requests are stored as OpenAI requests, their deployment names are mapped to
+	// models by the 'pricingInfo.deployments' configuration
+	RegisterSpanSystem("az.ai.openai", "openai")
+	RegisterSpanSystem("azure_openai", "openai")
+
+	Register(Endpoint{Name: "openai.chat.completions", Category: CategoryChat, Operation: "chat", SpanOperations: []string{"chat"},
+		Required: []string{"model"}, TokensOrText: true, Cost: chatCost})
+	Register(Endpoint{Name: "openai.completions", Category: CategoryChat, Operation: "text_completion", SpanOperations: []string{"text_completion"},
+		Required: []string{"model"}, TokensOrText: true, Cost: chatCost})
+	Register(Endpoint{Name: "openai.embeddings", Category: CategoryEmbedding, Operation: "embeddings", SpanOperations: []string{"embeddings"},
+		Required: []string{"model", "promptTokens"}, Cost: embeddingsCost})
+	Register(Endpoint{Name: "openai.images.create", Category: CategoryImage, Operation: "image_generation",
+		Required: []string{"model", "imageSize", "imageQuality"}, Cost: imageCost})
+	Register(Endpoint{Name: "openai.images.create.variations", Category: CategoryImage, Operation: "image_generation",
+		Required: []string{"model", "imageSize", "imageQuality"}, Cost: imageCost})
+	Register(Endpoint{Name: "openai.audio.speech.create", Category: CategoryAudio, Operation: "speech",
+		Required: []string{"model", "prompt"}, Cost: audioCost})
+	Register(Endpoint{Name: "openai.fine_tuning", Category: CategoryFineTune, Operation: "fine_tuning"})
+}
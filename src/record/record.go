@@ -0,0 +1,201 @@
+package record
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ErrInvalidBody is returned when a record is not a JSON object.
+var ErrInvalidBody = errors.New("INVALIDBODY")
+
+// Record is a single LLM request pushed to the ingester. Optional numeric fields are pointers so
+// that a missing value is stored as NULL instead of zero.
+type Record struct {
+	Name              string     `json:"-"`    // Name is the name of the API key that pushed the record.
+	OrgID             int        `json:"-"`    // OrgID is the organization of the API key that pushed the record.
+	ProjectID         int        `json:"-"`    // ProjectID is the project of the API key that pushed the record.
+	PricingVersion    *int       `json:"-"`    // PricingVersion is the stored pricing version the usage cost was calculated with.
+	Time              *time.Time `json:"time"` // Time is when the request was made, it defaults to when the record is received.
+	Environment       string     `json:"environment"`
+	Endpoint          string     `json:"endpoint"`
+	SourceLanguage    string     `json:"sourceLanguage"`
+	ApplicationName   string     `json:"applicationName"`
+	CompletionTokens  *int       `json:"completionTokens"`
+	PromptTokens      *int       `json:"promptTokens"`
+	TotalTokens       *int       `json:"totalTokens"`
+	FinishReason      string     `json:"finishReason"`
+	RequestDuration   *float64   `json:"requestDuration"`
+	UsageCost         *float64   `json:"usageCost"`
+	Model             string     `json:"model"`
+	Prompt            string     `json:"prompt"`
+	Response          string     `json:"response"`
+	ImageSize         string     `json:"imageSize"`
+	RevisedPrompt     string     `json:"revisedPrompt"`
+	Image             string     `json:"image"`
+	AudioVoice        string     `json:"audioVoice"`
+	FinetuneJobID     string     `json:"finetuneJobId"`
+	FinetuneJobStatus string     `json:"finetuneJobStatus"`
+	ImageQuality      string     `json:"imageQuality"`
+	InputTokens       *int       `json:"inputTokens"`      // InputTokens is an alias of PromptTokens, as reported by Anthropic.
+	OutputTokens      *int       `json:"outputTokens"`     // OutputTokens is an alias of CompletionTokens, as reported by Anthropic.
+	CacheReadTokens   *int       `json:"cacheReadTokens"`  // CacheReadTokens are the prompt tokens read from the prompt cache.
+	CacheWriteTokens  *int       `json:"cacheWriteTokens"` // CacheWriteTokens are the prompt tokens written to the prompt cache.
+	Region            string     `json:"region"`           // Region is the cloud region the model was served from.
+	SkipResp          bool       `json:"skipResp"`         // SkipResp requests the record to be written in the background.
+}
+
+// FieldError describes why a field of a record is invalid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of a record. Its status is 400 when a field has the
+// wrong type and 422 when the record is well-formed but does not satisfy the rules of its endpoint.
+type ValidationError struct {
+	Status int
+	Fields []FieldError
+}
+
+// Error returns the invalid fields of the record as a single message.
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = fmt.Sprintf("'%s' %s", field.Field, field.Message)
+	}
+	return "Invalid record: " + strings.Join(messages, "; ")
+}
+
+// jsonField is a field of Record that is read from the request body.
+type jsonField struct {
+	name     string
+	index    int
+	jsonType string // jsonType is the JSON Schema type of the field.
+	format   string // format is the JSON Schema format of string fields, if any.
+}
+
+// jsonFields holds the fields of Record that are read from the request body, in declaration order.
+var jsonFields = func() []jsonField {
+	var fields []jsonField
+	t := reflect.TypeOf(Record{})
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("json")
+		if name == "-" {
+			continue
+		}
+
+		jsonType, format := "string", ""
+		switch t.Field(i).Type.String() {
+		case "*time.Time":
+			format = "date-time"
+		case "*int":
+			jsonType = "integer"
+		case "*float64":
+			jsonType = "number"
+		case "bool":
+			jsonType = "boolean"
+		}
+		fields = append(fields, jsonField{name: name, index: i, jsonType: jsonType, format: format})
+	}
+	return fields
+}()
+
+// typeMessage returns the message reported when the field has the wrong type.
+func (f jsonField) typeMessage() string {
+	if f.jsonType == "integer" {
+		return "must be an integer"
+	}
+	if f.format == "date-time" {
+		return "must be an RFC 3339 date-time"
+	}
+	return "must be a " + f.jsonType
+}
+
+// Decode reads a record from a JSON object and validates it. Each field is decoded on its own so
+// that every field with the wrong type is reported, fields that are not part of a record are ignored.
+func Decode(body []byte) (Record, error) {
+	var r Record
+
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(body, &values); err != nil || values == nil {
+		return r, ErrInvalidBody
+	}
+
+	var invalid []FieldError
+	v := reflect.ValueOf(&r).Elem()
+	for _, field := range jsonFields {
+		value, ok := values[field.name]
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal(value, v.Field(field.index).Addr().Interface()); err != nil {
+			invalid = append(invalid, FieldError{Field: field.name, Message: field.typeMessage()})
+		}
+	}
+	if len(invalid) > 0 {
+		return r, &ValidationError{Status: http.StatusBadRequest, Fields: invalid}
+	}
+
+	r.normalizeTokens()
+	return r, r.Validate()
+}
+
+// normalizeTokens moves the token counts sent as 'inputTokens' and 'outputTokens' to the prompt and
+// completion tokens, and sets the total tokens when they are not sent. The cached tokens are added to
+// the total of the endpoints that report them apart from the prompt tokens.
+func (r *Record) normalizeTokens() {
+	if r.PromptTokens == nil {
+		r.PromptTokens = r.InputTokens
+	}
+	if r.CompletionTokens == nil {
+		r.CompletionTokens = r.OutputTokens
+	}
+	r.InputTokens, r.OutputTokens = nil, nil
+
+	if r.TotalTokens == nil && r.PromptTokens != nil && r.CompletionTokens != nil {
+		totalTokens := *r.PromptTokens + *r.CompletionTokens
+		if registry[r.Endpoint].SeparateCacheTokens {
+			totalTokens += intValue(r.CacheReadTokens) + intValue(r.CacheWriteTokens)
+		}
+		r.TotalTokens = &totalTokens
+	}
+}
+
+// isSet reports whether a field of the record, identified by its JSON name, has a value.
+func (r *Record) isSet(name string) bool {
+	_, ok := r.Field(name)
+	return ok
+}
+
+// Field returns the value of a field of the record, identified by its JSON name, and whether it is set.
+func (r Record) Field(name string) (interface{}, bool) {
+	v := reflect.ValueOf(r)
+	for _, field := range jsonFields {
+		if field.name != name {
+			continue
+		}
+		value := v.Field(field.index)
+		if value.Kind() == reflect.Pointer {
+			if value.IsNil() {
+				return nil, false
+			}
+			return value.Elem().Interface(), true
+		}
+		return value.Interface(), !value.IsZero()
+	}
+	return nil, false
+}
+
+// String returns the value of a field of the record, identified by its JSON name, as a string.
+func (r Record) String(name string) string {
+	value, ok := r.Field(name)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
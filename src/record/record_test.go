@@ -0,0 +1,282 @@
+package record
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"ingester/cost"
+)
+
+// chatBody returns a valid chat record with the given fields added.
+func chatBody(fields string) []byte {
+	return recordBody("openai.chat.completions", "gpt-4", fields)
+}
+
+// recordBody returns a valid record of the endpoint and model with the given fields added.
+func recordBody(endpoint, model, fields string) []byte {
+	body := fmt.Sprintf(`"environment":"prod","endpoint":"%s","sourceLanguage":"python","applicationName":"chatbot","model":"%s"`, endpoint, model)
+	if fields != "" {
+		body = fields + "," + body
+	}
+	return []byte("{" + body + "}")
+}
+
+// withPricing prices the records of a test with the given pricing, stored as the only version.
+func withPricing(t *testing.T, pricingModel *cost.PricingModel) {
+	t.Helper()
+	cost.SetVersions([]cost.Version{{Number: 1, Pricing: pricingModel}})
+	t.Cleanup(func() { cost.SetVersions(nil) })
+}
+
+// pricedCost decodes a record and returns the usage cost calculated for it.
+func pricedCost(t *testing.T, body []byte) float64 {
+	t.Helper()
+	r, err := Decode(body)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	r.CalculateUsageCost()
+	if r.UsageCost == nil {
+		t.Fatalf("usageCost is not set for %s", body)
+	}
+	return *r.UsageCost
+}
+
+// closeTo reports whether two costs are equal up to the rounding of float arithmetic.
+func closeTo(got, want float64) bool {
+	return math.Abs(got-want) < 1e-9
+}
+
+// fieldErrors returns the fields reported by a validation error with its status.
+func fieldErrors(t *testing.T, err error) (int, []string) {
+	t.Helper()
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("error = %v, want a ValidationError", err)
+	}
+	var fields []string
+	for _, field := range validationErr.Fields {
+		fields = append(fields, field.Field)
+	}
+	return validationErr.Status, fields
+}
+
+func TestDecodeValid(t *testing.T) {
+	r, err := Decode(chatBody(`"promptTokens":10,"completionTokens":5,"requestDuration":0.5,"time":"2024-03-01T12:00:00Z","unknown":true`))
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if r.Environment != "prod" || r.Model != "gpt-4" || *r.PromptTokens != 10 || *r.CompletionTokens != 5 || *r.RequestDuration != 0.5 {
+		t.Errorf("Decode() = %+v", r)
+	}
+	if !r.Time.Equal(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("time = %v", r.Time)
+	}
+	if r.TotalTokens == nil || *r.TotalTokens != 15 {
+		t.Errorf("totalTokens = %v, want the sum of the prompt and completion tokens", r.TotalTokens)
+	}
+	if r.UsageCost != nil {
+		t.Errorf("usageCost = %v, want a missing value left unset", *r.UsageCost)
+	}
+}
+
+func TestDecodeTokenAliases(t *testing.T) {
+	r, err := Decode(chatBody(`"inputTokens":100,"outputTokens":20,"cacheReadTokens":50,"cacheWriteTokens":5`))
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if *r.PromptTokens != 100 || *r.CompletionTokens != 20 || r.InputTokens != nil || r.OutputTokens != nil {
+		t.Errorf("aliases were not moved to the prompt and completion tokens: %+v", r)
+	}
+	if *r.TotalTokens != 120 {
+		t.Errorf("totalTokens = %d, want 120 since OpenAI counts the cached tokens in the prompt tokens", *r.TotalTokens)
+	}
+
+	r, err = Decode(recordBody("anthropic.messages", "claude-3-opus", `"inputTokens":100,"outputTokens":20,"cacheReadTokens":50,"cacheWriteTokens":5`))
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if *r.TotalTokens != 175 {
+		t.Errorf("totalTokens = %d, want 175 since Anthropic reports the cached tokens apart", *r.TotalTokens)
+	}
+
+	r, err = Decode(chatBody(`"promptTokens":7,"inputTokens":100,"completionTokens":3,"totalTokens":12`))
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if *r.PromptTokens != 7 || *r.TotalTokens != 12 {
+		t.Errorf("sent values were overwritten: prompt %d, total %d", *r.PromptTokens, *r.TotalTokens)
+	}
+}
+
+func TestDecodeInvalidBody(t *testing.T) {
+	for _, body := range []string{"", "null", "[]", `"record"`, "{", `{"environment":"prod"`} {
+		if _, err := Decode([]byte(body)); err != ErrInvalidBody {
+			t.Errorf("Decode(%q) error = %v, want ErrInvalidBody", body, err)
+		}
+	}
+}
+
+func TestDecodeWrongTypes(t *testing.T) {
+	_, err := Decode(chatBody(`"promptTokens":"10","completionTokens":1.5,"time":"yesterday","skipResp":"yes","prompt":42`))
+
+	status, fields := fieldErrors(t, err)
+	if status != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", status)
+	}
+	// Every field with the wrong type is reported, in the order of the fields of Record
+	if got := strings.Join(fields, ","); got != "time,completionTokens,promptTokens,prompt,skipResp" {
+		t.Errorf("fields = %s", got)
+	}
+	var validationErr *ValidationError
+	errors.As(err, &validationErr)
+	if validationErr.Fields[0].Message != "must be an RFC 3339 date-time" || validationErr.Fields[1].Message != "must be an integer" {
+		t.Errorf("messages = %+v", validationErr.Fields)
+	}
+}
+
+func TestDecodeValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   []byte
+		fields string
+	}{
+		{"missing common fields", []byte(`{"endpoint":"openai.chat.completions","model":"gpt-4","prompt":"a","response":"b"}`), "environment,sourceLanguage,applicationName"},
+		{"unknown endpoint", []byte(`{"environment":"prod","endpoint":"openai.unknown","sourceLanguage":"go","applicationName":"app"}`), "endpoint"},
+		{"missing tokens and text", chatBody(`"promptTokens":3`), "promptTokens"},
+		{"missing endpoint field", []byte(`{"environment":"prod","endpoint":"openai.embeddings","sourceLanguage":"go","applicationName":"app","model":"ada"}`), "promptTokens"},
+		{"negative values", chatBody(`"promptTokens":-1,"completionTokens":1,"usageCost":-0.5`), "promptTokens,usageCost"},
+		{"long label", chatBody(fmt.Sprintf(`"prompt":"a","response":"b","region":"%s"`, strings.Repeat("r", maxLabelLength+1))), "region"},
+		{"future time", chatBody(fmt.Sprintf(`"prompt":"a","response":"b","time":"%s"`, time.Now().Add(time.Hour).Format(time.RFC3339))), "time"},
+		{"gemini chat without tokens or text", recordBody("gemini.chat", "gemini-pro", `"completionTokens":3`), "promptTokens"},
+		{"vertexai generate without model", recordBody("vertexai.generate", "", `"promptTokens":3,"completionTokens":3`), "model"},
+		{"vertexai embed without tokens", recordBody("vertexai.embed", "textembedding-gecko", ""), "promptTokens"},
+		{"mistral fim without tokens or text", recordBody("mistral.fim.completions", "codestral-latest", `"prompt":"def"`), "promptTokens"},
+		{"mistral embeddings without tokens", recordBody("mistral.embeddings", "mistral-embed", ""), "promptTokens"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Decode(test.body)
+			status, fields := fieldErrors(t, err)
+			if status != http.StatusUnprocessableEntity {
+				t.Errorf("status = %d, want 422", status)
+			}
+			if got := strings.Join(fields, ","); got != test.fields {
+				t.Errorf("fields = %s, want %s", got, test.fields)
+			}
+		})
+	}
+}
+
+func TestDecodeTextInsteadOfTokens(t *testing.T) {
+	if _, err := Decode(chatBody(`"prompt":"Hello","response":"Hi"`)); err != nil {
+		t.Errorf("Decode() error = %v, want the prompt and response accepted without tokens", err)
+	}
+}
+
+func TestDecodeProviderEndpoints(t *testing.T) {
+	tests := []struct {
+		endpoint, model, fields string
+	}{
+		{"gemini.chat", "gemini-1.5-pro", `"promptTokens":10,"completionTokens":5`},
+		{"gemini.generate", "gemini-1.5-flash", `"prompt":"Hello","response":"Hi"`},
+		{"gemini.embed", "text-embedding-004", `"promptTokens":10`},
+		{"vertexai.chat", "gemini-1.5-pro", `"inputTokens":10,"outputTokens":5`},
+		{"vertexai.generate", "gemini-1.5-flash", `"promptTokens":10,"completionTokens":5`},
+		{"vertexai.embed", "textembedding-gecko", `"promptTokens":10`},
+		{"mistral.chat", "mistral-large-latest", `"promptTokens":10,"completionTokens":5`},
+		{"mistral.fim.completions", "codestral-latest", `"prompt":"def","response":"main():"`},
+		{"mistral.embeddings", "mistral-embed", `"promptTokens":10`},
+	}
+	for _, test := range tests {
+		t.Run(test.endpoint, func(t *testing.T) {
+			r, err := Decode(recordBody(test.endpoint, test.model, test.fields))
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if r.Endpoint != test.endpoint || r.Model != test.model {
+				t.Errorf("Decode() = %+v", r)
+			}
+		})
+	}
+}
+
+func TestChatCostCachedTokens(t *testing.T) {
+	cached := cost.ChatPricing{PromptPrice: 0.01, CompletionPrice: 0.03, CacheReadPrice: 0.001, CacheWritePrice: 0.0125}
+	withPricing(t, &cost.PricingModel{
+		Chat:   map[string]cost.ChatPricing{"gpt-4": cached, "claude-3-opus": cached},
+		Gemini: cost.ProviderPricing{Chat: map[string]cost.ChatPricing{"gemini-pro": {PromptPrice: 0.01, CompletionPrice: 0.03}}},
+	})
+
+	tests := []struct {
+		name string
+		body []byte
+		want float64
+	}{
+		// 1000 prompt tokens apart from 2000 read and 1000 written: 0.01 + 0.002 + 0.0125, and 0.03 for the completion
+		{"anthropic reports cached tokens apart", recordBody("anthropic.messages", "claude-3-opus",
+			`"promptTokens":1000,"completionTokens":1000,"cacheReadTokens":2000,"cacheWriteTokens":1000`), 0.0545},
+		// 2000 of the 3000 prompt tokens read from the cache: 0.01 + 0.002, and 0.03 for the completion
+		{"openai counts cached tokens in the prompt", chatBody(`"promptTokens":3000,"completionTokens":1000,"cacheReadTokens":2000`), 0.042},
+		// Without cache prices the cached tokens cost the prompt price, the same as without a cache
+		{"gemini without cache prices", recordBody("gemini.generate", "gemini-pro",
+			`"promptTokens":3000,"completionTokens":1000,"cacheReadTokens":2000`), 0.06},
+		{"no cached tokens", chatBody(`"promptTokens":3000,"completionTokens":1000`), 0.06},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := pricedCost(t, test.body); !closeTo(got, test.want) {
+				t.Errorf("usageCost = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestProviderCost(t *testing.T) {
+	withPricing(t, &cost.PricingModel{
+		Chat:       map[string]cost.ChatPricing{"gemini-1.5-pro": {PromptPrice: 1, CompletionPrice: 1}},
+		Embeddings: map[string]float64{"text-embedding-004": 1, "mistral-embed": 1},
+		Gemini: cost.ProviderPricing{
+			Chat:       map[string]cost.ChatPricing{"gemini-1.5-pro": {PromptPrice: 0.0035, CompletionPrice: 0.0105}},
+			Embeddings: map[string]float64{"text-embedding-004": 0.00001},
+		},
+		VertexAI: cost.ProviderPricing{
+			Chat:       map[string]cost.ChatPricing{"gemini-1.5-pro": {PromptPrice: 0.00125, CompletionPrice: 0.00375}},
+			Embeddings: map[string]float64{"textembedding-gecko": 0.0001},
+		},
+		Mistral: cost.ProviderPricing{
+			Chat:       map[string]cost.ChatPricing{"mistral-large-latest": {PromptPrice: 0.002, CompletionPrice: 0.006}},
+			Embeddings: map[string]float64{"mistral-embed": 0.0001},
+		},
+	})
+
+	tests := []struct {
+		name string
+		body []byte
+		want float64
+	}{
+		// The same model is priced from the section of the provider, not from the common chat section
+		{"gemini chat", recordBody("gemini.chat", "gemini-1.5-pro", `"promptTokens":2000,"completionTokens":1000`), 0.0175},
+		{"vertexai chat", recordBody("vertexai.chat", "gemini-1.5-pro", `"promptTokens":2000,"completionTokens":1000`), 0.00625},
+		{"gemini embed", recordBody("gemini.embed", "text-embedding-004", `"promptTokens":1000`), 0.00001},
+		{"vertexai embed", recordBody("vertexai.embed", "textembedding-gecko", `"promptTokens":2000`), 0.0002},
+		{"mistral chat", recordBody("mistral.chat", "mistral-large-latest", `"promptTokens":1000,"completionTokens":500`), 0.005},
+		{"mistral embeddings", recordBody("mistral.embeddings", "mistral-embed", `"promptTokens":3000`), 0.0003},
+		// Gemini tokens are counted at four characters per token: 8 for the prompt and 2 for the response
+		{"gemini counted from text", recordBody("gemini.generate", "gemini-1.5-pro",
+			fmt.Sprintf(`"prompt":"%s","response":"Hi there"`, strings.Repeat("a", 30))), (8*0.0035 + 2*0.0105) / 1000},
+		{"model missing from the provider section", recordBody("mistral.chat", "gemini-1.5-pro", `"promptTokens":1000,"completionTokens":1000`), 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := pricedCost(t, test.body); !closeTo(got, test.want) {
+				t.Errorf("usageCost = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
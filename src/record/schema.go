@@ -0,0 +1,39 @@
+package record
+
+// Schema returns the JSON Schema of a record pushed for the endpoint, derived from the fields of
+// Record and the validation rules of the endpoint.
+func Schema(endpoint string) map[string]interface{} {
+	rule := registry[endpoint]
+
+	properties := map[string]interface{}{}
+	for _, field := range jsonFields {
+		property := map[string]interface{}{"type": field.jsonType}
+		if field.format != "" {
+			property["format"] = field.format
+		}
+		switch {
+		case field.name == "endpoint":
+			property["const"] = endpoint
+		case labelFields[field.name]:
+			property["maxLength"] = maxLabelLength
+		case field.jsonType == "integer" || field.jsonType == "number":
+			property["minimum"] = 0
+		}
+		properties[field.name] = property
+	}
+
+	schema := map[string]interface{}{
+		"title":      endpoint,
+		"type":       "object",
+		"properties": properties,
+		"required":   append(append([]string{}, commonRequired...), rule.Required...),
+	}
+	if rule.TokensOrText {
+		schema["anyOf"] = []interface{}{
+			map[string]interface{}{"required": []string{"promptTokens", "completionTokens"}},
+			map[string]interface{}{"required": []string{"inputTokens", "outputTokens"}},
+			map[string]interface{}{"required": []string{"prompt", "response"}},
+		}
+	}
+	return schema
+}
@@ -0,0 +1,95 @@
+package record
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxLabelLength is the size of the VARCHAR columns of the data table.
+const maxLabelLength = 50
+
+// maxClockSkew is how far in the future the time of a record can be, to allow for clock differences.
+const maxClockSkew = 5 * time.Minute
+
+// labelFields are the fields stored in VARCHAR columns of the data table.
+var labelFields = map[string]bool{
+	"environment":     true,
+	"endpoint":        true,
+	"sourceLanguage":  true,
+	"applicationName": true,
+	"model":           true,
+	"finishReason":    true,
+	"region":          true,
+}
+
+// commonRequired lists the fields every record must set.
+var commonRequired = []string{"environment", "endpoint", "sourceLanguage", "applicationName"}
+
+// Validate checks the record against the rules of its endpoint and reports every invalid field.
+func (r *Record) Validate() error {
+	var invalid []FieldError
+	missing := func(field string) {
+		invalid = append(invalid, FieldError{Field: field, Message: "is required"})
+	}
+
+	for _, field := range commonRequired {
+		if !r.isSet(field) {
+			missing(field)
+		}
+	}
+
+	endpoint, supported := registry[r.Endpoint]
+	if r.Endpoint != "" && !supported {
+		invalid = append(invalid, FieldError{Field: "endpoint", Message: fmt.Sprintf("must be one of %s", strings.Join(Endpoints(), ", "))})
+	}
+	for _, field := range endpoint.Required {
+		if !r.isSet(field) {
+			missing(field)
+		}
+	}
+	if endpoint.TokensOrText && !(r.PromptTokens != nil && r.CompletionTokens != nil) && !(r.Prompt != "" && r.Response != "") {
+		invalid = append(invalid, FieldError{Field: "promptTokens", Message: "is required along with 'completionTokens', unless 'prompt' and 'response' are set"})
+	}
+
+	for _, field := range jsonFields {
+		if labelFields[field.name] && len(r.String(field.name)) > maxLabelLength {
+			invalid = append(invalid, FieldError{Field: field.name, Message: fmt.Sprintf("must be at most %d characters", maxLabelLength)})
+		}
+	}
+
+	for name, value := range map[string]*int{"completionTokens": r.CompletionTokens, "promptTokens": r.PromptTokens, "totalTokens": r.TotalTokens,
+		"cacheReadTokens": r.CacheReadTokens, "cacheWriteTokens": r.CacheWriteTokens} {
+		if value != nil && *value < 0 {
+			invalid = append(invalid, FieldError{Field: name, Message: "must not be negative"})
+		}
+	}
+	for name, value := range map[string]*float64{"requestDuration": r.RequestDuration, "usageCost": r.UsageCost} {
+		if value != nil && *value < 0 {
+			invalid = append(invalid, FieldError{Field: name, Message: "must not be negative"})
+		}
+	}
+
+	if r.Time != nil && r.Time.After(time.Now().Add(maxClockSkew)) {
+		invalid = append(invalid, FieldError{Field: "time", Message: "must not be in the future"})
+	}
+
+	if len(invalid) > 0 {
+		sortFieldErrors(invalid)
+		return &ValidationError{Status: http.StatusUnprocessableEntity, Fields: invalid}
+	}
+	return nil
+}
+
+// sortFieldErrors orders field errors like the fields of Record so the response is stable.
+func sortFieldErrors(errors []FieldError) {
+	position := map[string]int{}
+	for i, field := range jsonFields {
+		position[field.name] = i
+	}
+	sort.SliceStable(errors, func(i, j int) bool {
+		return position[errors[i].Field] < position[errors[j].Field]
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"ingester/config"
+	"ingester/cost"
+	"ingester/db"
+
+	"github.com/rs/zerolog/log"
+)
+
+// repriceUsage describes the `reprice` subcommand.
+const repriceUsage = `Usage: ingester reprice -from <time> -to <time> [-include-overrides] [-config ./config.yml]
+
+  Calculates the usage cost of the records from -from up to -to again, with the pricing
+  version in effect at the time of each record, after a pricing correction. The times are
+  RFC 3339 timestamps or dates such as 2024-01-31.
+
+  The pricing overrides are not versioned, so the records of tenants with an override are
+  skipped. With -include-overrides, they are priced with the overrides configured now.
+`
+
+// parseRepriceTime parses a time of the `reprice` subcommand, either an RFC 3339 timestamp or a date.
+func parseRepriceTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// runReprice handles the `reprice` subcommand, which recomputes the usage cost of stored records
+// without starting the server.
+func runReprice(args []string) {
+	flags := flag.NewFlagSet("reprice", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, repriceUsage) }
+	configFilePath := flags.String("config", "./config.yml", "Path to the Doku Ingester config file")
+	fromValue := flags.String("from", "", "Start of the time range to reprice, inclusive")
+	toValue := flags.String("to", "", "End of the time range to reprice, exclusive")
+	includeOverrides := flags.Bool("include-overrides", false, "Reprice the records of tenants with a pricing override with the overrides configured now")
+	flags.Parse(args)
+
+	from, fromErr := parseRepriceTime(*fromValue)
+	to, toErr := parseRepriceTime(*toValue)
+	if fromErr != nil || toErr != nil || !from.Before(to) {
+		fmt.Fprint(os.Stderr, repriceUsage)
+		os.Exit(2)
+	}
+
+	cfg, err := config.LoadConfiguration(*configFilePath)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load configuration file")
+	}
+
+	// The configured pricing is stored as a version first, so a corrected document is used right away
+	if err := cost.LoadConfiguredPricing(*cfg); err != nil {
+		log.Fatal().Err(err).Msg("Failed to load LLM pricing information")
+	}
+	if err := cost.Init(*cfg); err != nil {
+		log.Fatal().Err(err).Msg("Invalid deployment configuration")
+	}
+	if err := db.Connect(*cfg); err != nil {
+		log.Fatal().Err(err).Msg("Unable to initialize connection to the backend database")
+	}
+	if err := db.SyncPricing(); err != nil {
+		log.Fatal().Err(err).Msg("Failed to synchronize the pricing versions")
+	}
+
+	updated, err := db.RepriceRecords(from, to, *includeOverrides)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Repricing failed after updating %d records", updated)
+	}
+	log.Info().Msgf("Repriced %d records between %s and %s", updated, from.Format(time.RFC3339), to.Format(time.RFC3339))
+}